@@ -0,0 +1,162 @@
+package adal
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// DefaultInstanceDiscoveryEndpoint is the AAD endpoint used to validate authorities.
+	DefaultInstanceDiscoveryEndpoint = "https://login.microsoftonline.com/common/discovery/instance"
+
+	instanceDiscoveryAPIVersion = "1.1"
+)
+
+// InvalidAuthorityError is returned when an authority fails instance validation.
+type InvalidAuthorityError struct {
+	// Authority is the authority that was rejected.
+	Authority string
+
+	// Reason describes why the authority was rejected.
+	Reason string
+}
+
+func (e InvalidAuthorityError) Error() string {
+	return fmt.Sprintf("adal: the authority %s is not a valid Azure Active Directory or ADFS instance: %s", e.Authority, e.Reason)
+}
+
+// trustedAuthorityHosts are the public and sovereign cloud login hosts that don't require instance discovery.
+var trustedAuthorityHosts = map[string]bool{
+	"login.microsoftonline.com": true,
+	"login.chinacloudapi.cn":    true,
+	"login.microsoftonline.us":  true,
+	"login.microsoftonline.de":  true,
+	"login.windows.net":         true,
+}
+
+// AuthorityValidationOptions contains optional settings for ValidateAuthority.
+type AuthorityValidationOptions struct {
+	// InstanceDiscoveryEndpoint overrides DefaultInstanceDiscoveryEndpoint.
+	InstanceDiscoveryEndpoint string
+
+	// SkipValidation disables validation, e.g. for disconnected Azure Stack Hub stamps
+	// that can't reach the instance discovery endpoint.
+	SkipValidation bool
+}
+
+// ValidateAuthority checks that the authority in the specified OAuthConfig is a known instance.
+// Azure Active Directory authorities are validated through AAD instance discovery, unless they're
+// one of the well-known public or sovereign cloud hosts.  ADFS authorities can't be discovered
+// through AAD so their OpenID configuration document is retrieved instead.
+// If the authority is rejected an InvalidAuthorityError is returned.
+func ValidateAuthority(ctx context.Context, sender Sender, oauthConfig OAuthConfig, options *AuthorityValidationOptions) error {
+	if options == nil {
+		options = &AuthorityValidationOptions{}
+	}
+	if options.SkipValidation {
+		return nil
+	}
+	if err := validateOAuthConfig(oauthConfig); err != nil {
+		return err
+	}
+	if sender == nil {
+		return errors.New("parameter 'sender' cannot be nil")
+	}
+	authority := oauthConfig.AuthorityEndpoint
+	if IsADFS(authority.String(), "") {
+		return validateADFSAuthority(ctx, sender, authority)
+	}
+	if trustedAuthorityHosts[strings.ToLower(authority.Hostname())] {
+		return nil
+	}
+	endpoint := options.InstanceDiscoveryEndpoint
+	if endpoint == "" {
+		endpoint = DefaultInstanceDiscoveryEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	u.RawQuery = url.Values{
+		"api-version":            []string{instanceDiscoveryAPIVersion},
+		"authorization_endpoint": []string{oauthConfig.AuthorizeEndpoint.String()},
+	}.Encode()
+	result := struct {
+		TenantDiscoveryEndpoint string `json:"tenant_discovery_endpoint"`
+		Error                   string `json:"error"`
+		ErrorDescription        string `json:"error_description"`
+	}{}
+	resp, err := getAuthorityJSON(ctx, sender, u.String(), &result)
+	if err != nil {
+		return err
+	}
+	if result.Error == "invalid_instance" {
+		return InvalidAuthorityError{Authority: authority.String(), Reason: result.ErrorDescription}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("adal: instance discovery for %s failed with status %d: %s", authority.String(), resp.StatusCode, result.ErrorDescription)
+	}
+	if result.TenantDiscoveryEndpoint == "" {
+		return InvalidAuthorityError{Authority: authority.String(), Reason: "missing tenant_discovery_endpoint"}
+	}
+	return nil
+}
+
+// ADFS publishes its metadata at the well-known OpenID configuration endpoint
+func validateADFSAuthority(ctx context.Context, sender Sender, authority url.URL) error {
+	u := authority.ResolveReference(&url.URL{Path: strings.TrimSuffix(authority.Path, "/") + "/.well-known/openid-configuration"})
+	result := struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}{}
+	resp, err := getAuthorityJSON(ctx, sender, u.String(), &result)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK || result.TokenEndpoint == "" {
+		return InvalidAuthorityError{Authority: authority.String(), Reason: fmt.Sprintf("no OpenID configuration found (status %d)", resp.StatusCode)}
+	}
+	return nil
+}
+
+// sends a GET request to the specified URL and unmarshals the JSON response body, if any, into v
+func getAuthorityJSON(ctx context.Context, sender Sender, u string, v interface{}) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("adal: failed to build the authority validation request: %v", err)
+	}
+	resp, err := sender.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("adal: failed to send the authority validation request: %v", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("adal: failed to read the authority validation response: %v", err)
+	}
+	if len(strings.TrimSpace(string(b))) > 0 {
+		if err := json.Unmarshal(b, v); err != nil && resp.StatusCode == http.StatusOK {
+			return nil, fmt.Errorf("adal: failed to unmarshal the authority validation response: %v", err)
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,188 @@
+package adal
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+func TestValidateAuthorityTrustedHost(t *testing.T) {
+	config, err := NewOAuthConfig("https://login.microsoftonline.com/", "tenant")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// no responses are queued so any request fails
+	s := mocks.NewSender()
+	if err := ValidateAuthority(context.Background(), s, *config, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Attempts() != 0 {
+		t.Fatalf("unexpected request for a trusted host")
+	}
+}
+
+func TestValidateAuthorityInstanceDiscovery(t *testing.T) {
+	config, err := NewOAuthConfig("https://login.contoso.com/", "tenant")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var sent *http.Request
+	s := SenderFunc(func(r *http.Request) (*http.Response, error) {
+		sent = r
+		return mocks.NewResponseWithContent(`{"tenant_discovery_endpoint": "https://login.contoso.com/tenant/v2.0/.well-known/openid-configuration", "api-version": "1.1"}`), nil
+	})
+	if err := ValidateAuthority(context.Background(), s, *config, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sent.URL.Host != "login.microsoftonline.com" || sent.URL.Path != "/common/discovery/instance" {
+		t.Fatalf("unexpected instance discovery URL %s", sent.URL)
+	}
+	if ae := sent.URL.Query().Get("authorization_endpoint"); ae != config.AuthorizeEndpoint.String() {
+		t.Fatalf("unexpected authorization_endpoint %s", ae)
+	}
+}
+
+func TestValidateAuthorityInvalidInstance(t *testing.T) {
+	config, err := NewOAuthConfig("https://login.contoso.com/", "tenant")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := mocks.NewSender()
+	s.AppendResponse(mocks.NewResponseWithBodyAndStatus(mocks.NewBody(`{"error": "invalid_instance", "error_description": "AADSTS50049: Unknown or invalid instance."}`), http.StatusBadRequest, "Bad Request"))
+	err = ValidateAuthority(context.Background(), s, *config, &AuthorityValidationOptions{InstanceDiscoveryEndpoint: "https://login.microsoftonline.us/common/discovery/instance"})
+	if _, ok := err.(InvalidAuthorityError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateAuthoritySkipValidation(t *testing.T) {
+	config, err := NewOAuthConfig("https://login.contoso.com/", "tenant")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := mocks.NewSender()
+	s.SetError(errors.New("unreachable"))
+	if err := ValidateAuthority(context.Background(), s, *config, &AuthorityValidationOptions{SkipValidation: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateAuthorityADFS(t *testing.T) {
+	config, err := NewOAuthConfigForADFS("https://adfs.local.azurestack.external/adfs/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var sent *http.Request
+	s := SenderFunc(func(r *http.Request) (*http.Response, error) {
+		sent = r
+		return mocks.NewResponseWithContent(`{"token_endpoint": "https://adfs.local.azurestack.external/adfs/oauth2/token/"}`), nil
+	})
+	if err := ValidateAuthority(context.Background(), s, *config, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u := sent.URL.String(); u != "https://adfs.local.azurestack.external/adfs/.well-known/openid-configuration" {
+		t.Fatalf("unexpected OpenID configuration URL %s", u)
+	}
+	s = SenderFunc(func(r *http.Request) (*http.Response, error) {
+		return mocks.NewResponseWithStatus("not found", http.StatusNotFound), nil
+	})
+	if _, ok := ValidateAuthority(context.Background(), s, *config, nil).(InvalidAuthorityError); !ok {
+		t.Fatal("expected an InvalidAuthorityError")
+	}
+}
+
+func TestValidateAuthoritySenderFailure(t *testing.T) {
+	config, err := NewOAuthConfig("https://login.contoso.com/", "tenant")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := SenderFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+	err = ValidateAuthority(context.Background(), s, *config, nil)
+	if err == nil {
+		t.Fatal("expected an error when the instance discovery endpoint can't be reached")
+	}
+	if errors.As(err, &InvalidAuthorityError{}) {
+		t.Fatalf("an unreachable endpoint was reported as an invalid authority: %v", err)
+	}
+}
+
+func TestServicePrincipalTokenAuthorityValidation(t *testing.T) {
+	config, err := NewOAuthConfig("https://login.contoso.com/", "tenant")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spt, err := NewServicePrincipalToken(*config, "id", "secret", "resource")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var paths []string
+	spt.SetSender(SenderFunc(func(r *http.Request) (*http.Response, error) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/common/discovery/instance" {
+			return mocks.NewResponseWithContent(`{"tenant_discovery_endpoint": "https://login.contoso.com/tenant/v2.0/.well-known/openid-configuration"}`), nil
+		}
+		return mocks.NewResponseWithContent(newTokenJSON(`"3600"`, "12345", "resource")), nil
+	}))
+	spt.SetAuthorityValidation(&AuthorityValidationOptions{})
+	for i := 0; i < 2; i++ {
+		if err = spt.Refresh(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// the authority is validated once, before the first token request
+	if len(paths) != 3 || paths[0] != "/common/discovery/instance" || paths[1] != "/tenant/oauth2/token" || paths[2] != "/tenant/oauth2/token" {
+		t.Fatalf("unexpected requests %v", paths)
+	}
+}
+
+func TestServicePrincipalTokenAuthorityValidationFailure(t *testing.T) {
+	config, err := NewOAuthConfig("https://login.contoso.com/", "tenant")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spt, err := NewServicePrincipalToken(*config, "id", "secret", "resource")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := mocks.NewSender()
+	s.AppendAndRepeatResponse(mocks.NewResponseWithBodyAndStatus(mocks.NewBody(`{"error": "invalid_instance", "error_description": "AADSTS50049: Unknown or invalid instance."}`), http.StatusBadRequest, "Bad Request"), 2)
+	spt.SetSender(s)
+	spt.SetAuthorityValidation(&AuthorityValidationOptions{})
+	if _, ok := spt.Refresh().(InvalidAuthorityError); !ok {
+		t.Fatal("expected an InvalidAuthorityError")
+	}
+	if s.Attempts() != 1 {
+		t.Fatalf("expected only the instance discovery request, got %d requests", s.Attempts())
+	}
+
+	// the bypass switch skips validation
+	s = mocks.NewSender()
+	s.AppendResponse(mocks.NewResponseWithContent(newTokenJSON(`"3600"`, "12345", "resource")))
+	spt.SetSender(s)
+	spt.SetAuthorityValidation(&AuthorityValidationOptions{SkipValidation: true})
+	if err = spt.Refresh(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Attempts() != 1 {
+		t.Fatalf("expected only the token request, got %d requests", s.Attempts())
+	}
+}
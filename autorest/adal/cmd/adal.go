@@ -33,20 +33,23 @@ const (
 	deviceMode        = "device"
 	clientSecretMode  = "secret"
 	clientCertMode    = "cert"
+	clientCertPemMode = "pem"
+	federatedMode     = "federated"
 	refreshMode       = "refresh"
 	msiDefaultMode    = "msiDefault"
 	msiClientIDMode   = "msiClientID"
 	msiResourceIDMode = "msiResourceID"
 
+	decodeCommand = "decode"
+	showCommand   = "show"
+	execCommand   = "exec"
+	serveCommand  = "serve"
+
 	activeDirectoryEndpoint = "https://login.microsoftonline.com/"
 )
 
-type option struct {
-	name  string
-	value string
-}
-
 var (
+	command  string
 	mode     string
 	resource string
 
@@ -56,18 +59,14 @@ var (
 
 	applicationSecret string
 	certificatePath   string
+	assertionPath     string
 
 	tokenCachePath string
+	output         string
+	injection      string
+	listenAddress  string
 )
 
-func checkMandatoryOptions(mode string, options ...option) {
-	for _, option := range options {
-		if strings.TrimSpace(option.value) == "" {
-			log.Fatalf("Authentication mode '%s' requires mandatory option '%s'.", mode, option.name)
-		}
-	}
-}
-
 func defaultTokenCachePath() string {
 	usr, err := user.Current()
 	if err != nil {
@@ -78,63 +77,73 @@ func defaultTokenCachePath() string {
 }
 
 func init() {
-	flag.StringVar(&mode, "mode", "device", "authentication mode (device, secret, cert, refresh)")
+	flag.StringVar(&mode, "mode", "device", "authentication mode ("+strings.Join(modeNames(), ", ")+")")
 	flag.StringVar(&resource, "resource", "", "resource for which the token is requested")
 	flag.StringVar(&tenantID, "tenantId", "", "tenant id")
 	flag.StringVar(&applicationID, "applicationId", "", "application id")
 	flag.StringVar(&applicationSecret, "secret", "", "application secret")
-	flag.StringVar(&certificatePath, "certificatePath", "", "path to pk12/PFC application certificate")
+	flag.StringVar(&certificatePath, "certificatePath", "", "path to pk12/PFX or PEM application certificate")
+	flag.StringVar(&assertionPath, "assertionPath", "", "path to the federated token assertion, or - to read it from stdin")
 	flag.StringVar(&tokenCachePath, "tokenCachePath", defaultTokenCachePath(), "location of oath token cache")
 	flag.StringVar(&identityResourceID, "identityResourceID", "", "managedIdentity azure resource id")
+	flag.StringVar(&output, "output", "", "output format; table or json for the decode and show commands, raw, json or export to print an acquired token")
+	flag.StringVar(&injection, "inject", envInjection, "how the exec command passes the token to the command (env, file)")
+	flag.StringVar(&listenAddress, "listen", defaultListenAddress, "address the serve command listens on")
+	flag.Usage = func() {
+		w := flag.CommandLine.Output()
+		switch command {
+		case decodeCommand, showCommand:
+			fmt.Fprintf(w, "Usage: %s %s [options]\n\n", os.Args[0], command)
+			fmt.Fprintf(w, "Prints the claims of the token at -tokenCachePath, or of every token in it if it's a directory.\n\n")
+		case execCommand:
+			fmt.Fprintf(w, "Usage: %s exec [options] -- command [args]\n\n", os.Args[0])
+			fmt.Fprintf(w, "Acquires a token and runs the command with it in %s, or with its path in %s when -inject is file.\n", accessTokenEnv, accessTokenFileEnv)
+			fmt.Fprintf(w, "The token isn't saved to -tokenCachePath.\n\n")
+			printModes(w)
+		case serveCommand:
+			fmt.Fprintf(w, "Usage: %s serve [options]\n\n", os.Args[0])
+			fmt.Fprintf(w, "Serves tokens for any resource from a managed identity compatible endpoint at http://<listen>%s.\n", imdsTokenPath)
+			fmt.Fprintf(w, "-resource is only required by the device mode.\n\n")
+			printModes(w)
+		default:
+			fmt.Fprintf(w, "Usage: %s [decode|show|exec|serve] [options] [-- command [args]]\n\n", os.Args[0])
+			fmt.Fprintf(w, "Without a command a token is acquired with the specified mode and saved to -tokenCachePath.\n")
+			fmt.Fprintf(w, "Use %s <command> -help for the usage of a command.\n\n", os.Args[0])
+			printModes(w)
+		}
+		fmt.Fprintf(w, "Options:\n")
+		flag.PrintDefaults()
+	}
+}
 
-	flag.Parse()
+// parses the command and the options, exiting on invalid ones
+func parseArgs(args []string) {
+	if len(args) > 0 && (args[0] == decodeCommand || args[0] == showCommand || args[0] == execCommand || args[0] == serveCommand) {
+		command, args = args[0], args[1:]
+	}
+	// errors are handled by the ExitOnError policy of the default flag set
+	_ = flag.CommandLine.Parse(args)
 
-	switch mode = strings.TrimSpace(mode); mode {
-	case msiDefaultMode:
-		checkMandatoryOptions(msiDefaultMode,
-			option{name: "resource", value: resource},
-			option{name: "tenantId", value: tenantID},
-		)
-	case msiClientIDMode:
-		checkMandatoryOptions(msiClientIDMode,
-			option{name: "resource", value: resource},
-			option{name: "tenantId", value: tenantID},
-			option{name: "applicationId", value: applicationID},
-		)
-	case msiResourceIDMode:
-		checkMandatoryOptions(msiResourceIDMode,
-			option{name: "resource", value: resource},
-			option{name: "tenantId", value: tenantID},
-			option{name: "identityResourceID", value: identityResourceID},
-		)
-	case clientSecretMode:
-		checkMandatoryOptions(clientSecretMode,
-			option{name: "resource", value: resource},
-			option{name: "tenantId", value: tenantID},
-			option{name: "applicationId", value: applicationID},
-			option{name: "secret", value: applicationSecret},
-		)
-	case clientCertMode:
-		checkMandatoryOptions(clientCertMode,
-			option{name: "resource", value: resource},
-			option{name: "tenantId", value: tenantID},
-			option{name: "applicationId", value: applicationID},
-			option{name: "certificatePath", value: certificatePath},
-		)
-	case deviceMode:
-		checkMandatoryOptions(deviceMode,
-			option{name: "resource", value: resource},
-			option{name: "tenantId", value: tenantID},
-			option{name: "applicationId", value: applicationID},
-		)
-	case refreshMode:
-		checkMandatoryOptions(refreshMode,
-			option{name: "resource", value: resource},
-			option{name: "tenantId", value: tenantID},
-			option{name: "applicationId", value: applicationID},
-		)
+	if command == decodeCommand || command == showCommand {
+		return
+	}
+
+	switch output {
+	case "", rawOutput, jsonOutput, exportOutput:
 	default:
-		log.Fatalln("Authentication modes 'secret, 'cert', 'device' or 'refresh' are supported.")
+		log.Fatalf("Output format '%s' is not supported, use '%s', '%s' or '%s'.", output, rawOutput, jsonOutput, exportOutput)
+	}
+	if command == execCommand && injection != envInjection && injection != fileInjection {
+		log.Fatalf("Token injection '%s' is not supported, use '%s' or '%s'.", injection, envInjection, fileInjection)
+	}
+
+	m, ok := lookupMode(strings.TrimSpace(mode))
+	if !ok {
+		log.Fatalf("Authentication mode '%s' is not supported, use one of %s.", mode, strings.Join(modeNames(), ", "))
+	}
+	mode = m.name
+	if err := m.validate(); err != nil {
+		log.Fatalf("%v. Use -help for the options of each mode.", err)
 	}
 }
 
@@ -166,6 +175,19 @@ func acquireTokenMSIFlow(applicationID string,
 	resource string,
 	callbacks ...adal.TokenRefreshCallback) (*adal.ServicePrincipalToken, error) {
 
+	spt, err := newMSIToken(applicationID, identityResourceID, resource, callbacks...)
+	if err != nil {
+		return nil, err
+	}
+
+	return spt, spt.Refresh()
+}
+
+func newMSIToken(applicationID string,
+	identityResourceID string,
+	resource string,
+	callbacks ...adal.TokenRefreshCallback) (*adal.ServicePrincipalToken, error) {
+
 	// only one of them can be present:
 	if applicationID != "" && identityResourceID != "" {
 		return nil, fmt.Errorf("didn't expect applicationID and identityResourceID at same time")
@@ -190,6 +212,26 @@ func acquireTokenMSIFlow(applicationID string,
 		spt, err = adal.NewServicePrincipalTokenFromMSIWithIdentityResourceID(msiEndpoint, resource, identityResourceID, callbacks...)
 	}
 
+	return spt, err
+}
+
+func acquireTokenClientCertFlow(oauthConfig adal.OAuthConfig,
+	applicationID string,
+	resource string,
+	callbacks ...adal.TokenRefreshCallback) (*adal.ServicePrincipalToken, error) {
+
+	certificate, rsaPrivateKey, err := loadCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	spt, err := adal.NewServicePrincipalTokenFromCertificate(
+		oauthConfig,
+		applicationID,
+		certificate,
+		rsaPrivateKey,
+		resource,
+		callbacks...)
 	if err != nil {
 		return nil, err
 	}
@@ -197,27 +239,20 @@ func acquireTokenMSIFlow(applicationID string,
 	return spt, spt.Refresh()
 }
 
-func acquireTokenClientCertFlow(oauthConfig adal.OAuthConfig,
+func acquireTokenFederatedFlow(oauthConfig adal.OAuthConfig,
 	applicationID string,
-	applicationCertPath string,
 	resource string,
 	callbacks ...adal.TokenRefreshCallback) (*adal.ServicePrincipalToken, error) {
 
-	certData, err := os.ReadFile(certificatePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read the certificate file (%s): %v", certificatePath, err)
-	}
-
-	certificate, rsaPrivateKey, err := decodePkcs12(certData, "")
+	jwtCallback, err := assertionCallback()
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode pkcs12 certificate while creating spt: %v", err)
+		return nil, err
 	}
 
-	spt, err := adal.NewServicePrincipalTokenFromCertificate(
+	spt, err := adal.NewServicePrincipalTokenFromFederatedTokenCallback(
 		oauthConfig,
 		applicationID,
-		certificate,
-		rsaPrivateKey,
+		jwtCallback,
 		resource,
 		callbacks...)
 	if err != nil {
@@ -242,7 +277,8 @@ func acquireTokenDeviceCodeFlow(oauthConfig adal.OAuthConfig,
 		return nil, fmt.Errorf("Failed to start device auth flow: %s", err)
 	}
 
-	fmt.Println(*deviceCode.Message)
+	// stdout is reserved for the token output
+	fmt.Fprintln(os.Stderr, *deviceCode.Message)
 
 	token, err := adal.WaitForUserCompletion(oauthClient, deviceCode)
 	if err != nil {
@@ -294,66 +330,110 @@ func saveToken(spt adal.Token) error {
 	return fmt.Errorf("empty path for token cache")
 }
 
-func main() {
-	oauthConfig, err := adal.NewOAuthConfig(activeDirectoryEndpoint, tenantID)
-	if err != nil {
-		panic(err)
-	}
-
+// acquires a token with the configured mode and saves it to tokenCachePath.
+// the exec command only passes the token to the command so it isn't saved.
+func acquireToken(oauthConfig adal.OAuthConfig) (*adal.ServicePrincipalToken, error) {
 	callback := func(token adal.Token) error {
+		if command == execCommand {
+			return nil
+		}
 		return saveToken(token)
 	}
 
 	log.Printf("Authenticating with mode '%s'\n", mode)
 	switch mode {
 	case clientSecretMode:
-		_, err = acquireTokenClientSecretFlow(
-			*oauthConfig,
+		return acquireTokenClientSecretFlow(
+			oauthConfig,
 			applicationID,
 			applicationSecret,
 			resource,
 			callback)
-	case clientCertMode:
-		_, err = acquireTokenClientCertFlow(
-			*oauthConfig,
+	case clientCertMode, clientCertPemMode:
+		return acquireTokenClientCertFlow(
+			oauthConfig,
+			applicationID,
+			resource,
+			callback)
+	case federatedMode:
+		return acquireTokenFederatedFlow(
+			oauthConfig,
 			applicationID,
-			certificatePath,
 			resource,
 			callback)
 	case deviceMode:
-		var spt *adal.ServicePrincipalToken
-		spt, err = acquireTokenDeviceCodeFlow(
-			*oauthConfig,
+		spt, err := acquireTokenDeviceCodeFlow(
+			oauthConfig,
 			applicationID,
 			resource,
 			callback)
 		if err == nil {
-			err = saveToken(spt.Token())
+			err = callback(spt.Token())
 		}
+		return spt, err
 	case msiResourceIDMode:
 		fallthrough
 	case msiClientIDMode:
 		fallthrough
 	case msiDefaultMode:
-		var spt *adal.ServicePrincipalToken
-		spt, err = acquireTokenMSIFlow(
+		spt, err := acquireTokenMSIFlow(
 			applicationID,
 			identityResourceID,
 			resource,
 			callback)
 		if err == nil {
-			err = saveToken(spt.Token())
+			err = callback(spt.Token())
 		}
+		return spt, err
 	case refreshMode:
-		_, err = refreshToken(
-			*oauthConfig,
+		return refreshToken(
+			oauthConfig,
 			applicationID,
 			resource,
 			tokenCachePath,
 			callback)
 	}
+	return nil, fmt.Errorf("unsupported authentication mode '%s'", mode)
+}
+
+func main() {
+	parseArgs(os.Args[1:])
+	switch command {
+	case decodeCommand, showCommand:
+		if output == "" {
+			output = tableOutput
+		}
+		if err := decodeTokens(os.Stdout, tokenCachePath, output); err != nil {
+			log.Fatalf("Failed to decode tokens. Error: %v", err)
+		}
+		return
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(activeDirectoryEndpoint, tenantID)
+	if err != nil {
+		panic(err)
+	}
+
+	if command == serveCommand {
+		log.Fatal(serveTokens(*oauthConfig, listenAddress))
+	}
 
+	spt, err := acquireToken(*oauthConfig)
 	if err != nil {
 		log.Fatalf("Failed to acquire a token for resource %s. Error: %v", resource, err)
 	}
+
+	if output != "" {
+		if err := writeToken(os.Stdout, spt.Token(), output); err != nil {
+			log.Fatalf("Failed to write the token. Error: %v", err)
+		}
+	}
+
+	if command == execCommand {
+		code, err := execWithToken(spt.Token(), flag.Args(), injection)
+		if err != nil {
+			log.Fatalf("Failed to run the command. Error: %v", err)
+		}
+		os.Exit(code)
+	}
 }
@@ -0,0 +1,174 @@
+package main
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	tableOutput = "table"
+	jsonOutput  = "json"
+)
+
+// tokenInfo describes a cached token and the claims of its access token
+type tokenInfo struct {
+	Path      string     `json:"path"`
+	Resource  string     `json:"resource,omitempty"`
+	Audience  string     `json:"audience,omitempty"`
+	TenantID  string     `json:"tenantId,omitempty"`
+	ObjectID  string     `json:"objectId,omitempty"`
+	Roles     []string   `json:"roles,omitempty"`
+	ExpiresOn *time.Time `json:"expiresOn,omitempty"`
+	Remaining string     `json:"remaining,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// loads the token at the specified path and decodes the claims of its access token.
+// failures are recorded in the returned tokenInfo; the error is returned if the token couldn't be loaded.
+func inspectToken(path string, now time.Time) (tokenInfo, error) {
+	info := tokenInfo{Path: path}
+	token, err := adal.LoadToken(path)
+	if err != nil {
+		info.Error = err.Error()
+		return info, err
+	}
+	info.Resource = token.Resource
+	if token.ExpiresOn != "" {
+		expiresOn := token.Expires()
+		info.ExpiresOn = &expiresOn
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token.AccessToken, claims); err != nil {
+		// not every access token is a JWT, e.g. MSA tokens are opaque
+		info.Error = fmt.Sprintf("failed to decode the access token: %v", err)
+	} else {
+		info.Audience = claimString(claims, "aud")
+		info.TenantID = claimString(claims, "tid")
+		info.ObjectID = claimString(claims, "oid")
+		if roles, ok := claims["roles"].([]interface{}); ok {
+			for _, role := range roles {
+				info.Roles = append(info.Roles, fmt.Sprint(role))
+			}
+		}
+		if exp, ok := claims["exp"].(float64); ok {
+			expiresOn := time.Unix(int64(exp), 0).UTC()
+			info.ExpiresOn = &expiresOn
+		}
+	}
+	if info.ExpiresOn != nil {
+		if remaining := info.ExpiresOn.Sub(now); remaining > 0 {
+			info.Remaining = remaining.Truncate(time.Second).String()
+		} else {
+			info.Remaining = "expired"
+		}
+	}
+	return info, nil
+}
+
+// returns the claim as a string, joining audiences specified as an array
+func claimString(claims jwt.MapClaims, name string) string {
+	switch v := claims[name].(type) {
+	case string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			values = append(values, fmt.Sprint(value))
+		}
+		return strings.Join(values, ",")
+	}
+	return ""
+}
+
+// returns the token files at the specified path; every regular file in a directory is considered a token
+func tokenFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+func writeTokenInfos(w io.Writer, infos []tokenInfo, output string) error {
+	if output == jsonOutput {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(infos)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tAUDIENCE\tTENANT\tOBJECT ID\tROLES\tEXPIRES ON\tREMAINING\tERROR")
+	for _, info := range infos {
+		expiresOn := ""
+		if info.ExpiresOn != nil {
+			expiresOn = info.ExpiresOn.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", info.Path, info.Audience, info.TenantID, info.ObjectID,
+			strings.Join(info.Roles, ","), expiresOn, info.Remaining, info.Error)
+	}
+	return tw.Flush()
+}
+
+// decodeTokens prints the claims of the token at tokenCachePath, or of every token in it if it's a directory.
+// It returns an error if any of the tokens couldn't be loaded.
+func decodeTokens(w io.Writer, path, output string) error {
+	if output != tableOutput && output != jsonOutput {
+		return fmt.Errorf("unsupported output format '%s', use '%s' or '%s'", output, tableOutput, jsonOutput)
+	}
+	files, err := tokenFiles(path)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	infos := make([]tokenInfo, 0, len(files))
+	invalid := 0
+	for _, file := range files {
+		info, err := inspectToken(file, now)
+		if err != nil {
+			invalid++
+		}
+		infos = append(infos, info)
+	}
+	if err := writeTokenInfos(w, infos, output); err != nil {
+		return err
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d token(s) in '%s' could not be loaded", invalid, len(files), path)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// returns a token for the resource that expires at the specified time
+func newTestToken(resource string, expiresOn time.Time) adal.Token {
+	return adal.Token{
+		AccessToken:  "token-" + resource,
+		RefreshToken: "refresh",
+		ExpiresIn:    "3600",
+		ExpiresOn:    json.Number(strconv.FormatInt(expiresOn.Unix(), 10)),
+		NotBefore:    json.Number(strconv.FormatInt(expiresOn.Add(-time.Hour).Unix(), 10)),
+		Resource:     resource,
+		Type:         "Bearer",
+	}
+}
+
+// saves a token with the specified access token at the path
+func saveTestToken(t *testing.T, path, accessToken string) {
+	token := newTestToken("https://resource", time.Now().Add(time.Hour))
+	token.AccessToken = accessToken
+	if err := adal.SaveToken(path, 0600, token); err != nil {
+		t.Fatalf("failed to save the token: %v", err)
+	}
+}
+
+func newTestJWT(t *testing.T, claims jwt.MapClaims) string {
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("failed to sign the token: %v", err)
+	}
+	return s
+}
+
+func TestInspectToken(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token.json")
+	exp := time.Now().Add(30 * time.Minute).Unix()
+	saveTestToken(t, path, newTestJWT(t, jwt.MapClaims{
+		"aud":   []string{"https://management.azure.com/", "https://graph.microsoft.com/"},
+		"tid":   "tenant",
+		"oid":   "object",
+		"roles": []string{"reader", "writer"},
+		"exp":   exp,
+	}))
+	info, err := inspectToken(path, time.Unix(exp, 0).Add(-10*time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Audience != "https://management.azure.com/,https://graph.microsoft.com/" || info.TenantID != "tenant" || info.ObjectID != "object" {
+		t.Fatalf("unexpected claims %+v", info)
+	}
+	if strings.Join(info.Roles, ",") != "reader,writer" || info.Resource != "https://resource" || info.Error != "" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if info.ExpiresOn == nil || info.ExpiresOn.Unix() != exp || info.Remaining != "10m0s" {
+		t.Fatalf("unexpected expiry %v %s", info.ExpiresOn, info.Remaining)
+	}
+	if info, _ = inspectToken(path, time.Unix(exp, 0).Add(time.Minute)); info.Remaining != "expired" {
+		t.Fatalf("expected the token to be expired, remaining %s", info.Remaining)
+	}
+}
+
+func TestInspectTokenOpaque(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	saveTestToken(t, path, "opaque")
+	info, err := inspectToken(path, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the expiry is taken from the token when the access token can't be decoded
+	if info.Error == "" || info.ExpiresOn == nil || info.Remaining == "" || info.Remaining == "expired" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+}
+
+func TestDecodeTokens(t *testing.T) {
+	dir := t.TempDir()
+	saveTestToken(t, filepath.Join(dir, "a.json"), newTestJWT(t, jwt.MapClaims{"aud": "a", "exp": time.Now().Add(time.Hour).Unix()}))
+	saveTestToken(t, filepath.Join(dir, "b.json"), newTestJWT(t, jwt.MapClaims{"aud": "b", "exp": time.Now().Add(time.Hour).Unix()}))
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0700); err != nil {
+		t.Fatalf("failed to create the directory: %v", err)
+	}
+
+	w := &bytes.Buffer{}
+	if err := decodeTokens(w, dir, jsonOutput); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var infos []tokenInfo
+	if err := json.Unmarshal(w.Bytes(), &infos); err != nil {
+		t.Fatalf("failed to unmarshal the output: %v", err)
+	}
+	if len(infos) != 2 || infos[0].Audience != "a" || infos[1].Audience != "b" {
+		t.Fatalf("unexpected output %s", w.String())
+	}
+
+	w.Reset()
+	if err := decodeTokens(w, filepath.Join(dir, "a.json"), tableOutput); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(w.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[0], "PATH") || !strings.Contains(lines[1], "a.json") {
+		t.Fatalf("unexpected output %s", w.String())
+	}
+
+	if err := decodeTokens(w, dir, "yaml"); err == nil {
+		t.Fatal("expected an error for an unsupported output format")
+	}
+}
+
+func TestDecodeTokensInvalid(t *testing.T) {
+	dir := t.TempDir()
+	saveTestToken(t, filepath.Join(dir, "a.json"), newTestJWT(t, jwt.MapClaims{"aud": "a"}))
+	if err := os.WriteFile(filepath.Join(dir, "b.json"), []byte("not a token"), 0600); err != nil {
+		t.Fatalf("failed to write the file: %v", err)
+	}
+	w := &bytes.Buffer{}
+	err := decodeTokens(w, dir, jsonOutput)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var infos []tokenInfo
+	if err := json.Unmarshal(w.Bytes(), &infos); err != nil {
+		t.Fatalf("failed to unmarshal the output: %v", err)
+	}
+	if len(infos) != 2 || infos[0].Error != "" || infos[1].Error == "" {
+		t.Fatalf("unexpected output %s", w.String())
+	}
+	if err := decodeTokens(w, filepath.Join(dir, "missing"), jsonOutput); err == nil {
+		t.Fatal("expected an error for a missing path")
+	}
+}
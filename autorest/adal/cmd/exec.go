@@ -0,0 +1,107 @@
+package main
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+const (
+	rawOutput    = "raw"
+	exportOutput = "export"
+
+	envInjection  = "env"
+	fileInjection = "file"
+
+	accessTokenEnv     = "AZURE_ACCESS_TOKEN"
+	accessTokenFileEnv = "AZURE_ACCESS_TOKEN_FILE"
+	expiresOnEnv       = "AZURE_ACCESS_TOKEN_EXPIRES_ON"
+)
+
+// quotes the value for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// writes the acquired token in the specified output format
+func writeToken(w io.Writer, token adal.Token, output string) error {
+	switch output {
+	case rawOutput:
+		_, err := fmt.Fprintln(w, token.AccessToken)
+		return err
+	case jsonOutput:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(token)
+	case exportOutput:
+		if _, err := fmt.Fprintf(w, "export %s=%s\n", accessTokenEnv, shellQuote(token.AccessToken)); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(w, "export %s=%s\n", expiresOnEnv, shellQuote(token.Expires().Format(time.RFC3339)))
+		return err
+	}
+	return fmt.Errorf("unsupported output format '%s', use '%s', '%s' or '%s'", output, rawOutput, jsonOutput, exportOutput)
+}
+
+// runs the specified command with the token injected in its environment and returns its exit code.
+// with file injection the token is written to a temporary file that's removed when the command exits.
+func execWithToken(token adal.Token, args []string, injection string) (int, error) {
+	if len(args) == 0 {
+		return 0, errors.New("no command specified, use 'adal exec [options] -- <command> [args]'")
+	}
+	env := append(os.Environ(), fmt.Sprintf("%s=%s", expiresOnEnv, token.Expires().Format(time.RFC3339)))
+	switch injection {
+	case envInjection:
+		env = append(env, fmt.Sprintf("%s=%s", accessTokenEnv, token.AccessToken))
+	case fileInjection:
+		f, err := os.CreateTemp("", "adal-token")
+		if err != nil {
+			return 0, fmt.Errorf("failed to create the token file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		_, err = f.WriteString(token.AccessToken)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to write the token file: %v", err)
+		}
+		env = append(env, fmt.Sprintf("%s=%s", accessTokenFileEnv, f.Name()))
+	default:
+		return 0, fmt.Errorf("unsupported token injection '%s', use '%s' or '%s'", injection, envInjection, fileInjection)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, err
+	}
+	return 0, nil
+}
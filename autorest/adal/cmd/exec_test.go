@@ -0,0 +1,103 @@
+package main
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runs the shell script with the token and returns what it wrote to the file passed as $0
+func execTestScript(t *testing.T, script, injection string) (string, int) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	code, err := execWithToken(newTestToken("https://resource", time.Unix(1700000000, 0)), []string{"sh", "-c", script, out}, injection)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed to read the output: %v", err)
+	}
+	return string(b), code
+}
+
+func TestExecWithTokenEnv(t *testing.T) {
+	out, code := execTestScript(t, `printf '%s\n%s\n%s' "$AZURE_ACCESS_TOKEN" "$AZURE_ACCESS_TOKEN_EXPIRES_ON" "$AZURE_ACCESS_TOKEN_FILE" > "$0"`, envInjection)
+	if code != 0 {
+		t.Fatalf("unexpected exit code %d", code)
+	}
+	expected := "token-https://resource\n" + time.Unix(1700000000, 0).Format(time.RFC3339) + "\n"
+	if out != expected {
+		t.Fatalf("unexpected environment %q", out)
+	}
+}
+
+func TestExecWithTokenFile(t *testing.T) {
+	out, code := execTestScript(t, `printf '%s\n%s\n' "$AZURE_ACCESS_TOKEN" "$AZURE_ACCESS_TOKEN_FILE" > "$0" && cat "$AZURE_ACCESS_TOKEN_FILE" >> "$0"`, fileInjection)
+	if code != 0 {
+		t.Fatalf("unexpected exit code %d", code)
+	}
+	lines := strings.Split(out, "\n")
+	if len(lines) != 3 || lines[0] != "" || lines[1] == "" || lines[2] != "token-https://resource" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if _, err := os.Stat(lines[1]); !os.IsNotExist(err) {
+		t.Fatalf("expected the token file to be removed, got %v", err)
+	}
+}
+
+func TestExecWithTokenExitCode(t *testing.T) {
+	if _, code := execTestScript(t, `touch "$0"; exit 3`, envInjection); code != 3 {
+		t.Fatalf("unexpected exit code %d", code)
+	}
+}
+
+func TestExecWithTokenInvalid(t *testing.T) {
+	token := newTestToken("https://resource", time.Now())
+	if _, err := execWithToken(token, nil, envInjection); err == nil {
+		t.Fatal("expected an error without a command")
+	}
+	if _, err := execWithToken(token, []string{"true"}, "stdin"); err == nil {
+		t.Fatal("expected an error for an unsupported injection")
+	}
+}
+
+func TestWriteToken(t *testing.T) {
+	token := newTestToken("https://resource", time.Unix(1700000000, 0))
+	token.AccessToken = "it's"
+	w := &bytes.Buffer{}
+	if err := writeToken(w, token, exportOutput); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "export AZURE_ACCESS_TOKEN='it'\\''s'\nexport AZURE_ACCESS_TOKEN_EXPIRES_ON='" + time.Unix(1700000000, 0).Format(time.RFC3339) + "'\n"
+	if w.String() != expected {
+		t.Fatalf("unexpected output %q", w.String())
+	}
+	w.Reset()
+	if err := writeToken(w, token, rawOutput); err != nil || w.String() != "it's\n" {
+		t.Fatalf("unexpected output %q, error %v", w.String(), err)
+	}
+	if err := writeToken(w, token, "yaml"); err == nil {
+		t.Fatal("expected an error for an unsupported output format")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// authMode describes an authentication mode and the options it requires
+type authMode struct {
+	name        string
+	description string
+	required    []string
+}
+
+var authModes = []authMode{
+	{
+		name:        deviceMode,
+		description: "device code flow, prints a code to enter in a browser",
+		required:    []string{"resource", "tenantId", "applicationId"},
+	},
+	{
+		name:        clientSecretMode,
+		description: "client credentials with an application secret",
+		required:    []string{"resource", "tenantId", "applicationId", "secret"},
+	},
+	{
+		name:        clientCertMode,
+		description: "client credentials with a PFX certificate without a password",
+		required:    []string{"resource", "tenantId", "applicationId", "certificatePath"},
+	},
+	{
+		name:        clientCertPemMode,
+		description: "client credentials with a PEM file containing the certificate and its unencrypted private key",
+		required:    []string{"resource", "tenantId", "applicationId", "certificatePath"},
+	},
+	{
+		name:        federatedMode,
+		description: "client credentials with a federated token assertion, e.g. a Kubernetes service account token; the file is read again on every refresh",
+		required:    []string{"resource", "tenantId", "applicationId", "assertionPath"},
+	},
+	{
+		name:        refreshMode,
+		description: "refreshes the token saved at -tokenCachePath",
+		required:    []string{"resource", "tenantId", "applicationId"},
+	},
+	{
+		name:        msiDefaultMode,
+		description: "system-assigned managed identity",
+		required:    []string{"resource", "tenantId"},
+	},
+	{
+		name:        msiClientIDMode,
+		description: "user-assigned managed identity with the client id in -applicationId",
+		required:    []string{"resource", "tenantId", "applicationId"},
+	},
+	{
+		name:        msiResourceIDMode,
+		description: "user-assigned managed identity with the azure resource id in -identityResourceID",
+		required:    []string{"resource", "tenantId", "identityResourceID"},
+	},
+}
+
+// returns the authentication mode with the specified name
+func lookupMode(name string) (authMode, bool) {
+	for _, m := range authModes {
+		if m.name == name {
+			return m, true
+		}
+	}
+	return authMode{}, false
+}
+
+// returns the names of the authentication modes
+func modeNames() []string {
+	names := make([]string, 0, len(authModes))
+	for _, m := range authModes {
+		names = append(names, m.name)
+	}
+	return names
+}
+
+// returns an error listing the required options of the mode that weren't specified
+func (m authMode) validate() error {
+	var missing []string
+	for _, name := range m.required {
+		// the serve command receives the resource with each request; the device flow needs one to start
+		if name == "resource" && command == serveCommand && m.name != deviceMode {
+			continue
+		}
+		if strings.TrimSpace(flag.Lookup(name).Value.String()) == "" {
+			missing = append(missing, "-"+name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("authentication mode '%s' requires %s", m.name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// prints the authentication modes and their required options
+func printModes(w io.Writer) {
+	fmt.Fprintf(w, "Authentication modes (-mode):\n")
+	for _, m := range authModes {
+		required := make([]string, 0, len(m.required))
+		for _, name := range m.required {
+			required = append(required, "-"+name)
+		}
+		fmt.Fprintf(w, "  %s\n    \t%s\n    \trequires %s\n", m.name, m.description, strings.Join(required, ", "))
+	}
+	fmt.Fprintln(w)
+}
+
+// loads the certificate at certificatePath for the cert and pem modes
+func loadCertificate() (*x509.Certificate, *rsa.PrivateKey, error) {
+	certData, err := os.ReadFile(certificatePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the certificate file (%s): %v", certificatePath, err)
+	}
+	if mode == clientCertPemMode {
+		certificate, rsaPrivateKey, err := adal.DecodePemCertificateData(certData)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode PEM certificate while creating spt: %v", err)
+		}
+		return certificate, rsaPrivateKey, nil
+	}
+	certificate, rsaPrivateKey, err := decodePkcs12(certData, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode pkcs12 certificate while creating spt: %v", err)
+	}
+	return certificate, rsaPrivateKey, nil
+}
+
+// returns a callback providing the assertion at assertionPath for the federated mode.
+// a path of - reads the assertion from stdin once, files are read again on every refresh.
+func assertionCallback() (adal.JWTCallback, error) {
+	if assertionPath == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the assertion from stdin: %v", err)
+		}
+		assertion := string(bytes.TrimSpace(b))
+		if assertion == "" {
+			return nil, errors.New("the assertion read from stdin is empty")
+		}
+		return func() (string, error) {
+			return assertion, nil
+		}, nil
+	}
+	return func() (string, error) {
+		b, err := os.ReadFile(assertionPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the assertion file (%s): %v", assertionPath, err)
+		}
+		return string(bytes.TrimSpace(b)), nil
+	}, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestAuthModeValidate(t *testing.T) {
+	options := []string{"resource", "tenantId", "applicationId", "secret", "certificatePath", "assertionPath", "identityResourceID"}
+	testCases := []struct {
+		mode    string
+		command string
+		options map[string]string
+		missing string
+	}{
+		{deviceMode, "", map[string]string{"resource": "r", "tenantId": "t", "applicationId": "a"}, ""},
+		{deviceMode, serveCommand, map[string]string{"tenantId": "t", "applicationId": "a"}, "-resource"},
+		{clientSecretMode, "", map[string]string{"resource": "r", "tenantId": "t", "applicationId": "a"}, "-secret"},
+		{clientSecretMode, "", map[string]string{"resource": "r", "tenantId": "t", "applicationId": "a", "secret": " "}, "-secret"},
+		{clientSecretMode, serveCommand, map[string]string{"tenantId": "t", "applicationId": "a", "secret": "s"}, ""},
+		{clientCertMode, "", map[string]string{"resource": "r", "tenantId": "t"}, "-applicationId, -certificatePath"},
+		{clientCertPemMode, execCommand, map[string]string{"resource": "r", "tenantId": "t", "applicationId": "a", "certificatePath": "c"}, ""},
+		{federatedMode, "", map[string]string{"resource": "r", "tenantId": "t", "applicationId": "a"}, "-assertionPath"},
+		{federatedMode, serveCommand, map[string]string{"tenantId": "t", "applicationId": "a", "assertionPath": "-"}, ""},
+		{refreshMode, "", map[string]string{}, "-resource, -tenantId, -applicationId"},
+		{msiDefaultMode, "", map[string]string{"resource": "r", "tenantId": "t"}, ""},
+		{msiClientIDMode, "", map[string]string{"resource": "r", "tenantId": "t"}, "-applicationId"},
+		{msiResourceIDMode, serveCommand, map[string]string{"tenantId": "t"}, "-identityResourceID"},
+	}
+	defer func(c string) { command = c }(command)
+	for _, tc := range testCases {
+		t.Run(tc.mode+" "+tc.command, func(t *testing.T) {
+			for _, name := range options {
+				if err := flag.Set(name, tc.options[name]); err != nil {
+					t.Fatalf("failed to set -%s: %v", name, err)
+				}
+			}
+			defer func() {
+				for _, name := range options {
+					_ = flag.Set(name, "")
+				}
+			}()
+			command = tc.command
+			m, ok := lookupMode(tc.mode)
+			if !ok {
+				t.Fatalf("mode %s not found", tc.mode)
+			}
+			err := m.validate()
+			if tc.missing == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasSuffix(err.Error(), "requires "+tc.missing) {
+				t.Fatalf("expected %s to be missing, got %v", tc.missing, err)
+			}
+		})
+	}
+}
+
+func TestLookupMode(t *testing.T) {
+	for _, name := range modeNames() {
+		if m, ok := lookupMode(name); !ok || m.name != name || len(m.required) == 0 {
+			t.Fatalf("unexpected mode %v", m)
+		}
+	}
+	if _, ok := lookupMode("password"); ok {
+		t.Fatal("expected an unsupported mode not to be found")
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+const (
+	imdsTokenPath = "/metadata/identity/oauth2/token"
+
+	defaultListenAddress = "localhost:50342"
+)
+
+// tokenFactory creates a token for the specified resource; the token isn't acquired until it's used
+type tokenFactory func(resource string) (*adal.ServicePrincipalToken, error)
+
+// tokenServer serves tokens from an IMDS-compatible endpoint.  a token is created per resource on
+// first use and is refreshed when it's about to expire.
+type tokenServer struct {
+	factory tokenFactory
+
+	mu     sync.Mutex
+	tokens map[string]*adal.ServicePrincipalToken
+}
+
+func newTokenServer(factory tokenFactory) *tokenServer {
+	return &tokenServer{
+		factory: factory,
+		tokens:  map[string]*adal.ServicePrincipalToken{},
+	}
+}
+
+// returns the cached token for the resource, creating it on first use.  the token is created without
+// holding the lock as the factory can make network calls, the first token cached for a resource wins.
+func (ts *tokenServer) token(resource string) (*adal.ServicePrincipalToken, error) {
+	ts.mu.Lock()
+	spt, ok := ts.tokens[resource]
+	ts.mu.Unlock()
+	if ok {
+		return spt, nil
+	}
+	spt, err := ts.factory(resource)
+	if err != nil {
+		return nil, err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if cached, ok := ts.tokens[resource]; ok {
+		return cached, nil
+	}
+	ts.tokens[resource] = spt
+	return spt, nil
+}
+
+// writes an error in the format returned by IMDS
+func writeIMDSError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func (ts *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeIMDSError(w, http.StatusMethodNotAllowed, "invalid_request", "Only GET is supported")
+		return
+	}
+	// IMDS rejects requests without the header to prevent server-side request forgery
+	if !strings.EqualFold(r.Header.Get("Metadata"), "true") {
+		writeIMDSError(w, http.StatusBadRequest, "invalid_request", "Required metadata header not specified")
+		return
+	}
+	resource := r.URL.Query().Get("resource")
+	if resource == "" {
+		writeIMDSError(w, http.StatusBadRequest, "invalid_request", "Required audience parameter not specified")
+		return
+	}
+	spt, err := ts.token(resource)
+	if err == nil {
+		err = spt.EnsureFreshWithContext(r.Context())
+	}
+	if err != nil {
+		log.Printf("Failed to acquire a token for resource %s. Error: %v\n", resource, err)
+		status := http.StatusInternalServerError
+		if tre, ok := err.(adal.TokenRefreshError); ok && tre.Response() != nil {
+			status = tre.Response().StatusCode
+		}
+		writeIMDSError(w, status, "unknown_error", fmt.Sprintf("Failed to acquire a token for resource %s", resource))
+		return
+	}
+	token := spt.Token()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	// IMDS returns every field as a string
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"access_token":  token.AccessToken,
+		"refresh_token": "",
+		"expires_in":    token.ExpiresIn.String(),
+		"expires_on":    token.ExpiresOn.String(),
+		"not_before":    token.NotBefore.String(),
+		"resource":      token.Resource,
+		"token_type":    token.Type,
+	}); err != nil {
+		log.Printf("Failed to write the token response. Error: %v\n", err)
+	}
+}
+
+// returns a tokenFactory for the configured mode
+func newTokenFactory(oauthConfig adal.OAuthConfig) (tokenFactory, error) {
+	switch mode {
+	case clientSecretMode:
+		return func(resource string) (*adal.ServicePrincipalToken, error) {
+			return adal.NewServicePrincipalToken(oauthConfig, applicationID, applicationSecret, resource)
+		}, nil
+	case clientCertMode, clientCertPemMode:
+		certificate, rsaPrivateKey, err := loadCertificate()
+		if err != nil {
+			return nil, err
+		}
+		return func(resource string) (*adal.ServicePrincipalToken, error) {
+			return adal.NewServicePrincipalTokenFromCertificate(oauthConfig, applicationID, certificate, rsaPrivateKey, resource)
+		}, nil
+	case federatedMode:
+		jwtCallback, err := assertionCallback()
+		if err != nil {
+			return nil, err
+		}
+		return func(resource string) (*adal.ServicePrincipalToken, error) {
+			return adal.NewServicePrincipalTokenFromFederatedTokenCallback(oauthConfig, applicationID, jwtCallback, resource)
+		}, nil
+	case msiDefaultMode, msiClientIDMode, msiResourceIDMode:
+		return func(resource string) (*adal.ServicePrincipalToken, error) {
+			return newMSIToken(applicationID, identityResourceID, resource)
+		}, nil
+	case deviceMode, refreshMode:
+		var base adal.Token
+		if mode == deviceMode {
+			spt, err := acquireTokenDeviceCodeFlow(oauthConfig, applicationID, resource)
+			if err != nil {
+				return nil, err
+			}
+			base = spt.Token()
+		} else {
+			token, err := adal.LoadToken(tokenCachePath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load token from cache: %v", err)
+			}
+			base = *token
+		}
+		// the refresh token is redeemed for tokens for other resources
+		return func(resource string) (*adal.ServicePrincipalToken, error) {
+			spt, err := adal.NewServicePrincipalTokenFromManualToken(oauthConfig, applicationID, resource, base)
+			if err != nil {
+				return nil, err
+			}
+			if base.Resource != resource {
+				if err := spt.Refresh(); err != nil {
+					return nil, err
+				}
+			}
+			return spt, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported authentication mode '%s'", mode)
+}
+
+// serveTokens serves tokens for the configured mode on the specified address until the server fails
+func serveTokens(oauthConfig adal.OAuthConfig, address string) error {
+	factory, err := newTokenFactory(oauthConfig)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(imdsTokenPath, newTokenServer(factory))
+	log.Printf("Serving tokens with mode '%s' on http://%s%s\n", mode, address, imdsTokenPath)
+	return http.ListenAndServe(address, mux)
+}
@@ -0,0 +1,169 @@
+package main
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+// starts a token server whose tokens are refreshed with the specified status code
+func newTestTokenServer(t *testing.T, expiresOn time.Time, refreshStatus int) (*httptest.Server, map[string]int) {
+	created := map[string]int{}
+	oauthConfig, err := adal.NewOAuthConfig(activeDirectoryEndpoint, "tenant")
+	if err != nil {
+		t.Fatalf("failed to create the OAuthConfig: %v", err)
+	}
+	factory := func(resource string) (*adal.ServicePrincipalToken, error) {
+		created[resource]++
+		spt, err := adal.NewServicePrincipalTokenFromManualToken(*oauthConfig, "id", resource, newTestToken(resource, expiresOn))
+		if err != nil {
+			return nil, err
+		}
+		spt.SetSender(adal.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			return mocks.NewResponseWithBodyAndStatus(mocks.NewBody(`{"error": "invalid_client"}`), refreshStatus, http.StatusText(refreshStatus)), nil
+		}))
+		return spt, nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle(imdsTokenPath, newTokenServer(factory))
+	return httptest.NewServer(mux), created
+}
+
+func TestTokenServerMSI(t *testing.T) {
+	ts, created := newTestTokenServer(t, time.Now().Add(time.Hour), http.StatusOK)
+	defer ts.Close()
+	for i := 0; i < 2; i++ {
+		spt, err := adal.NewServicePrincipalTokenFromMSI(ts.URL+imdsTokenPath, "https://resource")
+		if err != nil {
+			t.Fatalf("failed to create the MSI token: %v", err)
+		}
+		if err = spt.Refresh(); err != nil {
+			t.Fatalf("failed to refresh the MSI token: %v", err)
+		}
+		if token := spt.Token(); token.AccessToken != "token-https://resource" || token.Resource != "https://resource" || token.IsExpired() {
+			t.Fatalf("unexpected token %v", token)
+		}
+	}
+	if created["https://resource"] != 1 {
+		t.Fatalf("expected the token to be cached, created %d", created["https://resource"])
+	}
+}
+
+func TestTokenServerInvalidRequests(t *testing.T) {
+	ts, created := newTestTokenServer(t, time.Now().Add(time.Hour), http.StatusOK)
+	defer ts.Close()
+	testCases := []struct {
+		name     string
+		method   string
+		metadata string
+		resource string
+		status   int
+	}{
+		{"no metadata header", http.MethodGet, "", "https://resource", http.StatusBadRequest},
+		{"invalid metadata header", http.MethodGet, "false", "https://resource", http.StatusBadRequest},
+		{"no resource", http.MethodGet, "true", "", http.StatusBadRequest},
+		{"post", http.MethodPost, "true", "https://resource", http.StatusMethodNotAllowed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, fmt.Sprintf("%s%s?api-version=2018-02-01&resource=%s", ts.URL, imdsTokenPath, url.QueryEscape(tc.resource)), nil)
+			if err != nil {
+				t.Fatalf("failed to create the request: %v", err)
+			}
+			if tc.metadata != "" {
+				req.Header.Set("Metadata", tc.metadata)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("failed to send the request: %v", err)
+			}
+			defer resp.Body.Close()
+			var body map[string]string
+			if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("failed to decode the response: %v", err)
+			}
+			if resp.StatusCode != tc.status || body["error"] != "invalid_request" || body["error_description"] == "" {
+				t.Fatalf("unexpected response %d %v", resp.StatusCode, body)
+			}
+		})
+	}
+	if len(created) != 0 {
+		t.Fatalf("unexpected tokens %v", created)
+	}
+}
+
+func TestTokenServerRefreshFailure(t *testing.T) {
+	ts, _ := newTestTokenServer(t, time.Now().Add(-time.Hour), http.StatusUnauthorized)
+	defer ts.Close()
+	req, err := http.NewRequest(http.MethodGet, ts.URL+imdsTokenPath+"?resource=https%3A%2F%2Fresource", nil)
+	if err != nil {
+		t.Fatalf("failed to create the request: %v", err)
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send the request: %v", err)
+	}
+	defer resp.Body.Close()
+	var body map[string]string
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode the response: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized || body["error"] != "unknown_error" {
+		t.Fatalf("unexpected response %d %v", resp.StatusCode, body)
+	}
+}
+
+func TestTokenServerConcurrentResources(t *testing.T) {
+	oauthConfig, err := adal.NewOAuthConfig(activeDirectoryEndpoint, "tenant")
+	if err != nil {
+		t.Fatalf("failed to create the OAuthConfig: %v", err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	ts := newTokenServer(func(resource string) (*adal.ServicePrincipalToken, error) {
+		if resource == "slow" {
+			close(started)
+			<-release
+		}
+		return adal.NewServicePrincipalTokenFromManualToken(*oauthConfig, "id", resource, newTestToken(resource, time.Now().Add(time.Hour)))
+	})
+	done := make(chan error)
+	go func() {
+		_, err := ts.token("slow")
+		done <- err
+	}()
+	<-started
+	// a token for another resource isn't blocked by the one being created
+	if _, err := ts.token("fast"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ts.tokens) != 2 {
+		t.Fatalf("expected two cached tokens, got %d", len(ts.tokens))
+	}
+}
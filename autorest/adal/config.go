@@ -18,10 +18,15 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 )
 
 const (
 	activeDirectoryEndpointTemplate = "%s/oauth2/%s%s"
+
+	// ADFSTenantID is the tenant ID used when authenticating against Active Directory Federation Services,
+	// e.g. on Azure Stack Hub stamps that aren't connected to Azure Active Directory.
+	ADFSTenantID = "adfs"
 )
 
 // OAuthConfig represents the endpoints needed
@@ -94,6 +99,36 @@ func NewOAuthConfigWithAPIVersion(activeDirectoryEndpoint, tenantID string, apiV
 	}, nil
 }
 
+// IsADFS returns true if the specified Active Directory endpoint or tenant ID refers to ADFS.
+// Azure Stack Hub reports ADFS login endpoints with the adfs path segment, e.g. https://adfs.local.azurestack.external/adfs/.
+func IsADFS(activeDirectoryEndpoint, tenantID string) bool {
+	if strings.EqualFold(tenantID, ADFSTenantID) {
+		return true
+	}
+	u, err := url.Parse(activeDirectoryEndpoint)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(strings.Trim(u.Path, "/"), ADFSTenantID)
+}
+
+// NewOAuthConfigForADFS returns an OAuthConfig for the ADFS instance at the specified endpoint.
+// The endpoint can be specified with or without the adfs path segment.
+// The "api-version" query parameter isn't appended to the endpoint URLs.
+func NewOAuthConfigForADFS(activeDirectoryEndpoint string) (*OAuthConfig, error) {
+	if err := validateStringParam(activeDirectoryEndpoint, "activeDirectoryEndpoint"); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(activeDirectoryEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(strings.Trim(u.Path, "/"), ADFSTenantID) {
+		u.Path = "/"
+	}
+	return NewOAuthConfigWithAPIVersion(u.String(), ADFSTenantID, nil)
+}
+
 // MultiTenantOAuthConfig provides endpoints for primary and aulixiary tenant IDs.
 type MultiTenantOAuthConfig interface {
 	PrimaryTenant() *OAuthConfig
@@ -93,6 +93,41 @@ func TestNewOAuthConfigWithAPIVersionNotNil(t *testing.T) {
 	}
 }
 
+func TestNewOAuthConfigForADFS(t *testing.T) {
+	for _, endpoint := range []string{"https://adfs.local.azurestack.external/adfs/", "https://adfs.local.azurestack.external/adfs", "https://adfs.local.azurestack.external/"} {
+		config, err := NewOAuthConfigForADFS(endpoint)
+		if err != nil {
+			t.Fatalf("autorest/adal: Unexpected error while creating oauth configuration for ADFS: %v.", err)
+		}
+		expected := "https://adfs.local.azurestack.external/adfs/oauth2/token"
+		if config.TokenEndpoint.String() != expected {
+			t.Fatalf("autorest/adal: Incorrect token url for ADFS endpoint %s. expected(%s). actual(%v).", endpoint, expected, config.TokenEndpoint)
+		}
+	}
+	if _, err := NewOAuthConfigForADFS(""); err == nil {
+		t.Fatal("autorest/adal: expected an error for an empty endpoint")
+	}
+}
+
+func TestIsADFS(t *testing.T) {
+	testCases := []struct {
+		endpoint string
+		tenantID string
+		expected bool
+	}{
+		{"https://adfs.local.azurestack.external/adfs/", "", true},
+		{"https://adfs.local.azurestack.external/ADFS", "", true},
+		{"https://login.microsoftonline.com/", "adfs", true},
+		{"https://login.microsoftonline.com/", "tenant", false},
+		{"https://login.microsoftonline.com/adfs-tenant", "", false},
+	}
+	for _, tc := range testCases {
+		if actual := IsADFS(tc.endpoint, tc.tenantID); actual != tc.expected {
+			t.Fatalf("autorest/adal: IsADFS(%s, %s) expected %v", tc.endpoint, tc.tenantID, tc.expected)
+		}
+	}
+}
+
 func TestNewMultiTenantOAuthConfig(t *testing.T) {
 	cfg, err := NewMultiTenantOAuthConfig(TestActiveDirectoryEndpoint, TestTenantID, TestAuxTenantIDs, OAuthOptions{})
 	if err != nil {
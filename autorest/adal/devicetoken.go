@@ -165,8 +165,17 @@ func CheckForUserCompletion(sender Sender, code *DeviceCode) (*Token, error) {
 }
 
 // CheckForUserCompletionWithContext takes a DeviceCode and checks with the Azure AD OAuth endpoint
-// to see if the device flow has: been completed, timed out, or otherwise failed
+// to see if the device flow has: been completed, timed out, or otherwise failed.
+// Each check is reported to the TokenObserver in ctx, if any.
 func CheckForUserCompletionWithContext(ctx context.Context, sender Sender, code *DeviceCode) (*Token, error) {
+	observer := tokenObserverFromContext(ctx)
+	attempt := startTokenAttempt(observer, OAuthGrantTypeDeviceCode, code.Resource)
+	token, err := checkForUserCompletion(ctx, sender, code, attempt)
+	attempt.complete(observer, err, TokenErrorClassCredential)
+	return token, err
+}
+
+func checkForUserCompletion(ctx context.Context, sender Sender, code *DeviceCode, attempt *TokenAttempt) (*Token, error) {
 	v := url.Values{
 		"client_id":  []string{code.ClientID},
 		"code":       []string{*code.DeviceCode},
@@ -184,11 +193,14 @@ func CheckForUserCompletionWithContext(ctx context.Context, sender Sender, code
 
 	req.ContentLength = int64(len(s))
 	req.Header.Set(contentType, mimeTypeFormPost)
+	attempt.ErrorClass = TokenErrorClassTransport
 	resp, err := sender.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("%s %s: %s", logPrefix, errTokenSendingFails, err.Error())
 	}
 	defer resp.Body.Close()
+	attempt.StatusCode = resp.StatusCode
+	attempt.ErrorClass = TokenErrorClassResponse
 
 	rb, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -212,10 +224,13 @@ func CheckForUserCompletionWithContext(ctx context.Context, sender Sender, code
 		return &token.Token, nil
 	}
 
+	attempt.ErrorClass = TokenErrorClassHTTP
 	switch *token.Error {
 	case "authorization_pending":
+		attempt.ErrorClass = TokenErrorClassPending
 		return nil, ErrDeviceAuthorizationPending
 	case "slow_down":
+		attempt.ErrorClass = TokenErrorClassPending
 		return nil, ErrDeviceSlowDown
 	case "access_denied":
 		return nil, ErrDeviceAccessDenied
@@ -239,12 +254,19 @@ func WaitForUserCompletion(sender Sender, code *DeviceCode) (*Token, error) {
 
 // WaitForUserCompletionWithContext calls CheckForUserCompletion repeatedly until a token is granted or an error
 // state occurs.  This prevents the user from looping and checking against 'ErrDeviceAuthorizationPending'.
-func WaitForUserCompletionWithContext(ctx context.Context, sender Sender, code *DeviceCode) (*Token, error) {
+// The checks are reported to the TokenObserver in ctx, if any, as a single attempt that completes when the
+// token is granted or an error state occurs.
+func WaitForUserCompletionWithContext(ctx context.Context, sender Sender, code *DeviceCode) (token *Token, err error) {
+	observer := tokenObserverFromContext(ctx)
+	attempt := startTokenAttempt(observer, OAuthGrantTypeDeviceCode, code.Resource)
+	defer func() {
+		attempt.complete(observer, err, TokenErrorClassCredential)
+	}()
 	intervalDuration := time.Duration(*code.Interval) * time.Second
 	waitDuration := intervalDuration
 
 	for {
-		token, err := CheckForUserCompletionWithContext(ctx, sender, code)
+		token, err = checkForUserCompletion(ctx, sender, code, attempt)
 
 		if err == nil {
 			return token, nil
@@ -260,6 +282,7 @@ func WaitForUserCompletionWithContext(ctx context.Context, sender Sender, code *
 		}
 
 		if waitDuration > (intervalDuration * 3) {
+			attempt.ErrorClass = TokenErrorClassHTTP
 			return nil, fmt.Errorf("%s Error waiting for user to complete device flow. Server told us to slow_down too much", logPrefix)
 		}
 
@@ -267,6 +290,7 @@ func WaitForUserCompletionWithContext(ctx context.Context, sender Sender, code *
 		case <-time.After(waitDuration):
 			// noop
 		case <-ctx.Done():
+			attempt.ErrorClass = TokenErrorClassTransport
 			return nil, ctx.Err()
 		}
 	}
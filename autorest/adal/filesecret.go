@@ -0,0 +1,302 @@
+package adal
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/logger"
+)
+
+// the duration after a credential is reloaded during which the previous credential is tried if the
+// new one is rejected, i.e. the time it can take for a rotated credential to propagate
+const credentialFallbackWindow = 15 * time.Minute
+
+// fallbackSecret is implemented by secrets that retain the previous credential after a rotation.
+type fallbackSecret interface {
+	// fallBack makes the next call to SetAuthenticationValues use the previous credential.
+	// It returns false if there is no previous credential to fall back to.
+	fallBack() bool
+
+	// accepted is called when the token endpoint accepted the credential last used.
+	accepted()
+}
+
+// returns true if the error indicates the token endpoint rejected the credential
+func isCredentialRejected(err error) bool {
+	tre, ok := err.(TokenRefreshError)
+	if !ok || tre.Response() == nil {
+		return false
+	}
+	code := tre.Response().StatusCode
+	return code == http.StatusBadRequest || code == http.StatusUnauthorized
+}
+
+// fileCredential holds a credential read from a file.  The file is read again whenever its
+// modification time or size changes, and the previously loaded credential is retained.
+type fileCredential struct {
+	path  string
+	parse func([]byte) (interface{}, error)
+
+	mu       sync.Mutex
+	modTime  time.Time
+	size     int64
+	current  interface{}
+	lastUsed interface{}
+	// the credential before the last reload and the time of the reload
+	previous    interface{}
+	reloadedAt  time.Time
+	usePrevious bool
+}
+
+func newFileCredential(path string, parse func([]byte) (interface{}, error)) (*fileCredential, error) {
+	if err := validateStringParam(path, "path"); err != nil {
+		return nil, err
+	}
+	fc := &fileCredential{path: path, parse: parse}
+	if _, err := fc.value(); err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+// returns the credential to use, reloading the file if it has changed
+func (fc *fileCredential) value() (interface{}, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if err := fc.reload(); err != nil {
+		if fc.current == nil {
+			return nil, err
+		}
+		// the file can be briefly missing while it's being replaced so keep the last good credential
+		logger.Instance.Writef(logger.LogWarning, "adal: failed to reload credential from %s, using the previous value: %v\n", fc.path, err)
+	}
+	fc.lastUsed = fc.current
+	if fc.usePrevious {
+		fc.usePrevious = false
+		fc.lastUsed = fc.previous
+	}
+	return fc.lastUsed, nil
+}
+
+// reads and parses the file if it has changed.  the caller must hold mu.
+func (fc *fileCredential) reload() error {
+	fi, err := os.Stat(fc.path)
+	if err != nil {
+		return err
+	}
+	if fc.current != nil && fi.ModTime().Equal(fc.modTime) && fi.Size() == fc.size {
+		return nil
+	}
+	b, err := os.ReadFile(fc.path)
+	if err != nil {
+		return err
+	}
+	v, err := fc.parse(b)
+	if err != nil {
+		return fmt.Errorf("adal: failed to parse credential from %s: %v", fc.path, err)
+	}
+	if fc.current != nil {
+		logger.Instance.Writef(logger.LogInfo, "adal: reloaded credential from %s\n", fc.path)
+		fc.previous = fc.current
+		fc.reloadedAt = time.Now()
+	}
+	fc.current = v
+	fc.modTime = fi.ModTime()
+	fc.size = fi.Size()
+	fc.usePrevious = false
+	return nil
+}
+
+// falls back to the previous credential if the current one was loaded within credentialFallbackWindow,
+// otherwise the current credential is simply wrong and the previous one is dropped
+func (fc *fileCredential) fallBack() bool {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.previous == nil {
+		return false
+	}
+	if time.Since(fc.reloadedAt) > credentialFallbackWindow {
+		fc.previous = nil
+		return false
+	}
+	fc.usePrevious = true
+	return true
+}
+
+// drops the previous credential once the current one has been accepted
+func (fc *fileCredential) accepted() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.lastUsed == fc.current {
+		fc.previous = nil
+	}
+}
+
+// ServicePrincipalFileSecret implements ServicePrincipalSecret for client_secret type authorization
+// where the secret is read from a file.  The file is read again when its modification time changes.
+// If the token endpoint rejects a secret loaded within the last 15 minutes, the previous secret is tried
+// during the same refresh, which covers the overlap window while a rotated secret propagates.  The
+// previous secret is dropped once the new one is accepted.
+type ServicePrincipalFileSecret struct {
+	fc *fileCredential
+}
+
+// NewServicePrincipalFileSecret creates a ServicePrincipalFileSecret for the secret in the specified file.
+// Leading and trailing whitespace is trimmed from the file's content.
+func NewServicePrincipalFileSecret(path string) (*ServicePrincipalFileSecret, error) {
+	fc, err := newFileCredential(path, func(b []byte) (interface{}, error) {
+		secret := string(bytes.TrimSpace(b))
+		if secret == "" {
+			return nil, errors.New("the file is empty")
+		}
+		return secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ServicePrincipalFileSecret{fc: fc}, nil
+}
+
+// SetAuthenticationValues is a method of the interface ServicePrincipalSecret.
+// It will populate the form submitted during oAuth Token Acquisition using the client_secret read from the file.
+func (secret *ServicePrincipalFileSecret) SetAuthenticationValues(spt *ServicePrincipalToken, v *url.Values) error {
+	value, err := secret.fc.value()
+	if err != nil {
+		return err
+	}
+	v.Set("client_secret", value.(string))
+	return nil
+}
+
+func (secret *ServicePrincipalFileSecret) fallBack() bool {
+	return secret.fc.fallBack()
+}
+
+func (secret *ServicePrincipalFileSecret) accepted() {
+	secret.fc.accepted()
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (secret ServicePrincipalFileSecret) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("marshalling ServicePrincipalFileSecret is not supported")
+}
+
+// ServicePrincipalCertificateFileSecret implements ServicePrincipalSecret for RSA cert auth where the
+// certificate and private key are read from a PEM or PFX file.  The file is read again when its
+// modification time changes.  If the token endpoint rejects a certificate loaded within the last 15
+// minutes, the previous certificate is tried during the same refresh until the new one is accepted.
+type ServicePrincipalCertificateFileSecret struct {
+	fc *fileCredential
+}
+
+// NewServicePrincipalCertificateFileSecret creates a ServicePrincipalCertificateFileSecret for the certificate
+// in the specified file.  PEM files must contain the certificate and its unencrypted private key; any other
+// content is decoded as PFX data using the specified password.
+func NewServicePrincipalCertificateFileSecret(path, password string) (*ServicePrincipalCertificateFileSecret, error) {
+	fc, err := newFileCredential(path, func(b []byte) (interface{}, error) {
+		certificate, privateKey, err := decodeCertificateFile(b, password)
+		if err != nil {
+			return nil, err
+		}
+		return &ServicePrincipalCertificateSecret{
+			Certificate: certificate,
+			PrivateKey:  privateKey,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ServicePrincipalCertificateFileSecret{fc: fc}, nil
+}
+
+// SetAuthenticationValues is a method of the interface ServicePrincipalSecret.
+// It will populate the form submitted during oAuth Token Acquisition using a JWT signed with the certificate read from the file.
+func (secret *ServicePrincipalCertificateFileSecret) SetAuthenticationValues(spt *ServicePrincipalToken, v *url.Values) error {
+	value, err := secret.fc.value()
+	if err != nil {
+		return err
+	}
+	return value.(*ServicePrincipalCertificateSecret).SetAuthenticationValues(spt, v)
+}
+
+func (secret *ServicePrincipalCertificateFileSecret) fallBack() bool {
+	return secret.fc.fallBack()
+}
+
+func (secret *ServicePrincipalCertificateFileSecret) accepted() {
+	secret.fc.accepted()
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (secret ServicePrincipalCertificateFileSecret) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("marshalling ServicePrincipalCertificateFileSecret is not supported")
+}
+
+// decodes PEM data, falling back to PFX data if no PEM blocks are found
+func decodeCertificateFile(data []byte, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		return DecodePemCertificateData(data)
+	}
+	return DecodePfxCertificateData(data, password)
+}
+
+// NewServicePrincipalTokenFromSecretFile creates a ServicePrincipalToken from the client secret in the
+// specified file.  The secret is reloaded when the file changes; see ServicePrincipalFileSecret.
+func NewServicePrincipalTokenFromSecretFile(oauthConfig OAuthConfig, clientID string, path string, resource string, callbacks ...TokenRefreshCallback) (*ServicePrincipalToken, error) {
+	if err := validateOAuthConfig(oauthConfig); err != nil {
+		return nil, err
+	}
+	if err := validateStringParam(clientID, "clientID"); err != nil {
+		return nil, err
+	}
+	if err := validateStringParam(resource, "resource"); err != nil {
+		return nil, err
+	}
+	secret, err := NewServicePrincipalFileSecret(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewServicePrincipalTokenWithSecret(oauthConfig, clientID, resource, secret, callbacks...)
+}
+
+// NewServicePrincipalTokenFromCertificateFile creates a ServicePrincipalToken from the PEM or PFX certificate in the
+// specified file.  The certificate is reloaded when the file changes; see ServicePrincipalCertificateFileSecret.
+func NewServicePrincipalTokenFromCertificateFile(oauthConfig OAuthConfig, clientID string, path string, password string, resource string, callbacks ...TokenRefreshCallback) (*ServicePrincipalToken, error) {
+	if err := validateOAuthConfig(oauthConfig); err != nil {
+		return nil, err
+	}
+	if err := validateStringParam(clientID, "clientID"); err != nil {
+		return nil, err
+	}
+	if err := validateStringParam(resource, "resource"); err != nil {
+		return nil, err
+	}
+	secret, err := NewServicePrincipalCertificateFileSecret(path, password)
+	if err != nil {
+		return nil, err
+	}
+	return NewServicePrincipalTokenWithSecret(oauthConfig, clientID, resource, secret, callbacks...)
+}
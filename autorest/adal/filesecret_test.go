@@ -0,0 +1,208 @@
+package adal
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+func writeSecretFile(t *testing.T, path, content string, modTime time.Time) {
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write secret file: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to set secret file times: %v", err)
+	}
+}
+
+// returns a sender that succeeds only when the request contains the specified client secret
+func newSecretCheckingSender(t *testing.T, valid string, secrets *[]string) Sender {
+	return SenderFunc(func(r *http.Request) (*http.Response, error) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed to read request body: %v", err)
+		}
+		v, _ := url.ParseQuery(string(b))
+		*secrets = append(*secrets, v.Get("client_secret"))
+		if v.Get("client_secret") != valid {
+			return mocks.NewResponseWithStatus("invalid_client", http.StatusUnauthorized), nil
+		}
+		// no refresh token so that every refresh sends the client secret
+		return mocks.NewResponseWithContent(`{"access_token": "accessToken", "expires_in": "3600", "token_type": "Bearer"}`), nil
+	})
+}
+
+func TestServicePrincipalFileSecretReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	now := time.Now()
+	writeSecretFile(t, path, "secret-1\n", now.Add(-time.Hour))
+	spt, err := NewServicePrincipalTokenFromSecretFile(TestOAuthConfig, "id", path, "resource")
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+	var secrets []string
+	spt.SetSender(newSecretCheckingSender(t, "secret-1", &secrets))
+	if err := spt.Refresh(); err != nil {
+		t.Fatalf("unexpected error refreshing token: %v", err)
+	}
+	writeSecretFile(t, path, "secret-2", now)
+	spt.SetSender(newSecretCheckingSender(t, "secret-2", &secrets))
+	if err := spt.Refresh(); err != nil {
+		t.Fatalf("unexpected error refreshing token: %v", err)
+	}
+	if len(secrets) != 2 || secrets[0] != "secret-1" || secrets[1] != "secret-2" {
+		t.Fatalf("unexpected secrets sent %v", secrets)
+	}
+}
+
+func TestServicePrincipalFileSecretFallsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	now := time.Now()
+	writeSecretFile(t, path, "secret-1", now.Add(-time.Hour))
+	spt, err := NewServicePrincipalTokenFromSecretFile(TestOAuthConfig, "id", path, "resource")
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+	// the new secret hasn't propagated yet so only the previous one is accepted
+	writeSecretFile(t, path, "secret-2", now)
+	var secrets []string
+	spt.SetSender(newSecretCheckingSender(t, "secret-1", &secrets))
+	if err := spt.Refresh(); err != nil {
+		t.Fatalf("unexpected error refreshing token: %v", err)
+	}
+	if len(secrets) != 2 || secrets[0] != "secret-2" || secrets[1] != "secret-1" {
+		t.Fatalf("unexpected secrets sent %v", secrets)
+	}
+	// the new secret is tried first on the next refresh
+	secrets = nil
+	spt.SetSender(newSecretCheckingSender(t, "secret-2", &secrets))
+	if err := spt.Refresh(); err != nil {
+		t.Fatalf("unexpected error refreshing token: %v", err)
+	}
+	if len(secrets) != 1 || secrets[0] != "secret-2" {
+		t.Fatalf("unexpected secrets sent %v", secrets)
+	}
+	// the previous secret is dropped once the new one has been accepted
+	secrets = nil
+	spt.SetSender(newSecretCheckingSender(t, "other", &secrets))
+	if err := spt.Refresh(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(secrets) != 1 || secrets[0] != "secret-2" {
+		t.Fatalf("unexpected secrets sent %v", secrets)
+	}
+}
+
+func TestServicePrincipalFileSecretFallbackWindow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	now := time.Now()
+	writeSecretFile(t, path, "secret-1", now.Add(-time.Hour))
+	secret, err := NewServicePrincipalFileSecret(path)
+	if err != nil {
+		t.Fatalf("unexpected error creating secret: %v", err)
+	}
+	spt, err := NewServicePrincipalTokenWithSecret(TestOAuthConfig, "id", "resource", secret)
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+	writeSecretFile(t, path, "secret-2", now)
+	var secrets []string
+	spt.SetSender(newSecretCheckingSender(t, "other", &secrets))
+	if err := spt.Refresh(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(secrets) != 2 {
+		t.Fatalf("expected a fallback right after the reload, got %v", secrets)
+	}
+	// long after the reload the new secret is simply wrong
+	secret.fc.reloadedAt = now.Add(-2 * credentialFallbackWindow)
+	for i := 0; i < 2; i++ {
+		secrets = nil
+		if err := spt.Refresh(); err == nil {
+			t.Fatal("expected an error")
+		}
+		if len(secrets) != 1 || secrets[0] != "secret-2" {
+			t.Fatalf("unexpected secrets sent %v", secrets)
+		}
+	}
+}
+
+func TestServicePrincipalFileSecretNoFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	writeSecretFile(t, path, "secret-1", time.Now())
+	spt, err := NewServicePrincipalTokenFromSecretFile(TestOAuthConfig, "id", path, "resource")
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+	var secrets []string
+	spt.SetSender(newSecretCheckingSender(t, "other", &secrets))
+	if err := spt.Refresh(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(secrets) != 1 {
+		t.Fatalf("unexpected secrets sent %v", secrets)
+	}
+}
+
+func TestServicePrincipalFileSecretKeepsLastGood(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	writeSecretFile(t, path, "secret-1", time.Now())
+	secret, err := NewServicePrincipalFileSecret(path)
+	if err != nil {
+		t.Fatalf("unexpected error creating secret: %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	v := url.Values{}
+	if err := secret.SetAuthenticationValues(nil, &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.Get("client_secret") != "secret-1" {
+		t.Fatalf("unexpected secret %s", v.Get("client_secret"))
+	}
+	if _, err := NewServicePrincipalFileSecret(path); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestServicePrincipalCertificateFileSecret(t *testing.T) {
+	certificate, privateKey := newTestCertificate(t)
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+	pemData = append(pemData, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})...)
+	path := filepath.Join(t.TempDir(), "cert.pem")
+	writeSecretFile(t, path, string(pemData), time.Now())
+	spt, err := NewServicePrincipalTokenFromCertificateFile(TestOAuthConfig, "id", path, "", "resource")
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+	v := url.Values{}
+	if err := spt.inner.Secret.SetAuthenticationValues(spt, &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.Get("client_assertion") == "" {
+		t.Fatal("expected a client assertion")
+	}
+}
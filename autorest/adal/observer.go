@@ -0,0 +1,150 @@
+package adal
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// TokenGrantTypeManagedIdentity is the TokenAttempt.GrantType reported when acquiring a token from a managed identity endpoint
+	TokenGrantTypeManagedIdentity = "managed_identity"
+
+	// TokenGrantTypeCustom is the TokenAttempt.GrantType reported when acquiring a token through a custom refresh func
+	TokenGrantTypeCustom = "custom"
+)
+
+// TokenErrorClass classifies the failure of a token acquisition attempt.
+type TokenErrorClass string
+
+const (
+	// TokenErrorClassNone indicates the attempt succeeded.
+	TokenErrorClassNone TokenErrorClass = ""
+
+	// TokenErrorClassCredential indicates the request could not be created, e.g. signing a client assertion failed.
+	TokenErrorClassCredential TokenErrorClass = "Credential"
+
+	// TokenErrorClassTransport indicates the request could not be sent or the context was cancelled.
+	TokenErrorClassTransport TokenErrorClass = "Transport"
+
+	// TokenErrorClassHTTP indicates the token endpoint returned an unsuccessful HTTP status code.
+	TokenErrorClassHTTP TokenErrorClass = "HTTP"
+
+	// TokenErrorClassResponse indicates the token endpoint returned a malformed response.
+	TokenErrorClassResponse TokenErrorClass = "Response"
+
+	// TokenErrorClassCallback indicates a TokenRefreshCallback returned an error.
+	TokenErrorClassCallback TokenErrorClass = "Callback"
+
+	// TokenErrorClassPending indicates the device flow is waiting for the user to complete authentication.
+	TokenErrorClassPending TokenErrorClass = "Pending"
+)
+
+// TokenAttempt describes a single attempt to acquire a token.
+type TokenAttempt struct {
+	// GrantType is the OAuth grant type used, TokenGrantTypeManagedIdentity or TokenGrantTypeCustom.
+	GrantType string
+
+	// Resource is the resource the token was requested for.
+	Resource string
+
+	// Start is when the attempt started.
+	Start time.Time
+
+	// Latency is the duration of the attempt.  It's zero when reported to TokenObserver.AttemptStarted.
+	Latency time.Duration
+
+	// StatusCode is the HTTP status code returned by the token endpoint, or zero if no response was received.
+	StatusCode int
+
+	// Err is the error returned from the attempt, or nil if it succeeded.
+	Err error
+
+	// ErrorClass classifies Err.
+	ErrorClass TokenErrorClass
+
+	// FromCache is true when a valid cached token was used and no request was sent.
+	FromCache bool
+}
+
+// TokenObserver receives notifications about token acquisition attempts.
+// Implementations must be safe for concurrent use and should return quickly.
+type TokenObserver interface {
+	// AttemptStarted is called before a token is requested from the network.
+	AttemptStarted(attempt TokenAttempt)
+
+	// AttemptCompleted is called after a token request completes or a cached token was used.
+	// Cache hits are reported with FromCache set and are not preceded by a call to AttemptStarted.
+	AttemptCompleted(attempt TokenAttempt)
+}
+
+type tokenObserverKey struct{}
+
+// WithTokenObserver returns a copy of ctx that reports token acquisition attempts to the specified observer.
+// It's consulted by the device flow functions, and by ServicePrincipalToken when no observer has been set
+// through SetTokenObserver.
+func WithTokenObserver(ctx context.Context, observer TokenObserver) context.Context {
+	return context.WithValue(ctx, tokenObserverKey{}, observer)
+}
+
+// returns the observer in ctx or nil
+func tokenObserverFromContext(ctx context.Context) TokenObserver {
+	if observer, ok := ctx.Value(tokenObserverKey{}).(TokenObserver); ok {
+		return observer
+	}
+	return nil
+}
+
+// starts a new attempt and notifies the observer, if any
+func startTokenAttempt(observer TokenObserver, grantType, resource string) *TokenAttempt {
+	attempt := &TokenAttempt{
+		GrantType: grantType,
+		Resource:  resource,
+		Start:     time.Now(),
+	}
+	if observer != nil {
+		observer.AttemptStarted(*attempt)
+	}
+	return attempt
+}
+
+// completes the attempt with the specified error and notifies the observer, if any.
+// if err is non-nil and no class was assigned the error class defaults to class.
+func (a *TokenAttempt) complete(observer TokenObserver, err error, class TokenErrorClass) {
+	a.Latency = time.Since(a.Start)
+	a.Err = err
+	if err == nil {
+		a.ErrorClass = TokenErrorClassNone
+	} else if a.ErrorClass == TokenErrorClassNone {
+		a.ErrorClass = class
+	}
+	if observer != nil {
+		observer.AttemptCompleted(*a)
+	}
+}
+
+// notifies the observer, if any, that a cached token was used
+func reportTokenCacheHit(observer TokenObserver, grantType, resource string) {
+	if observer == nil {
+		return
+	}
+	observer.AttemptCompleted(TokenAttempt{
+		GrantType: grantType,
+		Resource:  resource,
+		Start:     time.Now(),
+		FromCache: true,
+	})
+}
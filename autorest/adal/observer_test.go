@@ -0,0 +1,200 @@
+package adal
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+type recordingObserver struct {
+	mu        sync.Mutex
+	started   []TokenAttempt
+	completed []TokenAttempt
+}
+
+func (r *recordingObserver) AttemptStarted(attempt TokenAttempt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started = append(r.started, attempt)
+}
+
+func (r *recordingObserver) AttemptCompleted(attempt TokenAttempt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.completed = append(r.completed, attempt)
+}
+
+func TestTokenObserverRefreshSuccess(t *testing.T) {
+	spt := newServicePrincipalToken()
+	obs := &recordingObserver{}
+	spt.SetTokenObserver(obs)
+	s := mocks.NewSender()
+	s.AppendResponse(mocks.NewResponseWithContent(newTokenJSON(`"3600"`, expiresOnIn(time.Hour), "resource")))
+	spt.SetSender(s)
+	if err := spt.EnsureFresh(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(obs.started) != 1 || len(obs.completed) != 1 {
+		t.Fatalf("expected one attempt, got %d started and %d completed", len(obs.started), len(obs.completed))
+	}
+	c := obs.completed[0]
+	if c.GrantType != OAuthGrantTypeClientCredentials || c.Resource != "resource" || c.StatusCode != http.StatusOK ||
+		c.Err != nil || c.ErrorClass != TokenErrorClassNone || c.FromCache || c.Latency <= 0 {
+		t.Fatalf("unexpected attempt %+v", c)
+	}
+	// the token is now fresh so it comes from the cache
+	if err := spt.EnsureFresh(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(obs.started) != 1 || len(obs.completed) != 2 || !obs.completed[1].FromCache {
+		t.Fatalf("expected a cache hit, got %+v", obs.completed)
+	}
+}
+
+func TestTokenObserverRefreshFailures(t *testing.T) {
+	testCases := []struct {
+		name   string
+		setup  func(s *mocks.Sender)
+		class  TokenErrorClass
+		status int
+	}{
+		{
+			name: "transport",
+			setup: func(s *mocks.Sender) {
+				s.SetError(errors.New("connection reset"))
+			},
+			class: TokenErrorClassTransport,
+		},
+		{
+			name: "http",
+			setup: func(s *mocks.Sender) {
+				s.AppendResponse(mocks.NewResponseWithStatus("unauthorized", http.StatusUnauthorized))
+			},
+			class:  TokenErrorClassHTTP,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "response",
+			setup: func(s *mocks.Sender) {
+				s.AppendResponse(mocks.NewResponseWithContent("not json"))
+			},
+			class:  TokenErrorClassResponse,
+			status: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			spt := newServicePrincipalToken()
+			s := mocks.NewSender()
+			tc.setup(s)
+			spt.SetSender(s)
+			obs := &recordingObserver{}
+			// the observer is picked up from the context
+			if err := spt.RefreshWithContext(WithTokenObserver(context.Background(), obs)); err == nil {
+				t.Fatal("expected an error")
+			}
+			if len(obs.completed) != 1 {
+				t.Fatalf("expected one completed attempt, got %d", len(obs.completed))
+			}
+			c := obs.completed[0]
+			if c.ErrorClass != tc.class || c.StatusCode != tc.status || c.Err == nil {
+				t.Fatalf("unexpected attempt %+v", c)
+			}
+		})
+	}
+}
+
+func TestTokenObserverCallbackFailure(t *testing.T) {
+	spt := newServicePrincipalToken(func(Token) error {
+		return errors.New("callback failed")
+	})
+	obs := &recordingObserver{}
+	spt.SetTokenObserver(obs)
+	s := mocks.NewSender()
+	s.AppendResponse(mocks.NewResponseWithContent(newTokenJSON(`"3600"`, expiresOnIn(time.Hour), "resource")))
+	spt.SetSender(s)
+	if err := spt.Refresh(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if c := obs.completed[0]; c.ErrorClass != TokenErrorClassCallback {
+		t.Fatalf("unexpected attempt %+v", c)
+	}
+}
+
+func TestTokenObserverDeviceFlowPending(t *testing.T) {
+	s := mocks.NewSender()
+	s.AppendResponse(mocks.NewResponseWithContent(errorDeviceTokenResponse("authorization_pending")))
+	obs := &recordingObserver{}
+	_, err := CheckForUserCompletionWithContext(WithTokenObserver(context.Background(), obs), s, deviceCode())
+	if err != ErrDeviceAuthorizationPending {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(obs.started) != 1 || len(obs.completed) != 1 {
+		t.Fatalf("expected one attempt, got %d started and %d completed", len(obs.started), len(obs.completed))
+	}
+	if c := obs.completed[0]; c.GrantType != OAuthGrantTypeDeviceCode || c.ErrorClass != TokenErrorClassPending {
+		t.Fatalf("unexpected attempt %+v", c)
+	}
+}
+
+func TestTokenObserverDeviceFlowWait(t *testing.T) {
+	s := mocks.NewSender()
+	s.AppendAndRepeatResponse(mocks.NewResponseWithContent(errorDeviceTokenResponse("authorization_pending")), 2)
+	s.AppendResponse(mocks.NewResponseWithContent(MockDeviceTokenResponse))
+	obs := &recordingObserver{}
+	if _, err := WaitForUserCompletionWithContext(WithTokenObserver(context.Background(), obs), s, deviceCode()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the pending checks are part of a single attempt
+	if s.Attempts() != 3 || len(obs.started) != 1 || len(obs.completed) != 1 {
+		t.Fatalf("expected one attempt for %d checks, got %d started and %d completed", s.Attempts(), len(obs.started), len(obs.completed))
+	}
+	if c := obs.completed[0]; c.GrantType != OAuthGrantTypeDeviceCode || c.Err != nil || c.ErrorClass != TokenErrorClassNone {
+		t.Fatalf("unexpected attempt %+v", c)
+	}
+}
+
+func TestTokenObserverExpiredWithoutAutoRefresh(t *testing.T) {
+	spt := newServicePrincipalToken()
+	spt.SetAutoRefresh(false)
+	obs := &recordingObserver{}
+	spt.SetTokenObserver(obs)
+	if err := spt.EnsureFresh(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(obs.completed) != 0 {
+		t.Fatalf("an expired token must not be reported as a cache hit, got %+v", obs.completed)
+	}
+	spt.inner.Token.ExpiresOn = json.Number(strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	if err := spt.EnsureFresh(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(obs.completed) != 1 || !obs.completed[0].FromCache {
+		t.Fatalf("expected a cache hit, got %+v", obs.completed)
+	}
+}
+
+func expiresOnIn(d time.Duration) string {
+	return time.Now().Add(d).UTC().Format(expiresOnDateFormat)
+}
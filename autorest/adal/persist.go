@@ -18,6 +18,7 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"os"
@@ -93,11 +94,36 @@ func DecodePfxCertificateData(pfxData []byte, password string) (*x509.Certificat
 	if err != nil {
 		return nil, nil, err
 	}
+	return decodeCertificateBlocks(blocks)
+}
+
+// DecodePemCertificateData extracts the x509 certificate and RSA private key from the provided PEM data.
+// The private key can be in PKCS #1 or PKCS #8 form.  If the PEM data contains more than one certificate,
+// the certificate returned is the one whose public key matches the private key.
+func DecodePemCertificateData(pemData []byte) (*x509.Certificate, *rsa.PrivateKey, error) {
+	var blocks []*pem.Block
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		return nil, nil, errors.New("adal: no PEM data found")
+	}
+	return decodeCertificateBlocks(blocks)
+}
+
+// returns the private key and its matching certificate from the specified PEM blocks
+func decodeCertificateBlocks(blocks []*pem.Block) (*x509.Certificate, *rsa.PrivateKey, error) {
 	// first extract the private key
 	var priv *rsa.PrivateKey
+	var err error
 	for _, block := range blocks {
-		if block.Type == "PRIVATE KEY" {
-			priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if block.Type == "PRIVATE KEY" || block.Type == "RSA PRIVATE KEY" {
+			priv, err = parseRSAPrivateKey(block.Bytes)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -132,3 +158,19 @@ func DecodePfxCertificateData(pfxData []byte, password string) (*x509.Certificat
 	}
 	return cert, priv, nil
 }
+
+// parses a PKCS #1 or PKCS #8 RSA private key
+func parseRSAPrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	if priv, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return priv, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("adal: unsupported private key type %T", key)
+	}
+	return priv, nil
+}
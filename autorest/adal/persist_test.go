@@ -15,7 +15,9 @@ package adal
 //  limitations under the License.
 
 import (
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"os"
 	"path"
 	"reflect"
@@ -168,3 +170,26 @@ func TestSaveTokenFailsCantCreate(t *testing.T) {
 		t.Fatalf("azure: failed to get correct error expected(%s) actual(%v)", expectedSubstring, err)
 	}
 }
+
+func TestDecodePemCertificateData(t *testing.T) {
+	certificate, privateKey := newTestCertificate(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+	pemData = append(pemData, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})...)
+	cert, key, err := DecodePemCertificateData(pemData)
+	if err != nil {
+		t.Fatalf("adal: unexpected error decoding PEM data: %v", err)
+	}
+	if !cert.Equal(certificate) || !key.Equal(privateKey) {
+		t.Fatal("adal: decoded certificate or private key doesn't match")
+	}
+	if _, _, err = DecodePemCertificateData(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})); err != ErrMissingPrivateKey {
+		t.Fatalf("adal: expected ErrMissingPrivateKey, got %v", err)
+	}
+	if _, _, err = DecodePemCertificateData([]byte("not pem")); err == nil {
+		t.Fatal("adal: expected an error for non-PEM data")
+	}
+}
@@ -16,9 +16,11 @@ package adal
 
 import (
 	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
@@ -29,6 +31,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
@@ -86,6 +89,9 @@ const (
 
 	// the format for expires_on in UTC without AM/PM
 	expiresOnDateFormat = "1/2/2006 15:04:05 +00:00"
+
+	// the audience of managed identity tokens used as federated identity credentials
+	tokenExchangeAudience = "api://AzureADTokenExchange"
 )
 
 // OAuthTokenProvider is an interface which should be implemented by an access token retriever
@@ -243,11 +249,24 @@ type ServicePrincipalCertificateSecret struct {
 
 // SignJwt returns the JWT signed with the certificate's private key.
 func (secret *ServicePrincipalCertificateSecret) SignJwt(spt *ServicePrincipalToken) (string, error) {
-	hasher := sha1.New()
-	_, err := hasher.Write(secret.Certificate.Raw)
+	token, err := newClientAssertion(spt, secret.Certificate, jwt.SigningMethodRS256)
 	if err != nil {
 		return "", err
 	}
+	x5c := []string{base64.StdEncoding.EncodeToString(secret.Certificate.Raw)}
+	token.Header["x5c"] = x5c
+
+	signedString, err := token.SignedString(secret.PrivateKey)
+	return signedString, err
+}
+
+// creates an unsigned client assertion for the specified certificate
+func newClientAssertion(spt *ServicePrincipalToken, certificate *x509.Certificate, method jwt.SigningMethod) (*jwt.Token, error) {
+	hasher := sha1.New()
+	_, err := hasher.Write(certificate.Raw)
+	if err != nil {
+		return nil, err
+	}
 
 	thumbprint := base64.URLEncoding.EncodeToString(hasher.Sum(nil))
 
@@ -255,13 +274,11 @@ func (secret *ServicePrincipalCertificateSecret) SignJwt(spt *ServicePrincipalTo
 	jti := make([]byte, 20)
 	_, err = rand.Read(jti)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	token := jwt.New(jwt.SigningMethodRS256)
+	token := jwt.New(method)
 	token.Header["x5t"] = thumbprint
-	x5c := []string{base64.StdEncoding.EncodeToString(secret.Certificate.Raw)}
-	token.Header["x5c"] = x5c
 	token.Claims = jwt.MapClaims{
 		"aud": spt.inner.OauthConfig.TokenEndpoint.String(),
 		"iss": spt.inner.ClientID,
@@ -270,9 +287,7 @@ func (secret *ServicePrincipalCertificateSecret) SignJwt(spt *ServicePrincipalTo
 		"nbf": time.Now().Unix(),
 		"exp": time.Now().Add(24 * time.Hour).Unix(),
 	}
-
-	signedString, err := token.SignedString(secret.PrivateKey)
-	return signedString, err
+	return token, nil
 }
 
 // SetAuthenticationValues is a method of the interface ServicePrincipalSecret.
@@ -293,6 +308,106 @@ func (secret ServicePrincipalCertificateSecret) MarshalJSON() ([]byte, error) {
 	return nil, errors.New("marshalling ServicePrincipalCertificateSecret is not supported")
 }
 
+// SignerCallback is the type representing a callback that signs the SHA-256 digest of a client
+// assertion using the private key of the service principal's certificate.  The returned value
+// must be a PKCS #1 v1.5 RSA signature.
+type SignerCallback func(digest []byte) ([]byte, error)
+
+// ServicePrincipalSignerSecret implements ServicePrincipalSecret for RSA cert auth where the private key
+// cannot be exported, e.g. it's held in a PKCS#11 HSM, a TPM or a remote signing service.
+// Exactly one of Signer or SignerCallback must be specified.
+type ServicePrincipalSignerSecret struct {
+	// Certificate is the certificate registered with the service principal.
+	Certificate *x509.Certificate
+
+	// Signer signs the client assertion with the certificate's private key.
+	Signer crypto.Signer
+
+	// SignerCallback signs the client assertion instead of Signer.
+	SignerCallback SignerCallback
+
+	// Chain contains any intermediate certificates to include in the x5c header.
+	Chain []*x509.Certificate
+
+	// SendCertificateChain includes the x5c header in the client assertion.
+	// This is required for subject name and issuer authentication.
+	SendCertificateChain bool
+}
+
+// SignJwt returns the JWT signed with the configured Signer or SignerCallback.
+func (secret *ServicePrincipalSignerSecret) SignJwt(spt *ServicePrincipalToken) (string, error) {
+	if secret.Certificate == nil {
+		return "", errors.New("adal: ServicePrincipalSignerSecret requires a certificate")
+	}
+	if (secret.Signer == nil) == (secret.SignerCallback == nil) {
+		return "", errors.New("adal: ServicePrincipalSignerSecret requires exactly one of Signer or SignerCallback")
+	}
+	sign := secret.SignerCallback
+	if secret.Signer != nil {
+		if _, ok := secret.Signer.Public().(*rsa.PublicKey); !ok {
+			return "", fmt.Errorf("adal: unsupported signer public key type %T", secret.Signer.Public())
+		}
+		sign = func(digest []byte) ([]byte, error) {
+			return secret.Signer.Sign(rand.Reader, digest, crypto.SHA256)
+		}
+	}
+	token, err := newClientAssertion(spt, secret.Certificate, signingMethodRS256Signer{})
+	if err != nil {
+		return "", err
+	}
+	if secret.SendCertificateChain {
+		x5c := []string{base64.StdEncoding.EncodeToString(secret.Certificate.Raw)}
+		for _, cert := range secret.Chain {
+			x5c = append(x5c, base64.StdEncoding.EncodeToString(cert.Raw))
+		}
+		token.Header["x5c"] = x5c
+	}
+	return token.SignedString(sign)
+}
+
+// SetAuthenticationValues is a method of the interface ServicePrincipalSecret.
+// It will populate the form submitted during oAuth Token Acquisition using a JWT signed by the Signer or SignerCallback.
+func (secret *ServicePrincipalSignerSecret) SetAuthenticationValues(spt *ServicePrincipalToken, v *url.Values) error {
+	jwt, err := secret.SignJwt(spt)
+	if err != nil {
+		return err
+	}
+
+	v.Set("client_assertion", jwt)
+	v.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (secret ServicePrincipalSignerSecret) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("marshalling ServicePrincipalSignerSecret is not supported")
+}
+
+// signingMethodRS256Signer is an RS256 jwt.SigningMethod that delegates signing of the
+// digest to a SignerCallback passed as the key.  It does not support verification.
+type signingMethodRS256Signer struct{}
+
+func (signingMethodRS256Signer) Alg() string {
+	return jwt.SigningMethodRS256.Alg()
+}
+
+func (signingMethodRS256Signer) Verify(signingString, signature string, key interface{}) error {
+	return errors.New("adal: verification is not supported")
+}
+
+func (signingMethodRS256Signer) Sign(signingString string, key interface{}) (string, error) {
+	sign, ok := key.(SignerCallback)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	digest := sha256.Sum256([]byte(signingString))
+	sig, err := sign(digest[:])
+	if err != nil {
+		return "", fmt.Errorf("adal: failed to sign the client assertion: %v", err)
+	}
+	return jwt.EncodeSegment(sig), nil
+}
+
 // ServicePrincipalMSISecret implements ServicePrincipalSecret for machines running the MSI Extension.
 type ServicePrincipalMSISecret struct {
 	msiType          msiType
@@ -397,6 +512,9 @@ type ServicePrincipalToken struct {
 	sender            Sender
 	customRefreshFunc TokenRefresh
 	refreshCallbacks  []TokenRefreshCallback
+	observer          TokenObserver
+	authority         *AuthorityValidationOptions
+	authorityValid    bool
 	// MaxMSIRefreshAttempts is the maximum number of attempts to refresh an MSI token.
 	// Settings this to a value less than 1 will use the default value.
 	MaxMSIRefreshAttempts int
@@ -417,6 +535,30 @@ func (spt *ServicePrincipalToken) SetCustomRefreshFunc(customRefreshFunc TokenRe
 	spt.customRefreshFunc = customRefreshFunc
 }
 
+// SetTokenObserver sets the TokenObserver notified of token acquisition attempts.
+// When not set, the TokenObserver in the context passed to the *WithContext methods is used.
+func (spt *ServicePrincipalToken) SetTokenObserver(observer TokenObserver) {
+	spt.observer = observer
+}
+
+// SetAuthorityValidation enables validation of the token's authority with ValidateAuthority.
+// The authority is validated before the first token request, using the token's sender, and
+// it's not validated again once it has been accepted.  Set SkipValidation in the options to
+// bypass validation, e.g. for disconnected Azure Stack Hub stamps.  Managed identity tokens
+// aren't validated.
+func (spt *ServicePrincipalToken) SetAuthorityValidation(options *AuthorityValidationOptions) {
+	spt.authority = options
+	spt.authorityValid = false
+}
+
+// returns the observer for the current operation or nil
+func (spt *ServicePrincipalToken) tokenObserver(ctx context.Context) TokenObserver {
+	if spt.observer != nil {
+		return spt.observer
+	}
+	return tokenObserverFromContext(ctx)
+}
+
 // MarshalJSON implements the json.Marshaler interface.
 func (spt ServicePrincipalToken) MarshalJSON() ([]byte, error) {
 	return json.Marshal(spt.inner)
@@ -438,6 +580,8 @@ func (spt *ServicePrincipalToken) UnmarshalJSON(data []byte) error {
 		spt.inner.Secret = &ServicePrincipalTokenSecret{}
 	case "ServicePrincipalCertificateSecret":
 		return errors.New("unmarshalling ServicePrincipalCertificateSecret is not supported")
+	case "ServicePrincipalSignerSecret":
+		return errors.New("unmarshalling ServicePrincipalSignerSecret is not supported")
 	case "ServicePrincipalMSISecret":
 		return errors.New("unmarshalling ServicePrincipalMSISecret is not supported")
 	case "ServicePrincipalUsernamePasswordSecret":
@@ -628,6 +772,37 @@ func NewServicePrincipalTokenFromCertificate(oauthConfig OAuthConfig, clientID s
 	)
 }
 
+// NewServicePrincipalTokenFromCertificateSigner creates a ServicePrincipalToken from the supplied certificate
+// whose private key is accessed through the supplied crypto.Signer.  Use NewServicePrincipalTokenWithSecret
+// with a ServicePrincipalSignerSecret to sign with a SignerCallback or to send the certificate chain.
+func NewServicePrincipalTokenFromCertificateSigner(oauthConfig OAuthConfig, clientID string, certificate *x509.Certificate, signer crypto.Signer, resource string, callbacks ...TokenRefreshCallback) (*ServicePrincipalToken, error) {
+	if err := validateOAuthConfig(oauthConfig); err != nil {
+		return nil, err
+	}
+	if err := validateStringParam(clientID, "clientID"); err != nil {
+		return nil, err
+	}
+	if err := validateStringParam(resource, "resource"); err != nil {
+		return nil, err
+	}
+	if certificate == nil {
+		return nil, fmt.Errorf("parameter 'certificate' cannot be nil")
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("parameter 'signer' cannot be nil")
+	}
+	return NewServicePrincipalTokenWithSecret(
+		oauthConfig,
+		clientID,
+		resource,
+		&ServicePrincipalSignerSecret{
+			Certificate: certificate,
+			Signer:      signer,
+		},
+		callbacks...,
+	)
+}
+
 // NewServicePrincipalTokenFromUsernamePassword creates a ServicePrincipalToken from the username and password.
 func NewServicePrincipalTokenFromUsernamePassword(oauthConfig OAuthConfig, clientID string, username string, password string, resource string, callbacks ...TokenRefreshCallback) (*ServicePrincipalToken, error) {
 	if err := validateOAuthConfig(oauthConfig); err != nil {
@@ -967,6 +1142,18 @@ func (spt *ServicePrincipalToken) EnsureFreshWithContext(ctx context.Context) er
 		if spt.inner.Token.WillExpireIn(spt.inner.RefreshWithin) {
 			return spt.refreshInternal(ctx, spt.inner.Resource)
 		}
+		// the token was refreshed by another goroutine
+		reportTokenCacheHit(spt.tokenObserver(ctx), spt.observedGrantType(), spt.inner.Resource)
+		return nil
+	}
+	if observer := spt.tokenObserver(ctx); observer != nil {
+		spt.refreshLock.RLock()
+		grantType, resource, expired := spt.observedGrantType(), spt.inner.Resource, spt.inner.Token.IsExpired()
+		spt.refreshLock.RUnlock()
+		// without autoRefresh an expired token is returned as is, it isn't a cache hit
+		if !expired {
+			reportTokenCacheHit(observer, grantType, resource)
+		}
 	}
 	return nil
 }
@@ -1023,13 +1210,65 @@ func (spt *ServicePrincipalToken) getGrantType() string {
 	}
 }
 
+// returns the grant type reported to the TokenObserver
+func (spt *ServicePrincipalToken) observedGrantType() string {
+	if spt.customRefreshFunc != nil {
+		return TokenGrantTypeCustom
+	}
+	if _, ok := spt.inner.Secret.(*ServicePrincipalMSISecret); ok {
+		return TokenGrantTypeManagedIdentity
+	}
+	if spt.inner.Token.RefreshToken != "" {
+		return OAuthGrantTypeRefreshToken
+	}
+	return spt.getGrantType()
+}
+
 func (spt *ServicePrincipalToken) refreshInternal(ctx context.Context, resource string) error {
+	if err := spt.validateAuthority(ctx); err != nil {
+		return err
+	}
+	observer := spt.tokenObserver(ctx)
+	attempt := startTokenAttempt(observer, spt.observedGrantType(), resource)
+	err := spt.refresh(ctx, resource, attempt)
+	attempt.complete(observer, err, TokenErrorClassCredential)
+	fb, ok := spt.inner.Secret.(fallbackSecret)
+	if ok && err == nil {
+		fb.accepted()
+	}
+	// if a rotated credential was rejected try again with the previous one
+	if ok && isCredentialRejected(err) && fb.fallBack() {
+		logger.Instance.Writef(logger.LogWarning, "adal: credential was rejected, retrying with the previous credential\n")
+		attempt = startTokenAttempt(observer, spt.observedGrantType(), resource)
+		err = spt.refresh(ctx, resource, attempt)
+		attempt.complete(observer, err, TokenErrorClassCredential)
+	}
+	return err
+}
+
+// validates the authority once if authority validation is enabled
+func (spt *ServicePrincipalToken) validateAuthority(ctx context.Context) error {
+	if spt.authority == nil || spt.authorityValid {
+		return nil
+	}
+	if _, ok := spt.inner.Secret.(*ServicePrincipalMSISecret); ok {
+		return nil
+	}
+	if err := ValidateAuthority(ctx, spt.sender, spt.inner.OauthConfig, spt.authority); err != nil {
+		return err
+	}
+	spt.authorityValid = true
+	return nil
+}
+
+func (spt *ServicePrincipalToken) refresh(ctx context.Context, resource string, attempt *TokenAttempt) error {
 	if spt.customRefreshFunc != nil {
 		token, err := spt.customRefreshFunc(ctx, resource)
 		if err != nil {
 			return err
 		}
 		spt.inner.Token = *token
+		attempt.ErrorClass = TokenErrorClassCallback
 		return spt.InvokeRefreshCallbacks(spt.inner.Token)
 	}
 	req, err := http.NewRequest(http.MethodPost, spt.inner.OauthConfig.TokenEndpoint.String(), nil)
@@ -1104,6 +1343,7 @@ func (spt *ServicePrincipalToken) refreshInternal(ctx context.Context, resource
 	}
 
 	// don't return a TokenRefreshError here; this will allow retry logic to apply
+	attempt.ErrorClass = TokenErrorClassTransport
 	if err != nil {
 		return fmt.Errorf("adal: Failed to execute the refresh request. Error = '%v'", err)
 	} else if resp == nil {
@@ -1113,8 +1353,10 @@ func (spt *ServicePrincipalToken) refreshInternal(ctx context.Context, resource
 	logger.Instance.WriteResponse(resp, logger.Filter{Body: authBodyFilter})
 	defer resp.Body.Close()
 	rb, err := io.ReadAll(resp.Body)
+	attempt.StatusCode = resp.StatusCode
 
 	if resp.StatusCode != http.StatusOK {
+		attempt.ErrorClass = TokenErrorClassHTTP
 		if err != nil {
 			return newTokenRefreshError(fmt.Sprintf("adal: Refresh request failed. Status Code = '%d'. Failed reading response body: %v Endpoint %s", resp.StatusCode, err, req.URL.String()), resp)
 		}
@@ -1125,6 +1367,7 @@ func (spt *ServicePrincipalToken) refreshInternal(ctx context.Context, resource
 	// but some transient failure happened during deserialization.  by returning a generic error
 	// the retry logic will kick in (we don't retry on TokenRefreshError).
 
+	attempt.ErrorClass = TokenErrorClassResponse
 	if err != nil {
 		return fmt.Errorf("adal: Failed to read a new service principal token during refresh. Error = '%v'", err)
 	}
@@ -1155,6 +1398,9 @@ func (spt *ServicePrincipalToken) refreshInternal(ctx context.Context, resource
 		if expiresOn, err = parseExpiresOn(token.ExpiresOn); err != nil {
 			return newTokenRefreshError(fmt.Sprintf("adal: failed to parse expires_on: %v value '%s'", err, token.ExpiresOn), resp)
 		}
+	} else if expiresIn, err := token.ExpiresIn.Int64(); err == nil {
+		// compute it from expires_in so the token isn't considered expired immediately
+		expiresOn = json.Number(strconv.FormatInt(time.Now().Add(time.Duration(expiresIn)*time.Second).Unix(), 10))
 	}
 	spt.inner.Token.AccessToken = token.AccessToken
 	spt.inner.Token.RefreshToken = token.RefreshToken
@@ -1164,6 +1410,7 @@ func (spt *ServicePrincipalToken) refreshInternal(ctx context.Context, resource
 	spt.inner.Token.Resource = token.Resource
 	spt.inner.Token.Type = token.Type
 
+	attempt.ErrorClass = TokenErrorClassCallback
 	return spt.InvokeRefreshCallbacks(spt.inner.Token)
 }
 
@@ -1304,10 +1551,24 @@ func (spt *ServicePrincipalToken) Token() Token {
 	return spt.inner.Token
 }
 
+// ServicePrincipalTokenFactory is the type representing a callback that creates a ServicePrincipalToken
+// for the tenant described by the specified OAuthConfig.
+type ServicePrincipalTokenFactory func(oauthConfig OAuthConfig) (*ServicePrincipalToken, error)
+
+// the maximum number of auxiliary tokens accepted in the x-ms-authorization-auxiliary header
+const maxAuxiliaryTenants = 3
+
 // MultiTenantServicePrincipalToken contains tokens for multi-tenant authorization.
 type MultiTenantServicePrincipalToken struct {
 	PrimaryToken    *ServicePrincipalToken
 	AuxiliaryTokens []*ServicePrincipalToken
+
+	// used to create tokens for auxiliary tenants that are added on demand, with the primary tenant's api version
+	auxLock                 sync.RWMutex
+	activeDirectoryEndpoint string
+	apiVersion              *string
+	factory                 ServicePrincipalTokenFactory
+	tenantTokens            map[string]*ServicePrincipalToken
 }
 
 // PrimaryOAuthToken returns the primary authorization token.
@@ -1317,13 +1578,158 @@ func (mt *MultiTenantServicePrincipalToken) PrimaryOAuthToken() string {
 
 // AuxiliaryOAuthTokens returns one to three auxiliary authorization tokens.
 func (mt *MultiTenantServicePrincipalToken) AuxiliaryOAuthTokens() []string {
-	tokens := make([]string, len(mt.AuxiliaryTokens))
-	for i := range mt.AuxiliaryTokens {
-		tokens[i] = mt.AuxiliaryTokens[i].OAuthToken()
+	auxTokens := mt.auxiliaryTokens()
+	tokens := make([]string, len(auxTokens))
+	for i := range auxTokens {
+		tokens[i] = auxTokens[i].OAuthToken()
 	}
 	return tokens
 }
 
+// returns a snapshot of the auxiliary tokens
+func (mt *MultiTenantServicePrincipalToken) auxiliaryTokens() []*ServicePrincipalToken {
+	mt.auxLock.RLock()
+	defer mt.auxLock.RUnlock()
+	return append([]*ServicePrincipalToken{}, mt.AuxiliaryTokens...)
+}
+
+// AddAuxiliaryTenant adds the specified tenant to the set of auxiliary tenants returned from AuxiliaryOAuthTokens.
+// The token for the tenant isn't acquired until it's refreshed.  Adding a tenant that's already present is a no-op.
+// This method is safe for concurrent use.
+func (mt *MultiTenantServicePrincipalToken) AddAuxiliaryTenant(tenantID string) error {
+	if err := validateStringParam(tenantID, "tenantID"); err != nil {
+		return err
+	}
+	mt.auxLock.Lock()
+	defer mt.auxLock.Unlock()
+	for _, aux := range mt.AuxiliaryTokens {
+		if strings.EqualFold(tenantFromOAuthConfig(aux.inner.OauthConfig), tenantID) {
+			return nil
+		}
+	}
+	if len(mt.AuxiliaryTokens) == maxAuxiliaryTenants {
+		return fmt.Errorf("cannot add tenant '%s', the maximum of %d auxiliary tenants has been reached", tenantID, maxAuxiliaryTenants)
+	}
+	aux, err := mt.tenantToken(tenantID)
+	if err != nil {
+		return err
+	}
+	mt.AuxiliaryTokens = append(mt.AuxiliaryTokens, aux)
+	return nil
+}
+
+// AuxiliaryOAuthTokensForTenants returns fresh authorization tokens for the specified auxiliary tenants.
+// Tokens for tenants not previously seen are created on demand and cached for subsequent calls, however
+// they are not added to the set of tenants returned from AuxiliaryOAuthTokens.
+// This method is safe for concurrent use.
+func (mt *MultiTenantServicePrincipalToken) AuxiliaryOAuthTokensForTenants(ctx context.Context, tenantIDs []string) ([]string, error) {
+	if len(tenantIDs) == 0 || len(tenantIDs) > maxAuxiliaryTenants {
+		return nil, fmt.Errorf("must specify one to %d auxiliary tenants", maxAuxiliaryTenants)
+	}
+	auxTokens := make([]*ServicePrincipalToken, len(tenantIDs))
+	mt.auxLock.Lock()
+	for i := range tenantIDs {
+		aux, err := mt.tenantToken(tenantIDs[i])
+		if err != nil {
+			mt.auxLock.Unlock()
+			return nil, err
+		}
+		auxTokens[i] = aux
+	}
+	mt.auxLock.Unlock()
+	tokens := make([]string, len(auxTokens))
+	for i, aux := range auxTokens {
+		if err := aux.EnsureFreshWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("failed to refresh auxiliary token for tenant '%s': %v", tenantIDs[i], err)
+		}
+		tokens[i] = aux.OAuthToken()
+	}
+	return tokens, nil
+}
+
+// returns the cached token for the specified tenant, creating it as required.  the caller must hold auxLock.
+func (mt *MultiTenantServicePrincipalToken) tenantToken(tenantID string) (*ServicePrincipalToken, error) {
+	if mt.tenantTokens == nil {
+		mt.tenantTokens = map[string]*ServicePrincipalToken{}
+		for _, aux := range mt.AuxiliaryTokens {
+			mt.tenantTokens[strings.ToLower(tenantFromOAuthConfig(aux.inner.OauthConfig))] = aux
+		}
+	}
+	key := strings.ToLower(tenantID)
+	if aux, ok := mt.tenantTokens[key]; ok {
+		return aux, nil
+	}
+	if mt.factory == nil {
+		return nil, fmt.Errorf("cannot create a token for tenant '%s', no ServicePrincipalTokenFactory was configured", tenantID)
+	}
+	cfg, err := NewOAuthConfigWithAPIVersion(mt.activeDirectoryEndpoint, tenantID, mt.apiVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OAuthConfig for tenant '%s': %v", tenantID, err)
+	}
+	aux, err := mt.factory(*cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SPT for auxiliary tenant '%s': %v", tenantID, err)
+	}
+	mt.tenantTokens[key] = aux
+	return aux, nil
+}
+
+// returns the tenant ID from the authority endpoint, e.g. https://login.microsoftonline.com/{tenant}
+func tenantFromOAuthConfig(cfg OAuthConfig) string {
+	return path.Base(cfg.AuthorityEndpoint.Path)
+}
+
+// returns the Active Directory endpoint from the authority endpoint, i.e. the authority with the tenant removed
+func activeDirectoryEndpointFromOAuthConfig(cfg OAuthConfig) string {
+	return cfg.AuthorityEndpoint.ResolveReference(&url.URL{Path: "./"}).String()
+}
+
+// returns the api version the token endpoint was created with, nil if it has none
+func apiVersionFromOAuthConfig(cfg OAuthConfig) *string {
+	q := cfg.TokenEndpoint.Query()
+	if _, ok := q["api-version"]; !ok {
+		return nil
+	}
+	apiVersion := q.Get("api-version")
+	return &apiVersion
+}
+
+// NewMultiTenantServicePrincipalTokenFromFactory creates a new MultiTenantServicePrincipalToken using the specified
+// factory to create the token for each tenant.  Up to three auxiliary tenants can be specified, and additional tenants
+// can be added on demand through AddAuxiliaryTenant and AuxiliaryOAuthTokensForTenants.
+func NewMultiTenantServicePrincipalTokenFromFactory(activeDirectoryEndpoint, primaryTenantID string, auxiliaryTenantIDs []string, options OAuthOptions, factory ServicePrincipalTokenFactory) (*MultiTenantServicePrincipalToken, error) {
+	if err := validateStringParam(activeDirectoryEndpoint, "activeDirectoryEndpoint"); err != nil {
+		return nil, err
+	}
+	if len(auxiliaryTenantIDs) > maxAuxiliaryTenants {
+		return nil, fmt.Errorf("must specify zero to %d auxiliary tenants", maxAuxiliaryTenants)
+	}
+	if factory == nil {
+		return nil, fmt.Errorf("parameter 'factory' cannot be nil")
+	}
+	apiVer := options.apiVersion()
+	pri, err := NewOAuthConfigWithAPIVersion(activeDirectoryEndpoint, primaryTenantID, &apiVer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OAuthConfig for primary tenant: %v", err)
+	}
+	primary, err := factory(*pri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SPT for primary tenant: %v", err)
+	}
+	m := MultiTenantServicePrincipalToken{
+		PrimaryToken:            primary,
+		activeDirectoryEndpoint: activeDirectoryEndpoint,
+		apiVersion:              &apiVer,
+		factory:                 factory,
+	}
+	for i := range auxiliaryTenantIDs {
+		if err := m.AddAuxiliaryTenant(auxiliaryTenantIDs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return &m, nil
+}
+
 // NewMultiTenantServicePrincipalToken creates a new MultiTenantServicePrincipalToken with the specified credentials and resource.
 func NewMultiTenantServicePrincipalToken(multiTenantCfg MultiTenantOAuthConfig, clientID string, secret string, resource string) (*MultiTenantServicePrincipalToken, error) {
 	if err := validateStringParam(clientID, "clientID"); err != nil {
@@ -1351,6 +1757,11 @@ func NewMultiTenantServicePrincipalToken(multiTenantCfg MultiTenantOAuthConfig,
 		}
 		m.AuxiliaryTokens[i] = aux
 	}
+	m.activeDirectoryEndpoint = activeDirectoryEndpointFromOAuthConfig(*multiTenantCfg.PrimaryTenant())
+	m.apiVersion = apiVersionFromOAuthConfig(*multiTenantCfg.PrimaryTenant())
+	m.factory = func(oauthConfig OAuthConfig) (*ServicePrincipalToken, error) {
+		return NewServicePrincipalToken(oauthConfig, clientID, secret, resource)
+	}
 	return &m, nil
 }
 
@@ -1400,9 +1811,67 @@ func NewMultiTenantServicePrincipalTokenFromCertificate(multiTenantCfg MultiTena
 		}
 		m.AuxiliaryTokens[i] = aux
 	}
+	m.activeDirectoryEndpoint = activeDirectoryEndpointFromOAuthConfig(*multiTenantCfg.PrimaryTenant())
+	m.apiVersion = apiVersionFromOAuthConfig(*multiTenantCfg.PrimaryTenant())
+	m.factory = func(oauthConfig OAuthConfig) (*ServicePrincipalToken, error) {
+		return NewServicePrincipalTokenFromCertificate(oauthConfig, clientID, certificate, privateKey, resource)
+	}
 	return &m, nil
 }
 
+// NewMultiTenantServicePrincipalTokenFromFederatedTokenCallback creates a new MultiTenantServicePrincipalToken with the
+// specified federated OIDC JWTCallback and resource.  This supports workload identity federation across tenants.
+func NewMultiTenantServicePrincipalTokenFromFederatedTokenCallback(multiTenantCfg MultiTenantOAuthConfig, clientID string, jwtCallback JWTCallback, resource string) (*MultiTenantServicePrincipalToken, error) {
+	if err := validateStringParam(clientID, "clientID"); err != nil {
+		return nil, err
+	}
+	if err := validateStringParam(resource, "resource"); err != nil {
+		return nil, err
+	}
+	if jwtCallback == nil {
+		return nil, fmt.Errorf("parameter 'jwtCallback' cannot be empty")
+	}
+	factory := func(oauthConfig OAuthConfig) (*ServicePrincipalToken, error) {
+		return NewServicePrincipalTokenFromFederatedTokenCallback(oauthConfig, clientID, jwtCallback, resource)
+	}
+	auxTenants := multiTenantCfg.AuxiliaryTenants()
+	m := MultiTenantServicePrincipalToken{
+		AuxiliaryTokens:         make([]*ServicePrincipalToken, len(auxTenants)),
+		activeDirectoryEndpoint: activeDirectoryEndpointFromOAuthConfig(*multiTenantCfg.PrimaryTenant()),
+		apiVersion:              apiVersionFromOAuthConfig(*multiTenantCfg.PrimaryTenant()),
+		factory:                 factory,
+	}
+	primary, err := factory(*multiTenantCfg.PrimaryTenant())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SPT for primary tenant: %v", err)
+	}
+	m.PrimaryToken = primary
+	for i := range auxTenants {
+		aux, err := factory(*auxTenants[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create SPT for auxiliary tenant: %v", err)
+		}
+		m.AuxiliaryTokens[i] = aux
+	}
+	return &m, nil
+}
+
+// NewMultiTenantServicePrincipalTokenFromManagedIdentity creates a new MultiTenantServicePrincipalToken for the
+// multi-tenant application clientID, using a managed identity configured as a federated identity credential of
+// the application.  The managed identity's token for audience api://AzureADTokenExchange is used as the assertion.
+func NewMultiTenantServicePrincipalTokenFromManagedIdentity(multiTenantCfg MultiTenantOAuthConfig, clientID string, resource string, options *ManagedIdentityOptions) (*MultiTenantServicePrincipalToken, error) {
+	msi, err := NewServicePrincipalTokenFromManagedIdentity(tokenExchangeAudience, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create managed identity SPT: %v", err)
+	}
+	return NewMultiTenantServicePrincipalTokenFromFederatedTokenCallback(multiTenantCfg, clientID, func() (string, error) {
+		if err := msi.EnsureFresh(); err != nil {
+			return "", err
+		}
+		return msi.OAuthToken(), nil
+	}, resource)
+}
+
 // MSIAvailable returns true if the MSI endpoint is available for authentication.
 func MSIAvailable(ctx context.Context, s Sender) bool {
 	msiType, _, err := getMSIType()
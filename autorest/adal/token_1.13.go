@@ -41,7 +41,7 @@ func (mt *MultiTenantServicePrincipalToken) EnsureFreshWithContext(ctx context.C
 	if err := mt.PrimaryToken.EnsureFreshWithContext(ctx); err != nil {
 		return fmt.Errorf("failed to refresh primary token: %w", err)
 	}
-	for _, aux := range mt.AuxiliaryTokens {
+	for _, aux := range mt.auxiliaryTokens() {
 		if err := aux.EnsureFreshWithContext(ctx); err != nil {
 			return fmt.Errorf("failed to refresh auxiliary token: %w", err)
 		}
@@ -54,7 +54,7 @@ func (mt *MultiTenantServicePrincipalToken) RefreshWithContext(ctx context.Conte
 	if err := mt.PrimaryToken.RefreshWithContext(ctx); err != nil {
 		return fmt.Errorf("failed to refresh primary token: %w", err)
 	}
-	for _, aux := range mt.AuxiliaryTokens {
+	for _, aux := range mt.auxiliaryTokens() {
 		if err := aux.RefreshWithContext(ctx); err != nil {
 			return fmt.Errorf("failed to refresh auxiliary token: %w", err)
 		}
@@ -67,7 +67,7 @@ func (mt *MultiTenantServicePrincipalToken) RefreshExchangeWithContext(ctx conte
 	if err := mt.PrimaryToken.RefreshExchangeWithContext(ctx, resource); err != nil {
 		return fmt.Errorf("failed to refresh primary token: %w", err)
 	}
-	for _, aux := range mt.AuxiliaryTokens {
+	for _, aux := range mt.auxiliaryTokens() {
 		if err := aux.RefreshExchangeWithContext(ctx, resource); err != nil {
 			return fmt.Errorf("failed to refresh auxiliary token: %w", err)
 		}
@@ -40,7 +40,7 @@ func (mt *MultiTenantServicePrincipalToken) EnsureFreshWithContext(ctx context.C
 	if err := mt.PrimaryToken.EnsureFreshWithContext(ctx); err != nil {
 		return err
 	}
-	for _, aux := range mt.AuxiliaryTokens {
+	for _, aux := range mt.auxiliaryTokens() {
 		if err := aux.EnsureFreshWithContext(ctx); err != nil {
 			return err
 		}
@@ -53,7 +53,7 @@ func (mt *MultiTenantServicePrincipalToken) RefreshWithContext(ctx context.Conte
 	if err := mt.PrimaryToken.RefreshWithContext(ctx); err != nil {
 		return err
 	}
-	for _, aux := range mt.AuxiliaryTokens {
+	for _, aux := range mt.auxiliaryTokens() {
 		if err := aux.RefreshWithContext(ctx); err != nil {
 			return err
 		}
@@ -66,7 +66,7 @@ func (mt *MultiTenantServicePrincipalToken) RefreshExchangeWithContext(ctx conte
 	if err := mt.PrimaryToken.RefreshExchangeWithContext(ctx, resource); err != nil {
 		return err
 	}
-	for _, aux := range mt.AuxiliaryTokens {
+	for _, aux := range mt.auxiliaryTokens() {
 		if err := aux.RefreshExchangeWithContext(ctx, resource); err != nil {
 			return err
 		}
@@ -16,6 +16,7 @@ package adal
 
 import (
 	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -529,8 +530,12 @@ func TestServicePrincipalTokenFromADFS(t *testing.T) {
 	if i != expiresIn {
 		t.Fatalf("unexpected expires_in %d", i)
 	}
-	if spt.inner.Token.ExpiresOn.String() != "" {
-		t.Fatal("expected empty expires_on")
+	// expires_on is computed from expires_in
+	if spt.inner.Token.IsExpired() {
+		t.Fatalf("unexpected expires_on %s", spt.inner.Token.ExpiresOn)
+	}
+	if !spt.inner.Token.WillExpireIn((expiresIn + 1) * time.Second) {
+		t.Fatalf("expires_on %s is later than expected", spt.inner.Token.ExpiresOn)
 	}
 	if body.IsOpen() {
 		t.Fatalf("the response was not closed!")
@@ -700,6 +705,99 @@ func TestServicePrincipalTokenCertficateRefreshSetsBody(t *testing.T) {
 	})
 }
 
+func TestServicePrincipalTokenCertificateSignerRefreshSetsBody(t *testing.T) {
+	certificate, privateKey := newTestCertificate(t)
+	spt, err := NewServicePrincipalTokenFromCertificateSigner(TestOAuthConfig, "id", certificate, privateKey, "resource")
+	if err != nil {
+		t.Fatalf("adal: unexpected error while creating signer token: %v", err)
+	}
+	testServicePrincipalTokenRefreshSetsBody(t, spt, func(t *testing.T, b []byte) {
+		values, _ := url.ParseQuery(string(b))
+		if values["client_assertion_type"][0] != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" ||
+			values["client_id"][0] != "id" ||
+			values["grant_type"][0] != "client_credentials" ||
+			values["resource"][0] != "resource" {
+			t.Fatalf("adal: ServicePrincipalSignerSecret#Refresh did not correctly set the HTTP Request Body.")
+		}
+		tok, err := jwt.Parse(values["client_assertion"][0], func(*jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		})
+		if err != nil {
+			t.Fatalf("adal: failed to verify client_assertion: %v", err)
+		}
+		if tok.Method.Alg() != "RS256" {
+			t.Fatalf("unexpected alg: %s", tok.Method.Alg())
+		}
+		if _, ok := tok.Header["x5t"]; !ok {
+			t.Fatalf("adal: ServicePrincipalSignerSecret#Expected client_assertion to have an x5t header")
+		}
+		if _, ok := tok.Header["x5c"]; ok {
+			t.Fatalf("adal: ServicePrincipalSignerSecret#Unexpected x5c header")
+		}
+		if aud := tok.Claims.(jwt.MapClaims)["aud"]; aud != "https://login.test.com/SomeTenantID/oauth2/token?api-version=1.0" {
+			t.Fatalf("unexpected aud: %s", aud)
+		}
+	})
+}
+
+func TestServicePrincipalTokenSignerCallbackSendsChain(t *testing.T) {
+	certificate, privateKey := newTestCertificate(t)
+	intermediate, _ := newTestCertificate(t)
+	called := false
+	secret := &ServicePrincipalSignerSecret{
+		Certificate: certificate,
+		SignerCallback: func(digest []byte) ([]byte, error) {
+			called = true
+			return rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest)
+		},
+		Chain:                []*x509.Certificate{intermediate},
+		SendCertificateChain: true,
+	}
+	spt, err := NewServicePrincipalTokenWithSecret(TestOAuthConfig, "id", "resource", secret)
+	if err != nil {
+		t.Fatalf("adal: unexpected error while creating signer token: %v", err)
+	}
+	signed, err := secret.SignJwt(spt)
+	if err != nil {
+		t.Fatalf("adal: unexpected error while signing: %v", err)
+	}
+	if !called {
+		t.Fatal("adal: SignerCallback was not invoked")
+	}
+	tok, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) {
+		return &privateKey.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatalf("adal: failed to verify client_assertion: %v", err)
+	}
+	x5c, ok := tok.Header["x5c"].([]interface{})
+	if !ok || len(x5c) != 2 {
+		t.Fatalf("adal: expected x5c header with two certificates, got %v", tok.Header["x5c"])
+	}
+}
+
+func TestServicePrincipalSignerSecretRequiresSigner(t *testing.T) {
+	certificate, privateKey := newTestCertificate(t)
+	secret := &ServicePrincipalSignerSecret{Certificate: certificate}
+	spt, err := NewServicePrincipalTokenWithSecret(TestOAuthConfig, "id", "resource", secret)
+	if err != nil {
+		t.Fatalf("adal: unexpected error while creating signer token: %v", err)
+	}
+	if _, err := secret.SignJwt(spt); err == nil {
+		t.Fatal("adal: expected an error when no signer is configured")
+	}
+	secret.Signer = privateKey
+	secret.SignerCallback = func(digest []byte) ([]byte, error) {
+		return rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest)
+	}
+	if _, err := secret.SignJwt(spt); err == nil {
+		t.Fatal("adal: expected an error when both Signer and SignerCallback are configured")
+	}
+	if _, err := NewServicePrincipalTokenFromCertificateSigner(TestOAuthConfig, "id", certificate, nil, "resource"); err == nil {
+		t.Fatal("adal: expected an error for a nil signer")
+	}
+}
+
 func TestServicePrincipalTokenUsernamePasswordRefreshSetsBody(t *testing.T) {
 	spt := newServicePrincipalTokenUsernamePassword(t)
 	testServicePrincipalTokenRefreshSetsBody(t, spt, func(t *testing.T, b []byte) {
@@ -1343,6 +1441,17 @@ func TestMarshalServicePrincipalCertificateSecret(t *testing.T) {
 	}
 }
 
+func TestMarshalServicePrincipalSignerSecret(t *testing.T) {
+	certificate, privateKey := newTestCertificate(t)
+	spt, err := NewServicePrincipalTokenFromCertificateSigner(TestOAuthConfig, "id", certificate, privateKey, "resource")
+	if err != nil {
+		t.Fatalf("adal: unexpected error while creating signer token: %v", err)
+	}
+	if _, err := json.Marshal(spt); err == nil {
+		t.Fatal("expected error when marshalling signer token")
+	}
+}
+
 func TestMarshalServicePrincipalMSISecret(t *testing.T) {
 	spt, err := newServicePrincipalTokenFromMSI("http://msiendpoint/", "https://resource", "", "")
 	if err != nil {
@@ -1473,6 +1582,186 @@ func TestNewMultiTenantServicePrincipalTokenFromCertificate(t *testing.T) {
 	}
 }
 
+func TestNewMultiTenantServicePrincipalTokenFromFederatedTokenCallback(t *testing.T) {
+	cfg, err := NewMultiTenantOAuthConfig(TestActiveDirectoryEndpoint, TestTenantID, TestAuxTenantIDs[:1], OAuthOptions{})
+	if err != nil {
+		t.Fatalf("autorest/adal: unexpected error while creating multitenant config: %v", err)
+	}
+	mt, err := NewMultiTenantServicePrincipalTokenFromFederatedTokenCallback(cfg, "clientID", func() (string, error) {
+		return "assertion", nil
+	}, "resource")
+	if err != nil {
+		t.Fatalf("autorest/adal: unexpected error while creating multitenant service principal token: %v", err)
+	}
+	if _, ok := mt.PrimaryToken.inner.Secret.(*ServicePrincipalFederatedSecret); !ok {
+		t.Fatalf("unexpected primary secret type %T", mt.PrimaryToken.inner.Secret)
+	}
+	if len(mt.AuxiliaryTokens) != 1 {
+		t.Fatalf("expected one auxiliary token, got %d", len(mt.AuxiliaryTokens))
+	}
+	// adding an existing tenant is a no-op
+	if err := mt.AddAuxiliaryTenant(strings.ToUpper(TestAuxTenantIDs[0])); err != nil {
+		t.Fatalf("unexpected error adding existing tenant: %v", err)
+	}
+	if err := mt.AddAuxiliaryTenant(TestAuxTenantIDs[1]); err != nil {
+		t.Fatalf("unexpected error adding tenant: %v", err)
+	}
+	if len(mt.AuxiliaryTokens) != 2 {
+		t.Fatalf("expected two auxiliary tokens, got %d", len(mt.AuxiliaryTokens))
+	}
+	if ep := mt.AuxiliaryTokens[1].inner.OauthConfig.TokenEndpoint.String(); ep != TestActiveDirectoryEndpoint+TestAuxTenantIDs[1]+"/oauth2/token?api-version=1.0" {
+		t.Fatalf("unexpected token endpoint %s", ep)
+	}
+}
+
+func TestNewMultiTenantServicePrincipalTokenFromManagedIdentity(t *testing.T) {
+	msiRequests := 0
+	// the cloud shell endpoint is used as it's taken from the environment
+	msi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msiRequests++
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("resource") != tokenExchangeAudience {
+			t.Errorf("unexpected managed identity resource %s", r.PostForm.Get("resource"))
+		}
+		fmt.Fprintf(w, `{"access_token": "msi-token", "expires_in": "3600", "expires_on": "%d", "resource": "%s", "token_type": "Bearer"}`, time.Now().Add(time.Hour).Unix(), tokenExchangeAudience)
+	}))
+	defer msi.Close()
+	os.Setenv(msiEndpointEnv, msi.URL)
+	defer os.Unsetenv(msiEndpointEnv)
+
+	cfg, err := NewMultiTenantOAuthConfig(TestActiveDirectoryEndpoint, TestTenantID, TestAuxTenantIDs, OAuthOptions{})
+	if err != nil {
+		t.Fatalf("autorest/adal: unexpected error while creating multitenant config: %v", err)
+	}
+	mt, err := NewMultiTenantServicePrincipalTokenFromManagedIdentity(cfg, "clientID", "resource", nil)
+	if err != nil {
+		t.Fatalf("autorest/adal: unexpected error while creating multitenant service principal token: %v", err)
+	}
+	tokens := append([]*ServicePrincipalToken{mt.PrimaryToken}, mt.AuxiliaryTokens...)
+	if len(tokens) != 1+len(TestAuxTenantIDs) {
+		t.Fatalf("unexpected number of tokens %d", len(tokens))
+	}
+	for _, spt := range tokens {
+		spt.SetSender(SenderFunc(func(r *http.Request) (*http.Response, error) {
+			if err := r.ParseForm(); err != nil {
+				t.Fatalf("failed to parse the form: %v", err)
+			}
+			if a := r.PostForm.Get("client_assertion"); a != "msi-token" {
+				t.Fatalf("unexpected client assertion %s for %s", a, r.URL)
+			}
+			if ct := r.PostForm.Get("client_assertion_type"); ct != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+				t.Fatalf("unexpected client assertion type %s", ct)
+			}
+			return mocks.NewResponseWithBodyAndStatus(mocks.NewBody(newTokenJSON(`"3600"`, strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10), "resource")), http.StatusOK, "OK"), nil
+		}))
+	}
+	if err = mt.RefreshWithContext(context.Background()); err != nil {
+		t.Fatalf("unexpected error refreshing the tokens: %v", err)
+	}
+	// the managed identity's token is cached across the tenants
+	if msiRequests != 1 {
+		t.Fatalf("expected one managed identity request, got %d", msiRequests)
+	}
+}
+
+func TestMultiTenantServicePrincipalTokenFromFactory(t *testing.T) {
+	requested := map[string]int{}
+	mu := sync.Mutex{}
+	factory := func(oauthConfig OAuthConfig) (*ServicePrincipalToken, error) {
+		spt, err := NewServicePrincipalToken(oauthConfig, "id", "secret", "resource")
+		if err != nil {
+			return nil, err
+		}
+		tenant := tenantFromOAuthConfig(oauthConfig)
+		spt.SetSender(SenderFunc(func(r *http.Request) (*http.Response, error) {
+			mu.Lock()
+			requested[tenant]++
+			mu.Unlock()
+			return mocks.NewResponseWithBodyAndStatus(mocks.NewBody(newTokenJSON(`"3600"`, strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10), tenant)), http.StatusOK, "OK"), nil
+		}))
+		return spt, nil
+	}
+	mt, err := NewMultiTenantServicePrincipalTokenFromFactory(TestActiveDirectoryEndpoint, TestTenantID, nil, OAuthOptions{}, factory)
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+	if len(mt.AuxiliaryOAuthTokens()) != 0 {
+		t.Fatal("expected no auxiliary tokens")
+	}
+	if len(requested) != 0 {
+		t.Fatal("expected tokens to be acquired lazily")
+	}
+	tokens, err := mt.AuxiliaryOAuthTokensForTenants(context.Background(), []string{"tenant-a", "tenant-b"})
+	if err != nil {
+		t.Fatalf("unexpected error getting auxiliary tokens: %v", err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("expected two tokens, got %d", len(tokens))
+	}
+	if _, err = mt.AuxiliaryOAuthTokensForTenants(context.Background(), []string{"tenant-a"}); err != nil {
+		t.Fatalf("unexpected error getting auxiliary tokens: %v", err)
+	}
+	if requested["tenant-a"] != 1 || requested["tenant-b"] != 1 || requested[TestTenantID] != 0 {
+		t.Fatalf("unexpected token requests %v", requested)
+	}
+	if len(mt.AuxiliaryOAuthTokens()) != 0 {
+		t.Fatal("per-request tenants must not be added to the auxiliary tenants")
+	}
+	if _, err = mt.AuxiliaryOAuthTokensForTenants(context.Background(), []string{"a", "b", "c", "d"}); err == nil {
+		t.Fatal("expected error for too many tenants")
+	}
+	if _, err = mt.AuxiliaryOAuthTokensForTenants(context.Background(), []string{}); err == nil {
+		t.Fatal("expected error for no tenants")
+	}
+}
+
+func TestMultiTenantServicePrincipalTokenOnDemandAPIVersion(t *testing.T) {
+	var endpoints []string
+	factory := func(oauthConfig OAuthConfig) (*ServicePrincipalToken, error) {
+		endpoints = append(endpoints, oauthConfig.TokenEndpoint.String())
+		return NewServicePrincipalToken(oauthConfig, "id", "secret", "resource")
+	}
+	mt, err := NewMultiTenantServicePrincipalTokenFromFactory(TestActiveDirectoryEndpoint, TestTenantID, nil, OAuthOptions{APIVersion: "2019-01-01"}, factory)
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+	if err = mt.AddAuxiliaryTenant("tenant-a"); err != nil {
+		t.Fatalf("unexpected error adding tenant: %v", err)
+	}
+	if len(endpoints) != 2 {
+		t.Fatalf("expected two tokens, got %d", len(endpoints))
+	}
+	if expected := strings.Replace(endpoints[0], TestTenantID, "tenant-a", 1); endpoints[1] != expected {
+		t.Fatalf("expected token endpoint %s, got %s", expected, endpoints[1])
+	}
+	if !strings.Contains(endpoints[1], "2019-01-01") {
+		t.Fatalf("the api version is missing from %s", endpoints[1])
+	}
+
+	// the api version is taken from the primary tenant's OAuthConfig
+	cfg, err := NewMultiTenantOAuthConfig(TestActiveDirectoryEndpoint, TestTenantID, TestAuxTenantIDs, OAuthOptions{APIVersion: "2019-01-01"})
+	if err != nil {
+		t.Fatalf("unexpected error creating config: %v", err)
+	}
+	mt, err = NewMultiTenantServicePrincipalToken(cfg, "id", "secret", "resource")
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %v", err)
+	}
+	if _, err = mt.tenantToken("tenant-a"); err != nil {
+		t.Fatalf("unexpected error creating tenant token: %v", err)
+	}
+	primary := mt.PrimaryToken.inner.OauthConfig.TokenEndpoint.String()
+	if ep := mt.tenantTokens["tenant-a"].inner.OauthConfig.TokenEndpoint.String(); ep != strings.Replace(primary, TestTenantID, "tenant-a", 1) {
+		t.Fatalf("unexpected token endpoint %s", ep)
+	}
+}
+
+func TestMultiTenantServicePrincipalTokenNoFactory(t *testing.T) {
+	mt := &MultiTenantServicePrincipalToken{PrimaryToken: newServicePrincipalToken()}
+	if err := mt.AddAuxiliaryTenant("tenant"); err == nil {
+		t.Fatal("expected error when no factory is configured")
+	}
+}
+
 func TestMSIAvailableSuccess(t *testing.T) {
 	c := mocks.NewSender()
 	c.AppendResponse(mocks.NewResponse())
@@ -15,6 +15,7 @@ package autorest
 //  limitations under the License.
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
@@ -302,9 +303,21 @@ func NewMultiTenantServicePrincipalTokenAuthorizer(tp adal.MultitenantOAuthToken
 	return NewMultiTenantBearerAuthorizer(tp)
 }
 
+// AuxiliaryTenantsSelector is the type representing a callback that returns the IDs of the auxiliary
+// tenants required by the specified request.  Returning an empty slice selects the token provider's
+// default auxiliary tokens.
+type AuxiliaryTenantsSelector func(r *http.Request) []string
+
+// auxiliaryTenantsTokenProvider is implemented by multi-tenant token providers that can acquire
+// tokens for arbitrary auxiliary tenants, e.g. *adal.MultiTenantServicePrincipalToken.
+type auxiliaryTenantsTokenProvider interface {
+	AuxiliaryOAuthTokensForTenants(ctx context.Context, tenantIDs []string) ([]string, error)
+}
+
 // MultiTenantBearerAuthorizer implements bearer authorization across multiple tenants.
 type MultiTenantBearerAuthorizer struct {
-	tp adal.MultitenantOAuthTokenProvider
+	tp       adal.MultitenantOAuthTokenProvider
+	selector AuxiliaryTenantsSelector
 }
 
 // NewMultiTenantBearerAuthorizer creates a MultiTenantBearerAuthorizer using the given token provider.
@@ -312,6 +325,13 @@ func NewMultiTenantBearerAuthorizer(tp adal.MultitenantOAuthTokenProvider) *Mult
 	return &MultiTenantBearerAuthorizer{tp: tp}
 }
 
+// WithAuxiliaryTenantsSelector sets the callback used to choose the auxiliary tenants on a per-request basis.
+// The token provider must support acquiring tokens for arbitrary tenants, else WithAuthorization returns an error.
+func (mt *MultiTenantBearerAuthorizer) WithAuxiliaryTenantsSelector(selector AuxiliaryTenantsSelector) *MultiTenantBearerAuthorizer {
+	mt.selector = selector
+	return mt
+}
+
 // WithAuthorization returns a PrepareDecorator that adds an HTTP Authorization header using the
 // primary token along with the auxiliary authorization header using the auxiliary tokens.
 //
@@ -338,7 +358,11 @@ func (mt *MultiTenantBearerAuthorizer) WithAuthorization() PrepareDecorator {
 			if err != nil {
 				return r, err
 			}
-			auxTokens := mt.tp.AuxiliaryOAuthTokens()
+			auxTokens, err := mt.auxiliaryTokens(r)
+			if err != nil {
+				return r, NewErrorWithError(err, "azure.multiTenantSPTAuthorizer", "WithAuthorization", nil,
+					"Failed to acquire auxiliary Tokens for request to %s", r.URL)
+			}
 			for i := range auxTokens {
 				auxTokens[i] = fmt.Sprintf("Bearer %s", auxTokens[i])
 			}
@@ -347,6 +371,22 @@ func (mt *MultiTenantBearerAuthorizer) WithAuthorization() PrepareDecorator {
 	}
 }
 
+// returns the auxiliary tokens for the specified request
+func (mt *MultiTenantBearerAuthorizer) auxiliaryTokens(r *http.Request) ([]string, error) {
+	if mt.selector == nil {
+		return mt.tp.AuxiliaryOAuthTokens(), nil
+	}
+	tenants := mt.selector(r)
+	if len(tenants) == 0 {
+		return mt.tp.AuxiliaryOAuthTokens(), nil
+	}
+	tp, ok := mt.tp.(auxiliaryTenantsTokenProvider)
+	if !ok {
+		return nil, fmt.Errorf("token provider %T doesn't support selecting auxiliary tenants", mt.tp)
+	}
+	return tp.AuxiliaryOAuthTokensForTenants(r.Context(), tenants)
+}
+
 // TokenProvider returns the underlying MultitenantOAuthTokenProvider for this authorizer.
 func (mt *MultiTenantBearerAuthorizer) TokenProvider() adal.MultitenantOAuthTokenProvider {
 	return mt.tp
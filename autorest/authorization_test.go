@@ -15,6 +15,7 @@ package autorest
 //  limitations under the License.
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"reflect"
@@ -310,6 +311,60 @@ func TestMultitenantAuthorizationThree(t *testing.T) {
 	}
 }
 
+type mockMTSPTTenantsProvider struct {
+	mockMTSPTProvider
+}
+
+func (m mockMTSPTTenantsProvider) AuxiliaryOAuthTokensForTenants(ctx context.Context, tenantIDs []string) ([]string, error) {
+	tokens := make([]string, len(tenantIDs))
+	for i := range tenantIDs {
+		tokens[i] = "token-" + tenantIDs[i]
+	}
+	return tokens, nil
+}
+
+func TestMultitenantAuthorizationSelector(t *testing.T) {
+	mtSPTProvider := mockMTSPTTenantsProvider{
+		mockMTSPTProvider: mockMTSPTProvider{
+			p: "primary",
+			a: []string{TestAuxTenent1},
+		},
+	}
+	mt := NewMultiTenantBearerAuthorizer(mtSPTProvider).WithAuxiliaryTenantsSelector(func(r *http.Request) []string {
+		if strings.Contains(r.URL.Path, "cross-tenant") {
+			return []string{"tenant-a", "tenant-b"}
+		}
+		return nil
+	})
+	req, err := Prepare(mocks.NewRequestWithParams("GET", "https://microsoft.com/cross-tenant", nil), mt.WithAuthorization())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if aux := req.Header.Get(headerAuxAuthorization); aux != "Bearer token-tenant-a, Bearer token-tenant-b" {
+		t.Fatalf("bad auxiliary authorization header %s", aux)
+	}
+	req, err = Prepare(mocks.NewRequest(), mt.WithAuthorization())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if aux := req.Header.Get(headerAuxAuthorization); aux != "Bearer aux1" {
+		t.Fatalf("bad auxiliary authorization header %s", aux)
+	}
+}
+
+func TestMultitenantAuthorizationSelectorUnsupported(t *testing.T) {
+	mtSPTProvider := mockMTSPTProvider{
+		p: "primary",
+		a: []string{TestAuxTenent1},
+	}
+	mt := NewMultiTenantBearerAuthorizer(mtSPTProvider).WithAuxiliaryTenantsSelector(func(r *http.Request) []string {
+		return []string{"tenant-a"}
+	})
+	if _, err := Prepare(mocks.NewRequest(), mt.WithAuthorization()); err == nil {
+		t.Fatal("expected an error when the token provider doesn't support selecting tenants")
+	}
+}
+
 func TestMultiTenantServicePrincipalTokenWithAuthorizationRefresh(t *testing.T) {
 	multiTenantCfg, err := adal.NewMultiTenantOAuthConfig(TestActiveDirectoryEndpoint, TestTenantID, []string{TestAuxTenent1, TestAuxTenent2, TestAuxTenent3}, adal.OAuthOptions{})
 	if err != nil {
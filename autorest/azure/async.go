@@ -31,9 +31,14 @@ import (
 )
 
 const (
-	headerAsyncOperation = "Azure-AsyncOperation"
+	headerAsyncOperation    = "Azure-AsyncOperation"
+	headerOperationLocation = "Operation-Location"
 )
 
+// futureStateVersion is the version of the serialized form of a Future.
+// increment it when making changes that older versions can't read.
+const futureStateVersion = 1
+
 const (
 	operationInProgress string = "InProgress"
 	operationCanceled   string = "Canceled"
@@ -102,6 +107,18 @@ func NewFutureFromResponse(resp *http.Response) (Future, error) {
 	return Future{pt: pt}, err
 }
 
+// NewFutureFromResponseWithFinalStateVia returns a new Future object initialized with the initial
+// response from an asynchronous operation.  GetResult retrieves the result from the URL selected
+// by finalStateVia, i.e. the x-ms-long-running-operation-options final-state-via value of the
+// operation.  If the initial response doesn't contain the selected URL the default URL is used.
+func NewFutureFromResponseWithFinalStateVia(resp *http.Response, finalStateVia FinalStateVia) (Future, error) {
+	pt, err := createPollingTracker(resp)
+	if err != nil {
+		return Future{pt: pt}, err
+	}
+	return Future{pt: pt}, pt.initFinalStateVia(finalStateVia)
+}
+
 // Response returns the last HTTP response.
 func (f Future) Response() *http.Response {
 	if f.pt == nil {
@@ -164,13 +181,18 @@ func (f *Future) DoneWithContext(ctx context.Context, sender autorest.Sender) (d
 
 // GetPollingDelay returns a duration the application should wait before checking
 // the status of the asynchronous request and true; this value is returned from
-// the service via the Retry-After response header.  If the header wasn't returned
-// then the function returns the zero-value time.Duration and false.
+// the service via the Retry-After response header.  If the header wasn't returned,
+// or can't be parsed, then the function returns the zero-value time.Duration and false.
+// The value of Retry-After can be either a number of seconds or a date in RFC1123 format.
 func (f Future) GetPollingDelay() (time.Duration, bool) {
 	if f.pt == nil {
 		return 0, false
 	}
-	resp := f.pt.latestResponse()
+	return getRetryAfter(f.pt.latestResponse())
+}
+
+// returns the delay specified by the Retry-After header of the response, if any
+func getRetryAfter(resp *http.Response) (time.Duration, bool) {
 	if resp == nil {
 		return 0, false
 	}
@@ -180,12 +202,18 @@ func (f Future) GetPollingDelay() (time.Duration, bool) {
 		return 0, false
 	}
 
-	d, err := time.ParseDuration(retry + "s")
-	if err != nil {
-		panic(err)
+	if d, err := time.ParseDuration(retry + "s"); err == nil && d >= 0 {
+		return d, true
 	}
-
-	return d, true
+	if t, err := time.Parse(time.RFC1123, retry); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	// fall back to the polling strategy
+	logger.Instance.Writef(logger.LogWarning, "GetPollingDelay: ignoring invalid Retry-After header '%s'\n", retry)
+	return 0, false
 }
 
 // WaitForCompletionRef will return when one of the following conditions is met: the long
@@ -196,6 +224,8 @@ func (f Future) GetPollingDelay() (time.Duration, bool) {
 // used to determine if a default deadline should be used.
 // If PollingDuration is greater than zero the value will be used as the context's timeout.
 // If PollingDuration is zero then no default deadline will be used.
+// In absence of a Retry-After the client's PollingDelay is used between polls, unless the context
+// contains PollingOptions, see WithPollingOptions.
 func (f *Future) WaitForCompletionRef(ctx context.Context, client autorest.Client) (err error) {
 	ctx = tracing.StartSpan(ctx, "github.com/Azure/go-autorest/autorest/azure/async.WaitForCompletionRef")
 	defer func() {
@@ -206,6 +236,12 @@ func (f *Future) WaitForCompletionRef(ctx context.Context, client autorest.Clien
 		}
 		tracing.EndSpan(ctx, sc, err)
 	}()
+	return f.waitForCompletion(ctx, client, nil)
+}
+
+// polls until the LRO completes as described by WaitForCompletionRef.
+// polled, if not nil, is called after every poll with its result and stops the wait when it returns an error.
+func (f *Future) waitForCompletion(ctx context.Context, client autorest.Client, polled func(done bool, err error) error) (err error) {
 	cancelCtx := ctx
 	// if the provided context already has a deadline don't override it
 	_, hasDeadline := ctx.Deadline()
@@ -214,47 +250,152 @@ func (f *Future) WaitForCompletionRef(ctx context.Context, client autorest.Clien
 		cancelCtx, cancel = context.WithTimeout(ctx, d)
 		defer cancel()
 	}
+	options := GetPollingOptions(ctx)
 	// if the initial response has a Retry-After, sleep for the specified amount of time before starting to poll
 	if delay, ok := f.GetPollingDelay(); ok {
 		logger.Instance.Writeln(logger.LogInfo, "WaitForCompletionRef: initial polling delay")
-		if delayElapsed := autorest.DelayForBackoff(delay, 0, cancelCtx.Done()); !delayElapsed {
+		if delayElapsed := delayForPolling(options.clamp(delay), cancelCtx.Done()); !delayElapsed {
 			err = cancelCtx.Err()
 			return
 		}
 	}
 	done, err := f.DoneWithContext(ctx, client)
-	for attempts := 0; !done; done, err = f.DoneWithContext(ctx, client) {
+	for attempts, polls := 0, 0; ; done, err = f.DoneWithContext(ctx, client) {
+		if polled != nil {
+			if perr := polled(done, err); perr != nil {
+				return perr
+			}
+		}
+		if done {
+			return
+		}
 		if attempts >= client.RetryAttempts {
 			return autorest.NewErrorWithError(err, "Future", "WaitForCompletion", f.pt.latestResponse(), "the number of retries has been exceeded")
 		}
-		// we want delayAttempt to be zero in the non-error case so
-		// that DelayForBackoff doesn't perform exponential back-off
-		var delayAttempt int
-		var delay time.Duration
+		var delayElapsed bool
 		if err == nil {
-			// check for Retry-After delay, if not present use the client's polling delay
-			var ok bool
-			delay, ok = f.GetPollingDelay()
-			if !ok {
-				logger.Instance.Writeln(logger.LogInfo, "WaitForCompletionRef: Using client polling delay")
-				delay = client.PollingDelay
-			}
+			// check for Retry-After delay, if not present use the polling strategy
+			delay := options.nextDelay(f, client, polls)
+			polls++
+			// wait until the delay elapses or the context is cancelled
+			delayElapsed = delayForPolling(delay, cancelCtx.Done())
 		} else {
 			// there was an error polling for status so perform exponential
 			// back-off based on the number of attempts using the client's retry
-			// duration.  update attempts after delayAttempt to avoid off-by-one.
-			logger.Instance.Writef(logger.LogError, "WaitForCompletionRef: %s\n", err)
-			delayAttempt = attempts
-			delay = client.RetryDuration
+			// duration.  update attempts after the delay to avoid off-by-one.
+			logger.Instance.Writef(logger.LogError, "WaitForCompletionRef: %s%s\n", err, correlationLog(operationCorrelationID(ctx, f.pt.latestResponse())))
+			delayElapsed = autorest.DelayForBackoff(client.RetryDuration, attempts, cancelCtx.Done())
 			attempts++
 		}
-		// wait until the delay elapses or the context is cancelled
-		delayElapsed := autorest.DelayForBackoff(delay, delayAttempt, cancelCtx.Done())
 		if !delayElapsed {
 			return autorest.NewErrorWithError(cancelCtx.Err(), "Future", "WaitForCompletion", f.pt.latestResponse(), "context has been cancelled")
 		}
 	}
-	return
+}
+
+// waits for the delay between polls, returns false if the wait was cancelled
+func delayForPolling(delay time.Duration, cancel <-chan struct{}) bool {
+	logger.Instance.Writef(logger.LogInfo, "WaitForCompletionRef: polling in %s\n", delay)
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-cancel:
+		return false
+	}
+}
+
+// PollingProgress describes the state of a long-running operation after a poll.
+type PollingProgress struct {
+	// Status is the status of the operation as returned from the service.
+	Status string
+
+	// PercentComplete is the percentage of the operation that has completed.
+	// It's nil when the service doesn't report it.
+	PercentComplete *float64
+
+	// StartTime is the time the operation started, as reported by the service.
+	StartTime *time.Time
+
+	// EndTime is the time the operation ended, as reported by the service.
+	EndTime *time.Time
+
+	// RetryAfter is the delay requested by the service before the next poll, or zero.
+	RetryAfter time.Duration
+
+	// Elapsed is the time elapsed since the wait started.
+	Elapsed time.Duration
+
+	// Attempt is the number of polls made, starting at one.
+	Attempt int
+
+	// Done is true when the operation has reached a terminal state.
+	Done bool
+
+	// Err is the error returned by the poll, if any.  Failed polls are retried
+	// unless the operation has terminated.
+	Err error
+}
+
+// WaitForCompletionWithProgress behaves like WaitForCompletionRef and calls progress after every poll
+// with the state of the operation, e.g. to report the completion of a long-running operation to a user.
+// The callback is called on the waiting goroutine so it should return quickly.
+func (f *Future) WaitForCompletionWithProgress(ctx context.Context, client autorest.Client, progress func(PollingProgress)) (err error) {
+	ctx = tracing.StartSpan(ctx, "github.com/Azure/go-autorest/autorest/azure/async.WaitForCompletionWithProgress")
+	defer func() {
+		sc := -1
+		resp := f.Response()
+		if resp != nil {
+			sc = resp.StatusCode
+		}
+		tracing.EndSpan(ctx, sc, err)
+	}()
+	start := time.Now()
+	attempt := 0
+	return f.waitForCompletion(ctx, client, func(done bool, err error) error {
+		attempt++
+		p := f.progress()
+		p.Elapsed = time.Since(start)
+		p.Attempt = attempt
+		p.Done = done
+		p.Err = err
+		progress(p)
+		return nil
+	})
+}
+
+// returns the progress reported in the latest response
+func (f Future) progress() PollingProgress {
+	p := PollingProgress{
+		Status: f.Status(),
+	}
+	if d, ok := f.GetPollingDelay(); ok {
+		p.RetryAfter = d
+	}
+	if f.pt == nil {
+		return p
+	}
+	body := f.pt.latestBody()
+	if pc, ok := body["percentComplete"].(float64); ok {
+		p.PercentComplete = &pc
+	}
+	p.StartTime = parseOperationTime(body["startTime"])
+	p.EndTime = parseOperationTime(body["endTime"])
+	return p
+}
+
+// parses a time from an operation status body, returns nil if it's missing or malformed
+func parseOperationTime(v interface{}) *time.Time {
+	s, ok := v.(string)
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil
+	}
+	return &t
 }
 
 // MarshalJSON implements the json.Marshaler interface.
@@ -263,31 +404,54 @@ func (f Future) MarshalJSON() ([]byte, error) {
 }
 
 // UnmarshalJSON implements the json.Unmarshaler interface.
+// The state is validated so that a future persisted by an incompatible version, or
+// one that's been corrupted, is rejected instead of failing while polling.
 func (f *Future) UnmarshalJSON(data []byte) error {
-	// unmarshal into JSON object to determine the tracker type
+	// unmarshal into JSON object to determine the version and tracker type
 	obj := map[string]interface{}{}
 	err := json.Unmarshal(data, &obj)
 	if err != nil {
 		return err
 	}
+	// futures persisted before versioning was introduced don't have a version
+	if v, ok := obj["version"]; ok {
+		version, ok := v.(float64)
+		if !ok {
+			return autorest.NewError("Future", "UnmarshalJSON", "the 'version' property must be a number")
+		}
+		if version < 1 || version > futureStateVersion {
+			return autorest.NewError("Future", "UnmarshalJSON", "unsupported version %v, the maximum supported version is %d", version, futureStateVersion)
+		}
+	}
 	if obj["method"] == nil {
 		return autorest.NewError("Future", "UnmarshalJSON", "missing 'method' property")
 	}
-	method := obj["method"].(string)
+	method, ok := obj["method"].(string)
+	if !ok {
+		return autorest.NewError("Future", "UnmarshalJSON", "the 'method' property must be a string")
+	}
+	var pt pollingTracker
 	switch strings.ToUpper(method) {
 	case http.MethodDelete:
-		f.pt = &pollingTrackerDelete{}
+		pt = &pollingTrackerDelete{}
 	case http.MethodPatch:
-		f.pt = &pollingTrackerPatch{}
+		pt = &pollingTrackerPatch{}
 	case http.MethodPost:
-		f.pt = &pollingTrackerPost{}
+		pt = &pollingTrackerPost{}
 	case http.MethodPut:
-		f.pt = &pollingTrackerPut{}
+		pt = &pollingTrackerPut{}
 	default:
 		return autorest.NewError("Future", "UnmarshalJSON", "unsupoorted method '%s'", method)
 	}
 	// now unmarshal into the tracker
-	return json.Unmarshal(data, &f.pt)
+	if err = json.Unmarshal(data, &pt); err != nil {
+		return err
+	}
+	if err = pt.validateState(); err != nil {
+		return err
+	}
+	f.pt = pt
+	return nil
 }
 
 // PollingURL returns the URL used for retrieving the status of the long-running operation.
@@ -314,6 +478,7 @@ func (f Future) GetResult(sender autorest.Sender) (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
+	setCorrelationID(req, ExtractCorrelationID(f.pt.latestResponse()))
 	resp, err := sender.Do(req)
 	if err == nil && resp.Body != nil {
 		// copy the body and close it so callers don't have to
@@ -348,6 +513,12 @@ type pollingTracker interface {
 	// initializes the tracker's internal state, call this when the tracker is created
 	initializeState() error
 
+	// validates the state of a tracker after it's been unmarshalled
+	validateState() error
+
+	// selects the URL for the final GET from the initial response, call this when the tracker is created
+	initFinalStateVia(finalStateVia FinalStateVia) error
+
 	// makes an HTTP request to check the status of the LRO
 	pollForStatus(ctx context.Context, sender autorest.Sender) error
 
@@ -380,12 +551,18 @@ type pollingTracker interface {
 
 	// returns the cached HTTP response after a call to pollForStatus(), can be nil
 	latestResponse() *http.Response
+
+	// returns the unmarshalled body of the cached HTTP response, can be nil
+	latestBody() map[string]interface{}
 }
 
 type pollingTrackerBase struct {
 	// resp is the last response, either from the submission of the LRO or from polling
 	resp *http.Response
 
+	// the version of the serialized form, see futureStateVersion
+	Version int `json:"version"`
+
 	// method is the HTTP verb, this is needed for deserialization
 	Method string `json:"method"`
 
@@ -406,11 +583,21 @@ type pollingTrackerBase struct {
 
 	// used to hold an error object returned from the service
 	Err *ServiceError `json:"error,omitempty"`
+
+	// the final-state-via option selected by the caller, if any
+	FinalStateVia FinalStateVia `json:"finalStateVia,omitempty"`
+
+	// the URL for the final GET selected by FinalStateVia
+	FinalStateViaURI string `json:"finalStateViaURI,omitempty"`
+
+	// the URL of the resource returned in the body of an Operation-Location status
+	ResourceLocationURI string `json:"resourceLocationURI,omitempty"`
 }
 
 func (pt *pollingTrackerBase) initializeState() error {
 	// determine the initial polling state based on response body and/or HTTP status
 	// code.  this is applicable to the initial LRO response, not polling responses!
+	pt.Version = futureStateVersion
 	pt.Method = pt.resp.Request.Method
 	if err := pt.updateRawBody(); err != nil {
 		return err
@@ -444,11 +631,59 @@ func (pt *pollingTrackerBase) initializeState() error {
 	return pt.initPollingMethod()
 }
 
+func (pt *pollingTrackerBase) validateState() error {
+	switch pt.Pm {
+	case PollingAsyncOperation, PollingOperationLocation, PollingLocation, PollingRequestURI, PollingUnknown:
+	default:
+		return autorest.NewError("pollingTrackerBase", "validateState", "unsupported polling method '%s'", pt.Pm)
+	}
+	switch pt.FinalStateVia {
+	case FinalStateViaDefault, FinalStateViaAzureAsyncOperation, FinalStateViaLocation, FinalStateViaOperationLocation, FinalStateViaOriginalURI:
+	default:
+		return autorest.NewError("pollingTrackerBase", "validateState", "unsupported final-state-via '%s'", pt.FinalStateVia)
+	}
+	// an LRO that hasn't terminated can only be resumed with a URL to poll
+	if !pt.hasTerminated() && !isValidURL(pt.URI) {
+		return autorest.NewError("pollingTrackerBase", "validateState", "invalid polling URL '%s'", pt.URI)
+	}
+	for _, u := range []string{pt.FinalGetURI, pt.FinalStateViaURI, pt.ResourceLocationURI} {
+		if u != "" && !isValidURL(u) {
+			return autorest.NewError("pollingTrackerBase", "validateState", "invalid result URL '%s'", u)
+		}
+	}
+	// the state is upgraded to the current version
+	pt.Version = futureStateVersion
+	return nil
+}
+
+func (pt *pollingTrackerBase) initFinalStateVia(finalStateVia FinalStateVia) error {
+	var u string
+	var err error
+	switch finalStateVia {
+	case FinalStateViaDefault:
+		return nil
+	case FinalStateViaAzureAsyncOperation:
+		u, err = getURLFromAsyncOpHeader(pt.resp)
+	case FinalStateViaLocation:
+		u, err = getURLFromLocationHeader(pt.resp)
+	case FinalStateViaOperationLocation:
+		u, err = getURLFromOperationLocationHeader(pt.resp)
+	case FinalStateViaOriginalURI:
+		u = pt.resp.Request.URL.String()
+	default:
+		return autorest.NewError("pollingTrackerBase", "initFinalStateVia", "unsupported final-state-via '%s'", finalStateVia)
+	}
+	if err != nil {
+		return err
+	}
+	pt.FinalStateVia = finalStateVia
+	pt.FinalStateViaURI = u
+	return nil
+}
+
 func (pt pollingTrackerBase) getProvisioningState() *string {
-	if pt.rawBody != nil && pt.rawBody["properties"] != nil {
-		p := pt.rawBody["properties"].(map[string]interface{})
-		if ps := p["provisioningState"]; ps != nil {
-			s := ps.(string)
+	if p, ok := pt.rawBody["properties"].(map[string]interface{}); ok {
+		if s, ok := p["provisioningState"].(string); ok {
 			return &s
 		}
 	}
@@ -488,6 +723,8 @@ func (pt *pollingTrackerBase) pollForStatus(ctx context.Context, sender autorest
 	if err != nil {
 		return autorest.NewErrorWithError(err, "pollingTrackerBase", "pollForStatus", nil, "failed preparing HTTP request")
 	}
+	// polls are part of the operation that returned the previous response
+	setCorrelationID(req, operationCorrelationID(ctx, pt.resp))
 	pt.resp, err = sender.Do(req)
 	if err != nil {
 		return autorest.NewErrorWithError(err, "pollingTrackerBase", "pollForStatus", nil, "failed to send HTTP request")
@@ -561,8 +798,18 @@ Default:
 }
 
 func (pt *pollingTrackerBase) updatePollingState(provStateApl bool) error {
-	if pt.Pm == PollingAsyncOperation && pt.rawBody["status"] != nil {
-		pt.State = pt.rawBody["status"].(string)
+	if pt.Pm == PollingOperationLocation {
+		// the status body can contain the location of the created or updated resource
+		if rl, ok := pt.rawBody["resourceLocation"].(string); ok && isValidURL(rl) {
+			pt.ResourceLocationURI = rl
+		}
+	}
+	if pt.usesStatusBody() && pt.rawBody["status"] != nil {
+		status, ok := pt.rawBody["status"].(string)
+		if !ok {
+			return autorest.NewError("pollingTrackerBase", "updatePollingState", "the status property in the response body must be a string")
+		}
+		pt.State = status
 	} else {
 		if pt.resp.StatusCode == http.StatusAccepted {
 			pt.State = operationInProgress
@@ -603,7 +850,21 @@ func (pt pollingTrackerBase) pollingURL() string {
 }
 
 func (pt pollingTrackerBase) finalGetURL() string {
-	return pt.FinalGetURI
+	switch {
+	case pt.FinalStateVia == FinalStateViaDefault, pt.FinalStateViaURI == "":
+		if pt.ResourceLocationURI != "" {
+			return pt.ResourceLocationURI
+		}
+		return pt.FinalGetURI
+	case pt.FinalStateVia == FinalStateViaOperationLocation && pt.ResourceLocationURI != "":
+		return pt.ResourceLocationURI
+	}
+	return pt.FinalStateViaURI
+}
+
+// returns true if the LRO status is polled from an operation status resource
+func (pt pollingTrackerBase) usesStatusBody() bool {
+	return pt.Pm == PollingAsyncOperation || pt.Pm == PollingOperationLocation
 }
 
 func (pt pollingTrackerBase) hasTerminated() bool {
@@ -622,15 +883,19 @@ func (pt pollingTrackerBase) latestResponse() *http.Response {
 	return pt.resp
 }
 
+func (pt pollingTrackerBase) latestBody() map[string]interface{} {
+	return pt.rawBody
+}
+
 // error checking common to all trackers
 func (pt pollingTrackerBase) baseCheckForErrors() error {
-	// for Azure-AsyncOperations the response body cannot be nil or empty
-	if pt.Pm == PollingAsyncOperation {
+	// for Azure-AsyncOperation and Operation-Location the response body cannot be nil or empty
+	if pt.usesStatusBody() {
 		if pt.resp.Body == nil || pt.resp.ContentLength == 0 {
-			return autorest.NewError("pollingTrackerBase", "baseCheckForErrors", "for Azure-AsyncOperation response body cannot be nil")
+			return autorest.NewError("pollingTrackerBase", "baseCheckForErrors", "for %s response body cannot be nil", pt.Pm)
 		}
 		if pt.rawBody["status"] == nil {
-			return autorest.NewError("pollingTrackerBase", "baseCheckForErrors", "missing status property in Azure-AsyncOperation response body")
+			return autorest.NewError("pollingTrackerBase", "baseCheckForErrors", "missing status property in %s response body", pt.Pm)
 		}
 	}
 	return nil
@@ -638,11 +903,11 @@ func (pt pollingTrackerBase) baseCheckForErrors() error {
 
 // default initialization of polling URL/method.  each verb tracker will update this as required.
 func (pt *pollingTrackerBase) initPollingMethod() error {
-	if ao, err := getURLFromAsyncOpHeader(pt.resp); err != nil {
+	if ao, pm, err := getURLFromOperationHeaders(pt.resp); err != nil {
 		return err
 	} else if ao != "" {
 		pt.URI = ao
-		pt.Pm = PollingAsyncOperation
+		pt.Pm = pm
 		return nil
 	}
 	if lh, err := getURLFromLocationHeader(pt.resp); err != nil {
@@ -677,12 +942,12 @@ func (pt *pollingTrackerDelete) updatePollingMethod() error {
 	}
 	// for 202 prefer the Azure-AsyncOperation header but fall back to Location if necessary
 	if pt.resp.StatusCode == http.StatusAccepted {
-		ao, err := getURLFromAsyncOpHeader(pt.resp)
+		ao, pm, err := getURLFromOperationHeaders(pt.resp)
 		if err != nil {
 			return err
 		} else if ao != "" {
 			pt.URI = ao
-			pt.Pm = PollingAsyncOperation
+			pt.Pm = pm
 		}
 		// if the Location header is invalid and we already have a polling URL
 		// then we don't care if the Location header URL is malformed.
@@ -731,22 +996,22 @@ func (pt *pollingTrackerPatch) updatePollingMethod() error {
 	}
 	// for 201 it's permissible for no headers to be returned
 	if pt.resp.StatusCode == http.StatusCreated {
-		if ao, err := getURLFromAsyncOpHeader(pt.resp); err != nil {
+		if ao, pm, err := getURLFromOperationHeaders(pt.resp); err != nil {
 			return err
 		} else if ao != "" {
 			pt.URI = ao
-			pt.Pm = PollingAsyncOperation
+			pt.Pm = pm
 		}
 	}
 	// for 202 prefer the Azure-AsyncOperation header but fall back to Location if necessary
 	// note the absence of the "final GET" mechanism for PATCH
 	if pt.resp.StatusCode == http.StatusAccepted {
-		ao, err := getURLFromAsyncOpHeader(pt.resp)
+		ao, pm, err := getURLFromOperationHeaders(pt.resp)
 		if err != nil {
 			return err
 		} else if ao != "" {
 			pt.URI = ao
-			pt.Pm = PollingAsyncOperation
+			pt.Pm = pm
 		}
 		if ao == "" {
 			if lh, err := getURLFromLocationHeader(pt.resp); err != nil {
@@ -791,12 +1056,12 @@ func (pt *pollingTrackerPost) updatePollingMethod() error {
 	}
 	// for 202 prefer the Azure-AsyncOperation header but fall back to Location if necessary
 	if pt.resp.StatusCode == http.StatusAccepted {
-		ao, err := getURLFromAsyncOpHeader(pt.resp)
+		ao, pm, err := getURLFromOperationHeaders(pt.resp)
 		if err != nil {
 			return err
 		} else if ao != "" {
 			pt.URI = ao
-			pt.Pm = PollingAsyncOperation
+			pt.Pm = pm
 		}
 		// if the Location header is invalid and we already have a polling URL
 		// then we don't care if the Location header URL is malformed.
@@ -845,21 +1110,21 @@ func (pt *pollingTrackerPut) updatePollingMethod() error {
 	}
 	// for 201 it's permissible for no headers to be returned
 	if pt.resp.StatusCode == http.StatusCreated {
-		if ao, err := getURLFromAsyncOpHeader(pt.resp); err != nil {
+		if ao, pm, err := getURLFromOperationHeaders(pt.resp); err != nil {
 			return err
 		} else if ao != "" {
 			pt.URI = ao
-			pt.Pm = PollingAsyncOperation
+			pt.Pm = pm
 		}
 	}
 	// for 202 prefer the Azure-AsyncOperation header but fall back to Location if necessary
 	if pt.resp.StatusCode == http.StatusAccepted {
-		ao, err := getURLFromAsyncOpHeader(pt.resp)
+		ao, pm, err := getURLFromOperationHeaders(pt.resp)
 		if err != nil {
 			return err
 		} else if ao != "" {
 			pt.URI = ao
-			pt.Pm = PollingAsyncOperation
+			pt.Pm = pm
 		}
 		// if the Location header is invalid and we already have a polling URL
 		// then we don't care if the Location header URL is malformed.
@@ -885,7 +1150,7 @@ func (pt pollingTrackerPut) checkForErrors() error {
 		return err
 	}
 	// if there are no LRO headers then the body cannot be empty
-	ao, err := getURLFromAsyncOpHeader(pt.resp)
+	ao, _, err := getURLFromOperationHeaders(pt.resp)
 	if err != nil {
 		return err
 	}
@@ -940,6 +1205,36 @@ func getURLFromAsyncOpHeader(resp *http.Response) (string, error) {
 	return s, nil
 }
 
+// gets the polling URL from the Operation-Location header.
+// ensures the URL is well-formed and absolute.
+func getURLFromOperationLocationHeader(resp *http.Response) (string, error) {
+	s := resp.Header.Get(http.CanonicalHeaderKey(headerOperationLocation))
+	if s == "" {
+		return "", nil
+	}
+	if !isValidURL(s) {
+		return "", autorest.NewError("azure", "getURLFromOperationLocationHeader", "invalid polling URL '%s'", s)
+	}
+	return s, nil
+}
+
+// gets the polling URL and method from the Azure-AsyncOperation or Operation-Location
+// header, Azure-AsyncOperation is preferred when both headers are returned.
+func getURLFromOperationHeaders(resp *http.Response) (string, PollingMethodType, error) {
+	if ao, err := getURLFromAsyncOpHeader(resp); err != nil {
+		return "", PollingUnknown, err
+	} else if ao != "" {
+		return ao, PollingAsyncOperation, nil
+	}
+	ol, err := getURLFromOperationLocationHeader(resp)
+	if err != nil {
+		return "", PollingUnknown, err
+	} else if ol != "" {
+		return ol, PollingOperationLocation, nil
+	}
+	return "", PollingUnknown, nil
+}
+
 // gets the polling URL from the Location header.
 // ensures the URL is well-formed and absolute.
 func getURLFromLocationHeader(resp *http.Response) (string, error) {
@@ -969,6 +1264,9 @@ const (
 	// PollingLocation indicates the polling method uses the Location header.
 	PollingLocation PollingMethodType = "Location"
 
+	// PollingOperationLocation indicates the polling method uses the Operation-Location header.
+	PollingOperationLocation PollingMethodType = "OperationLocation"
+
 	// PollingRequestURI indicates the polling method uses the original request URI.
 	PollingRequestURI PollingMethodType = "RequestURI"
 
@@ -976,6 +1274,29 @@ const (
 	PollingUnknown PollingMethodType = ""
 )
 
+// FinalStateVia defines a type used for enumerating the URLs the result of a long-running
+// operation can be retrieved from, see the x-ms-long-running-operation-options extension.
+type FinalStateVia string
+
+const (
+	// FinalStateViaAzureAsyncOperation retrieves the result from the Azure-AsyncOperation URL.
+	FinalStateViaAzureAsyncOperation FinalStateVia = "azure-async-operation"
+
+	// FinalStateViaLocation retrieves the result from the Location URL.
+	FinalStateViaLocation FinalStateVia = "location"
+
+	// FinalStateViaOperationLocation retrieves the result from the resourceLocation in the
+	// operation status, or from the Operation-Location URL when there's no resourceLocation.
+	FinalStateViaOperationLocation FinalStateVia = "operation-location"
+
+	// FinalStateViaOriginalURI retrieves the result from the URL of the initial request.
+	FinalStateViaOriginalURI FinalStateVia = "original-uri"
+
+	// FinalStateViaDefault retrieves the result from the URL selected based on the HTTP method and
+	// the response headers, and is the default value.
+	FinalStateViaDefault FinalStateVia = ""
+)
+
 // AsyncOpIncompleteError is the type that's returned from a future that has not completed.
 type AsyncOpIncompleteError struct {
 	// FutureType is the name of the type composed of a azure.Future.
@@ -705,6 +705,59 @@ func TestFuture_MarshallingWithError(t *testing.T) {
 	}
 }
 
+func TestFuture_UnmarshalLegacyState(t *testing.T) {
+	// state persisted before versioning was introduced
+	data := `{"method":"PUT","pollingMethod":"AsyncOperation","pollingURI":"https://somewhere.com/operationResource","lroState":"InProgress","resultURI":"https://somewhere.com/resource"}`
+	var future Future
+	if err := json.Unmarshal([]byte(data), &future); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if v := future.pt.(*pollingTrackerPut).Version; v != futureStateVersion {
+		t.Fatalf("expected version %d, got %d", futureStateVersion, v)
+	}
+	if future.PollingURL() != "https://somewhere.com/operationResource" {
+		t.Fatalf("unexpected polling URL %s", future.PollingURL())
+	}
+}
+
+func TestFuture_UnmarshalInvalidState(t *testing.T) {
+	testCases := map[string]string{
+		"method not a string":   `{"version":1,"method":1}`,
+		"missing method":        `{"version":1}`,
+		"unsupported method":    `{"version":1,"method":"GET"}`,
+		"version not a number":  `{"version":"1","method":"PUT"}`,
+		"newer version":         `{"version":99,"method":"PUT","pollingMethod":"AsyncOperation","pollingURI":"https://somewhere.com/operationResource","lroState":"InProgress"}`,
+		"unknown polling":       `{"version":1,"method":"PUT","pollingMethod":"Carrier-Pigeon","pollingURI":"https://somewhere.com/operationResource","lroState":"InProgress"}`,
+		"missing polling URL":   `{"version":1,"method":"PUT","pollingMethod":"AsyncOperation","lroState":"InProgress"}`,
+		"relative polling URL":  `{"version":1,"method":"PUT","pollingMethod":"AsyncOperation","pollingURI":"/operationResource","lroState":"InProgress"}`,
+		"relative result URL":   `{"version":1,"method":"PUT","pollingMethod":"AsyncOperation","pollingURI":"https://somewhere.com/operationResource","lroState":"InProgress","resultURI":"resource"}`,
+		"invalid property type": `{"version":1,"method":"PUT","pollingMethod":"AsyncOperation","pollingURI":42,"lroState":"InProgress"}`,
+	}
+	for name, data := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var future Future
+			if err := json.Unmarshal([]byte(data), &future); err == nil {
+				t.Fatal("expected an error")
+			}
+			if future.pt != nil {
+				t.Fatal("expected the future to be left uninitialized")
+			}
+		})
+	}
+}
+
+func TestFuture_UnmarshalTerminalStateWithoutPollingURL(t *testing.T) {
+	data := `{"version":1,"method":"POST","pollingMethod":"","lroState":"Succeeded"}`
+	var future Future
+	if err := json.Unmarshal([]byte(data), &future); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	done, err := future.DoneWithContext(context.Background(), mocks.NewSender())
+	if !done || err != nil {
+		t.Fatalf("expected a completed future, got %v, %v", done, err)
+	}
+}
+
 func TestFuture_CreateFromFailedOperation(t *testing.T) {
 	_, err := NewFutureFromResponse(newAsyncResponseWithError(http.MethodPut))
 	if err == nil {
@@ -745,6 +798,57 @@ func TestFuture_WaitForCompletionRef(t *testing.T) {
 		autorest.ByClosing())
 }
 
+func TestFuture_WaitForCompletionWithProgress(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendResponse(mocks.NewResponseWithStatus("500 Internal Server Error", http.StatusInternalServerError))
+	sender.AppendAndRepeatResponse(newOperationResourceResponse("busy"), 2)
+	sender.AppendResponse(newOperationResourceResponse(operationSucceeded))
+	client := autorest.Client{
+		PollingDelay:    1 * time.Second,
+		PollingDuration: autorest.DefaultPollingDuration,
+		RetryAttempts:   autorest.DefaultRetryAttempts,
+		RetryDuration:   10 * time.Millisecond,
+		Sender:          sender,
+	}
+	future, err := NewFutureFromResponse(newSimpleAsyncResp())
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	var updates []PollingProgress
+	err = future.WaitForCompletionWithProgress(context.Background(), client, func(p PollingProgress) {
+		updates = append(updates, p)
+	})
+	if err != nil {
+		t.Fatalf("WaitForCompletionWithProgress failed: %v", err)
+	}
+	if len(updates) != 4 {
+		t.Fatalf("expected 4 updates, got %d", len(updates))
+	}
+	if updates[0].Err == nil || updates[0].Done {
+		t.Fatalf("expected a failed poll, got %+v", updates[0])
+	}
+	busy := updates[1]
+	if busy.Status != "busy" || busy.Done || busy.Err != nil || busy.Attempt != 2 {
+		t.Fatalf("unexpected progress %+v", busy)
+	}
+	if busy.PercentComplete == nil || *busy.PercentComplete != 50 {
+		t.Fatalf("unexpected percent complete %v", busy.PercentComplete)
+	}
+	if busy.StartTime == nil || !busy.StartTime.Equal(time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)) {
+		t.Fatalf("unexpected start time %v", busy.StartTime)
+	}
+	if busy.EndTime == nil || busy.RetryAfter != retryDelay {
+		t.Fatalf("unexpected progress %+v", busy)
+	}
+	last := updates[3]
+	if last.Status != operationSucceeded || !last.Done || last.Attempt != 4 {
+		t.Fatalf("unexpected progress %+v", last)
+	}
+	if last.Elapsed < busy.Elapsed {
+		t.Fatalf("elapsed time went backwards: %v < %v", last.Elapsed, busy.Elapsed)
+	}
+}
+
 func TestFuture_WaitForCompletionRefWithRetryAfter(t *testing.T) {
 	r2 := newOperationResourceResponse("busy")
 	r3 := newOperationResourceResponse(operationSucceeded)
@@ -885,6 +989,206 @@ func TestFuture_GetResultFromNonAsyncOperation(t *testing.T) {
 	}
 }
 
+const (
+	testOperationLocationURL = "https://microsoft.com/a/b/c/operations/1"
+	testResourceLocationURL  = "https://microsoft.com/a/b/c/resources/1"
+)
+
+// creates an operation status response as returned by the Operation-Location URL
+func newOperationLocationStatusResponse(status, resourceLocation string) *http.Response {
+	body := fmt.Sprintf(`{"id":"1","status":"%s"}`, status)
+	if resourceLocation != "" {
+		body = fmt.Sprintf(`{"id":"1","status":"%s","resourceLocation":"%s"}`, status, resourceLocation)
+	}
+	return mocks.NewResponseWithBodyAndStatus(mocks.NewBody(body), http.StatusOK, status)
+}
+
+func TestCreatePutTracker202SuccessOperationLocation(t *testing.T) {
+	resp := newAsyncResp(newAsyncReq(http.MethodPut, nil), http.StatusAccepted, mocks.NewBody(""))
+	mocks.SetResponseHeader(resp, headerOperationLocation, testOperationLocationURL)
+	pt, err := createPollingTracker(resp)
+	if err != nil {
+		t.Fatalf("failed to create tracker: %v", err)
+	}
+	if pm := pt.pollingMethod(); pm != PollingOperationLocation {
+		t.Fatalf("wrong polling method: %s", pm)
+	}
+	if u := pt.pollingURL(); u != testOperationLocationURL {
+		t.Fatalf("wrong polling URL: %s", u)
+	}
+	if u := pt.finalGetURL(); u != mocks.TestURL {
+		t.Fatalf("wrong final GET URL: %s", u)
+	}
+}
+
+func TestCreatePutTracker202PrefersAsyncOpOverOperationLocation(t *testing.T) {
+	resp := newAsyncResp(newAsyncReq(http.MethodPut, nil), http.StatusAccepted, mocks.NewBody(""))
+	setAsyncOpHeader(resp, mocks.TestAzureAsyncURL)
+	mocks.SetResponseHeader(resp, headerOperationLocation, testOperationLocationURL)
+	pt, err := createPollingTracker(resp)
+	if err != nil {
+		t.Fatalf("failed to create tracker: %v", err)
+	}
+	if pm := pt.pollingMethod(); pm != PollingAsyncOperation {
+		t.Fatalf("wrong polling method: %s", pm)
+	}
+}
+
+func TestCreatePatchTracker202FailBadOperationLocation(t *testing.T) {
+	resp := newAsyncResp(newAsyncReq(http.MethodPatch, nil), http.StatusAccepted, mocks.NewBody(""))
+	mocks.SetResponseHeader(resp, headerOperationLocation, mocks.TestBadURL)
+	if _, err := createPollingTracker(resp); err == nil {
+		t.Fatal("unexpected nil error")
+	}
+}
+
+func TestFuture_OperationLocationResourceLocation(t *testing.T) {
+	resp := newAsyncResp(newAsyncReq(http.MethodPost, nil), http.StatusAccepted, mocks.NewBody(""))
+	mocks.SetResponseHeader(resp, headerOperationLocation, testOperationLocationURL)
+	future, err := NewFutureFromResponse(resp)
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	sender := mocks.NewSender()
+	sender.AppendResponse(newOperationLocationStatusResponse("Running", ""))
+	sender.AppendResponse(newOperationLocationStatusResponse("Succeeded", testResourceLocationURL))
+	sender.AppendResponse(mocks.NewResponseWithBodyAndStatus(mocks.NewBody(someResource), http.StatusOK, "OK"))
+	client := autorest.Client{
+		PollingDelay:  retryDelay,
+		RetryAttempts: autorest.DefaultRetryAttempts,
+		RetryDuration: retryDelay,
+		Sender:        sender,
+	}
+	if err = future.WaitForCompletionRef(context.Background(), client); err != nil {
+		t.Fatalf("WaitForCompletionRef failed: %v", err)
+	}
+	if future.Status() != operationSucceeded {
+		t.Fatalf("unexpected status %s", future.Status())
+	}
+	res, err := future.GetResult(sender)
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	if u := res.Request.URL.String(); u != testResourceLocationURL {
+		t.Fatalf("expected the result from %s, got %s", testResourceLocationURL, u)
+	}
+}
+
+func TestFuture_OperationLocationFailed(t *testing.T) {
+	resp := newAsyncResp(newAsyncReq(http.MethodPut, nil), http.StatusAccepted, mocks.NewBody(""))
+	mocks.SetResponseHeader(resp, headerOperationLocation, testOperationLocationURL)
+	future, err := NewFutureFromResponse(resp)
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	sender := mocks.NewSender()
+	sender.AppendResponse(mocks.NewResponseWithBodyAndStatus(mocks.NewBody(`{"status":"Failed","error":{"code":"Boom","message":"it failed"}}`), http.StatusOK, "OK"))
+	done, err := future.DoneWithContext(context.Background(), sender)
+	if !done {
+		t.Fatal("expected the operation to be done")
+	}
+	se, ok := err.(*ServiceError)
+	if !ok || se.Code != "Boom" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestFuture_FinalStateVia(t *testing.T) {
+	newResp := func() *http.Response {
+		resp := newAsyncResp(newAsyncReq(http.MethodPost, nil), http.StatusAccepted, mocks.NewBody(""))
+		mocks.SetResponseHeader(resp, headerOperationLocation, testOperationLocationURL)
+		mocks.SetResponseHeader(resp, autorest.HeaderLocation, mocks.TestLocationURL)
+		return resp
+	}
+	testCases := []struct {
+		finalStateVia    FinalStateVia
+		resourceLocation string
+		expected         string
+	}{
+		{FinalStateViaDefault, "", mocks.TestLocationURL},
+		{FinalStateViaDefault, testResourceLocationURL, testResourceLocationURL},
+		{FinalStateViaLocation, testResourceLocationURL, mocks.TestLocationURL},
+		{FinalStateViaOriginalURI, testResourceLocationURL, mocks.TestURL},
+		{FinalStateViaOperationLocation, "", testOperationLocationURL},
+		{FinalStateViaOperationLocation, testResourceLocationURL, testResourceLocationURL},
+		// the initial response doesn't contain an Azure-AsyncOperation header
+		{FinalStateViaAzureAsyncOperation, "", mocks.TestLocationURL},
+	}
+	for _, tc := range testCases {
+		future, err := NewFutureFromResponseWithFinalStateVia(newResp(), tc.finalStateVia)
+		if err != nil {
+			t.Fatalf("failed to create future: %v", err)
+		}
+		sender := mocks.NewSender()
+		sender.AppendResponse(newOperationLocationStatusResponse("Succeeded", tc.resourceLocation))
+		if _, err = future.DoneWithContext(context.Background(), sender); err != nil {
+			t.Fatalf("failed to poll: %v", err)
+		}
+		// the selection must survive a round-trip through the serialized form
+		data, err := json.Marshal(future)
+		if err != nil {
+			t.Fatalf("failed to marshal: %v", err)
+		}
+		var resumed Future
+		if err = json.Unmarshal(data, &resumed); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+		for _, f := range []Future{future, resumed} {
+			if u := f.pt.finalGetURL(); u != tc.expected {
+				t.Fatalf("%q with resourceLocation %q: expected %s, got %s", tc.finalStateVia, tc.resourceLocation, tc.expected, u)
+			}
+		}
+	}
+}
+
+func TestFuture_UnsupportedFinalStateVia(t *testing.T) {
+	if _, err := NewFutureFromResponseWithFinalStateVia(newSimpleAsyncResp(), FinalStateVia("elsewhere")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestFuture_PropagatesCorrelationID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ctx      context.Context
+		expected string
+	}{
+		{"from the initial response", context.Background(), "initial"},
+		{"from the context", autorest.WithCorrelationID(context.Background(), "context"), "context"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := newSimpleAsyncResp()
+			mocks.SetResponseHeader(resp, HeaderCorrelationRequestID, "initial")
+			future, err := NewFutureFromResponse(resp)
+			if err != nil {
+				t.Fatalf("failed to create future: %v", err)
+			}
+			var ids []string
+			sender := autorest.SenderFunc(func(req *http.Request) (*http.Response, error) {
+				ids = append(ids, req.Header.Get(HeaderCorrelationRequestID))
+				body := fmt.Sprintf(operationResourceFormat, operationSucceeded)
+				if req.URL.String() != mocks.TestAzureAsyncURL {
+					body = fmt.Sprintf(pollingStateFormat, operationSucceeded)
+				}
+				return newAsyncResp(req, http.StatusOK, mocks.NewBody(body)), nil
+			})
+			done, err := future.DoneWithContext(tc.ctx, sender)
+			if err != nil || !done {
+				t.Fatalf("unexpected poll result %v, %v", done, err)
+			}
+			res, err := future.GetResult(sender)
+			if err != nil {
+				t.Fatalf("failed to get the result: %v", err)
+			}
+			res.Body.Close()
+			if len(ids) != 2 || ids[0] != tc.expected || ids[1] != tc.expected {
+				t.Fatalf("unexpected correlation IDs %v, expected %s", ids, tc.expected)
+			}
+		})
+	}
+}
+
 func TestFuture_GetResultNonTerminal(t *testing.T) {
 	resp := newAsyncResp(newAsyncReq(http.MethodDelete, nil), http.StatusAccepted, mocks.NewBody(fmt.Sprintf(operationResourceFormat, operationInProgress)))
 	mocks.SetResponseHeader(resp, headerAsyncOperation, mocks.TestAzureAsyncURL)
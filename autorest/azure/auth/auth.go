@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
 	"strings"
 	"unicode/utf16"
@@ -114,6 +115,10 @@ func GetSettingsFromEnvironment() (s EnvironmentSettings, err error) {
 	}
 	if s.Values[Resource] == "" {
 		s.Values[Resource] = s.Environment.ResourceManagerEndpoint
+		// ADFS issues tokens for the audience configured on the Azure Stack Hub stamp
+		if isADFS(s.Environment.ActiveDirectoryEndpoint, s.Values[TenantID]) && s.Environment.TokenAudience != "" {
+			s.Values[Resource] = s.Environment.TokenAudience
+		}
 	}
 	return
 }
@@ -135,6 +140,9 @@ func (settings EnvironmentSettings) setValue(key string) {
 func (settings EnvironmentSettings) getClientAndTenant() (string, string) {
 	clientID := settings.Values[ClientID]
 	tenantID := settings.Values[TenantID]
+	if isADFS(settings.Environment.ActiveDirectoryEndpoint, tenantID) {
+		tenantID = adfsTenantID
+	}
 	return clientID, tenantID
 }
 
@@ -407,7 +415,7 @@ func (settings FileSettings) ServicePrincipalTokenFromClientCredentialsWithResou
 	if _, ok := settings.Values[ClientSecret]; !ok {
 		return nil, errors.New("missing client secret")
 	}
-	config, err := adal.NewOAuthConfig(settings.getAADEndpoint(), settings.Values[TenantID])
+	config, err := newOAuthConfig(settings.getAADEndpoint(), settings.Values[TenantID])
 	if err != nil {
 		return nil, err
 	}
@@ -587,7 +595,7 @@ type ClientCredentialsConfig struct {
 
 // ServicePrincipalToken creates a ServicePrincipalToken from client credentials.
 func (ccc ClientCredentialsConfig) ServicePrincipalToken() (*adal.ServicePrincipalToken, error) {
-	oauthConfig, err := adal.NewOAuthConfig(ccc.AADEndpoint, ccc.TenantID)
+	oauthConfig, err := newOAuthConfig(ccc.AADEndpoint, ccc.TenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -596,7 +604,7 @@ func (ccc ClientCredentialsConfig) ServicePrincipalToken() (*adal.ServicePrincip
 
 // MultiTenantServicePrincipalToken creates a MultiTenantServicePrincipalToken from client credentials.
 func (ccc ClientCredentialsConfig) MultiTenantServicePrincipalToken() (*adal.MultiTenantServicePrincipalToken, error) {
-	oauthConfig, err := adal.NewMultiTenantOAuthConfig(ccc.AADEndpoint, ccc.TenantID, ccc.AuxTenants, adal.OAuthOptions{})
+	oauthConfig, err := newMultiTenantOAuthConfig(ccc.AADEndpoint, ccc.TenantID, ccc.AuxTenants)
 	if err != nil {
 		return nil, err
 	}
@@ -632,7 +640,7 @@ type ClientCertificateConfig struct {
 
 // ServicePrincipalToken creates a ServicePrincipalToken from client certificate.
 func (ccc ClientCertificateConfig) ServicePrincipalToken() (*adal.ServicePrincipalToken, error) {
-	oauthConfig, err := adal.NewOAuthConfig(ccc.AADEndpoint, ccc.TenantID)
+	oauthConfig, err := newOAuthConfig(ccc.AADEndpoint, ccc.TenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -649,7 +657,7 @@ func (ccc ClientCertificateConfig) ServicePrincipalToken() (*adal.ServicePrincip
 
 // MultiTenantServicePrincipalToken creates a MultiTenantServicePrincipalToken from client certificate.
 func (ccc ClientCertificateConfig) MultiTenantServicePrincipalToken() (*adal.MultiTenantServicePrincipalToken, error) {
-	oauthConfig, err := adal.NewMultiTenantOAuthConfig(ccc.AADEndpoint, ccc.TenantID, ccc.AuxTenants, adal.OAuthOptions{})
+	oauthConfig, err := newMultiTenantOAuthConfig(ccc.AADEndpoint, ccc.TenantID, ccc.AuxTenants)
 	if err != nil {
 		return nil, err
 	}
@@ -699,7 +707,7 @@ func (dfc DeviceFlowConfig) Authorizer() (autorest.Authorizer, error) {
 
 // ServicePrincipalToken gets the service principal token from device flow.
 func (dfc DeviceFlowConfig) ServicePrincipalToken() (*adal.ServicePrincipalToken, error) {
-	oauthConfig, err := adal.NewOAuthConfig(dfc.AADEndpoint, dfc.TenantID)
+	oauthConfig, err := newOAuthConfig(dfc.AADEndpoint, dfc.TenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -728,7 +736,7 @@ type UsernamePasswordConfig struct {
 
 // ServicePrincipalToken creates a ServicePrincipalToken from username and password.
 func (ups UsernamePasswordConfig) ServicePrincipalToken() (*adal.ServicePrincipalToken, error) {
-	oauthConfig, err := adal.NewOAuthConfig(ups.AADEndpoint, ups.TenantID)
+	oauthConfig, err := newOAuthConfig(ups.AADEndpoint, ups.TenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -770,3 +778,44 @@ func (mc MSIConfig) Authorizer() (autorest.Authorizer, error) {
 
 	return autorest.NewBearerAuthorizer(spToken), nil
 }
+
+// the tenant ID used with Active Directory Federation Services
+const adfsTenantID = "adfs"
+
+// returns true if the AAD endpoint or tenant ID refers to ADFS, e.g. on Azure Stack Hub
+// TODO: use adal.IsADFS once the adal dependency is updated
+func isADFS(aadEndpoint, tenantID string) bool {
+	if strings.EqualFold(tenantID, adfsTenantID) {
+		return true
+	}
+	u, err := url.Parse(aadEndpoint)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(strings.Trim(u.Path, "/"), adfsTenantID)
+}
+
+// creates an OAuthConfig for the specified endpoint and tenant.
+// ADFS endpoints use the adfs tenant and don't include the api-version query parameter.
+// TODO: use adal.NewOAuthConfigForADFS once the adal dependency is updated
+func newOAuthConfig(aadEndpoint, tenantID string) (*adal.OAuthConfig, error) {
+	if !isADFS(aadEndpoint, tenantID) {
+		return adal.NewOAuthConfig(aadEndpoint, tenantID)
+	}
+	u, err := url.Parse(aadEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(strings.Trim(u.Path, "/"), adfsTenantID) {
+		u.Path = "/"
+	}
+	return adal.NewOAuthConfigWithAPIVersion(u.String(), adfsTenantID, nil)
+}
+
+// creates a MultiTenantOAuthConfig, auxiliary tenants aren't supported with ADFS
+func newMultiTenantOAuthConfig(aadEndpoint, tenantID string, auxTenants []string) (adal.MultiTenantOAuthConfig, error) {
+	if isADFS(aadEndpoint, tenantID) {
+		return nil, errors.New("auxiliary tenants are not supported with ADFS")
+	}
+	return adal.NewMultiTenantOAuthConfig(aadEndpoint, tenantID, auxTenants, adal.OAuthOptions{})
+}
@@ -320,3 +320,54 @@ func TestMultitenantClientCredentials(t *testing.T) {
 		t.Fatal("authorizer doesn't implement MultiTenantServicePrincipalTokenAuthorizer")
 	}
 }
+
+func TestGetSettingsFromEnvironmentADFS(t *testing.T) {
+	setDefaultEnv()
+	os.Setenv(EnvironmentName, "AZURESTACKCLOUD")
+	os.Setenv(azure.EnvironmentFilepathName, "./testdata/azurestackadfs.json")
+	os.Setenv(Resource, "")
+	defer func() {
+		os.Setenv(EnvironmentName, "")
+		os.Setenv(azure.EnvironmentFilepathName, "")
+	}()
+	settings, err := GetSettingsFromEnvironment()
+	if err != nil {
+		t.Fatalf("failed to get settings: %v", err)
+	}
+	if r := settings.Values[Resource]; r != settings.Environment.TokenAudience {
+		t.Fatalf("expected the token audience as the resource, got %s", r)
+	}
+	ccc, err := settings.GetClientCredentials()
+	if err != nil {
+		t.Fatalf("failed to get client credentials config: %v", err)
+	}
+	if ccc.TenantID != "adfs" {
+		t.Fatalf("unexpected tenant %s", ccc.TenantID)
+	}
+	if _, err := ccc.Authorizer(); err != nil {
+		t.Fatalf("failed to create authorizer: %v", err)
+	}
+	ccc.AuxTenants = []string{"aux-tenant-1"}
+	if _, err := ccc.Authorizer(); err == nil {
+		t.Fatal("expected an error for auxiliary tenants with ADFS")
+	}
+}
+
+func TestNewOAuthConfigADFS(t *testing.T) {
+	for _, endpoint := range []string{"https://adfs.local.azurestack.external/adfs/", "https://adfs.local.azurestack.external/"} {
+		oauthConfig, err := newOAuthConfig(endpoint, "adfs")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if te := oauthConfig.TokenEndpoint.String(); te != "https://adfs.local.azurestack.external/adfs/oauth2/token" {
+			t.Fatalf("unexpected token endpoint %s", te)
+		}
+	}
+	oauthConfig, err := newOAuthConfig("https://login.microsoftonline.com/", "tenant")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if te := oauthConfig.TokenEndpoint.String(); te != "https://login.microsoftonline.com/tenant/oauth2/token?api-version=1.0" {
+		t.Fatalf("unexpected token endpoint %s", te)
+	}
+}
@@ -18,13 +18,16 @@ package azure
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/Azure/go-autorest/autorest"
 )
@@ -43,8 +46,24 @@ const (
 	// HeaderRequestID is the Azure extension header of the service generated request ID returned
 	// in the response.
 	HeaderRequestID = "x-ms-request-id"
+
+	// HeaderCorrelationRequestID is the Azure extension header of the ID that correlates the
+	// operations made by Resource Manager on behalf of a request.
+	HeaderCorrelationRequestID = "x-ms-correlation-request-id"
+
+	// HeaderRoutingRequestID is the Azure extension header identifying the Resource Manager
+	// instance and region that routed the request.
+	HeaderRoutingRequestID = "x-ms-routing-request-id"
 )
 
+// MaxRawErrorBodySize is the maximum number of bytes of an error response body kept in
+// RequestError.RawBody.
+var MaxRawErrorBodySize = 64 * 1024
+
+// the maximum number of bytes of an error response body used as the message of its ServiceError
+// when the body isn't an Azure error, the whole body is kept in RequestError.RawBody
+const maxErrorBodyMessageSize = 512
+
 // ServiceError encapsulates the error response from an Azure service.
 // It adhears to the OData v4 specification for error responses.
 type ServiceError struct {
@@ -182,6 +201,22 @@ type RequestError struct {
 
 	// The request id (from the x-ms-request-id-header) of the request.
 	RequestID string
+
+	// CorrelationRequestID is the value of the x-ms-correlation-request-id header of the response.
+	CorrelationRequestID string `json:"-" xml:"-"`
+
+	// RoutingRequestID is the value of the x-ms-routing-request-id header of the response.
+	RoutingRequestID string `json:"-" xml:"-"`
+
+	// ContentType is the content type of the error response.
+	ContentType string `json:"-" xml:"-"`
+
+	// RawBody is a copy of the error response body, truncated to MaxRawErrorBodySize bytes.
+	// It preserves the payload when the body isn't a compliant error, e.g. an HTML page.
+	RawBody []byte `json:"-" xml:"-"`
+
+	// RawBodyTruncated is true if RawBody has been truncated.
+	RawBodyTruncated bool `json:"-" xml:"-"`
 }
 
 // Error returns a human-friendly error message from service error.
@@ -190,10 +225,69 @@ func (e RequestError) Error() string {
 		e.StatusCode, e.ServiceError)
 }
 
-// IsAzureError returns true if the passed error is an Azure Service error; false otherwise.
+// MarshalJSON implements the json.Marshaler interface for the RequestError type.
+// The error is serialized as a flat, structured object suitable for logs.
+func (e RequestError) MarshalJSON() ([]byte, error) {
+	type requestErrorJSON struct {
+		Message              string        `json:"message"`
+		StatusCode           interface{}   `json:"statusCode,omitempty"`
+		ServiceError         *ServiceError `json:"error,omitempty"`
+		RequestID            string        `json:"requestId,omitempty"`
+		CorrelationRequestID string        `json:"correlationRequestId,omitempty"`
+		RoutingRequestID     string        `json:"routingRequestId,omitempty"`
+		ContentType          string        `json:"contentType,omitempty"`
+		RawBody              string        `json:"rawBody,omitempty"`
+		RawBodyTruncated     bool          `json:"rawBodyTruncated,omitempty"`
+		Method               string        `json:"method,omitempty"`
+		URL                  string        `json:"url,omitempty"`
+	}
+	rej := requestErrorJSON{
+		Message:              e.Error(),
+		StatusCode:           e.StatusCode,
+		ServiceError:         e.ServiceError,
+		RequestID:            e.RequestID,
+		CorrelationRequestID: e.CorrelationRequestID,
+		RoutingRequestID:     e.RoutingRequestID,
+		ContentType:          e.ContentType,
+		RawBody:              string(e.RawBody),
+		RawBodyTruncated:     e.RawBodyTruncated,
+	}
+	if e.Response != nil && e.Response.Request != nil {
+		rej.Method = e.Response.Request.Method
+		if e.Response.Request.URL != nil {
+			rej.URL = e.Response.Request.URL.String()
+		}
+	}
+	return json.Marshal(rej)
+}
+
+// returns the body truncated to MaxRawErrorBodySize bytes
+func truncateErrorBody(b []byte) ([]byte, bool) {
+	if MaxRawErrorBodySize >= 0 && len(b) > MaxRawErrorBodySize {
+		return append([]byte(nil), b[:MaxRawErrorBodySize]...), true
+	}
+	return append([]byte(nil), b...), false
+}
+
+// returns the body as the message of a ServiceError, truncated to maxErrorBodyMessageSize bytes
+func errorBodyMessage(b []byte) string {
+	msg := strings.TrimSpace(string(b))
+	if len(msg) <= maxErrorBodyMessageSize {
+		return msg
+	}
+	end := maxErrorBodyMessageSize
+	// don't split a multi-byte character
+	for end > 0 && !utf8.RuneStart(msg[end]) {
+		end--
+	}
+	return msg[:end] + "..."
+}
+
+// IsAzureError returns true if the passed error, or any error it wraps, is an Azure Service error; false otherwise.
 func IsAzureError(e error) bool {
-	_, ok := e.(*RequestError)
-	return ok
+	var rep *RequestError
+	var re RequestError
+	return errors.As(e, &rep) || errors.As(e, &re)
 }
 
 // Resource contains details about an Azure resource.
@@ -294,6 +388,47 @@ func WithReturnClientID(b bool) autorest.PrepareDecorator {
 	return autorest.WithHeader(HeaderReturnClientID, strconv.FormatBool(b))
 }
 
+// ExtractCorrelationID extracts the correlation ID from the x-ms-correlation-request-id header of
+// the response or, if the service didn't return it, of the request that was sent.
+func ExtractCorrelationID(resp *http.Response) string {
+	if id := autorest.ExtractHeaderValue(HeaderCorrelationRequestID, resp); id != "" {
+		return id
+	}
+	if resp != nil && resp.Request != nil {
+		return resp.Request.Header.Get(HeaderCorrelationRequestID)
+	}
+	return ""
+}
+
+// returns the correlation ID of an operation, the one in the context, see autorest.WithCorrelationID,
+// or else the one of the last response of the operation so that subsequent requests reuse the ID
+// the service assigned to the operation.
+func operationCorrelationID(ctx context.Context, resp *http.Response) string {
+	if id := autorest.GetCorrelationID(ctx); id != "" {
+		return id
+	}
+	return ExtractCorrelationID(resp)
+}
+
+// sets the correlation ID header of the request unless it already has one
+func setCorrelationID(req *http.Request, id string) {
+	if id == "" || req.Header.Get(HeaderCorrelationRequestID) != "" {
+		return
+	}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set(HeaderCorrelationRequestID, id)
+}
+
+// formats the correlation ID for a log entry
+func correlationLog(id string) string {
+	if id == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (correlation ID %s)", id)
+}
+
 // ExtractClientID extracts the client identifier from the x-ms-client-request-id header set on the
 // http.Request sent to the service (and returned in the http.Response)
 func ExtractClientID(resp *http.Response) string {
@@ -311,9 +446,10 @@ func ExtractRequestID(resp *http.Response) string {
 // is among the set passed.
 //
 // If there is a chance service may return responses other than the Azure error
-// format and the response cannot be parsed into an error, a decoding error will
-// be returned containing the response body. In any case, the Responder will
-// return an error if the status code is not satisfied.
+// format, e.g. the HTML page of a gateway, and the response cannot be parsed into an
+// error, the azure.RequestError has a ServiceError whose code is the status text and
+// whose message is the start of the response body, and it wraps the decoding error. In any case,
+// the Responder will return an error if the status code is not satisfied.
 //
 // If this Responder returns an error, the response body will be replaced with
 // an in-memory reader, which needs no further closing.
@@ -322,61 +458,44 @@ func WithErrorUnlessStatusCode(codes ...int) autorest.RespondDecorator {
 		return autorest.ResponderFunc(func(resp *http.Response) error {
 			err := r.Respond(resp)
 			if err == nil && !autorest.ResponseHasStatusCode(resp, codes...) {
-				var e RequestError
 				defer resp.Body.Close()
 
+				contentType := resp.Header.Get("Content-Type")
 				encodedAs := autorest.EncodedAsJSON
-				if strings.Contains(resp.Header.Get("Content-Type"), "xml") {
+				if strings.Contains(contentType, "xml") {
 					encodedAs = autorest.EncodedAsXML
 				}
 
-				// Copy and replace the Body in case it does not contain an error object.
+				// Read and replace the whole Body in case it does not contain an error object.
 				// This will leave the Body available to the caller.
-				b, decodeErr := autorest.CopyAndDecode(encodedAs, resp.Body, &e)
-				resp.Body = io.NopCloser(&b)
-				if decodeErr != nil {
-					return fmt.Errorf("autorest/azure: error response cannot be parsed: %q error: %v", b, decodeErr)
+				b, readErr := io.ReadAll(resp.Body)
+				resp.Body = io.NopCloser(bytes.NewReader(b))
+				rawBody, truncated := truncateErrorBody(b)
+				e, decodeErr := decodeRequestError(encodedAs, b)
+				if readErr != nil {
+					decodeErr = fmt.Errorf("autorest/azure: error response cannot be read: %v", readErr)
 				}
-				if e.ServiceError == nil {
-					// Check if error is unwrapped ServiceError
-					decoder := autorest.NewDecoder(encodedAs, bytes.NewReader(b.Bytes()))
-					if err := decoder.Decode(&e.ServiceError); err != nil {
-						return fmt.Errorf("autorest/azure: error response cannot be parsed: %q error: %v", b, err)
-					}
-
-					// for example, should the API return the literal value `null` as the response
-					if e.ServiceError == nil {
-						e.ServiceError = &ServiceError{
-							Code:    "Unknown",
-							Message: "Unknown service error",
-							Details: []map[string]interface{}{
-								{
-									"HttpResponse.Body": b.String(),
-								},
-							},
-						}
-					}
-				}
-
-				if e.ServiceError != nil && e.ServiceError.Message == "" {
-					// if we're here it means the returned error wasn't OData v4 compliant.
-					// try to unmarshal the body in hopes of getting something.
-					rawBody := map[string]interface{}{}
-					decoder := autorest.NewDecoder(encodedAs, bytes.NewReader(b.Bytes()))
-					if err := decoder.Decode(&rawBody); err != nil {
-						return fmt.Errorf("autorest/azure: error response cannot be parsed: %q error: %v", b, err)
-					}
-
-					e.ServiceError = &ServiceError{
-						Code:    "Unknown",
-						Message: "Unknown service error",
+				if decodeErr != nil {
+					// not an Azure error, report the status and the body
+					code := http.StatusText(resp.StatusCode)
+					if code == "" {
+						code = "Unknown"
 					}
-					if len(rawBody) > 0 {
-						e.ServiceError.Details = []map[string]interface{}{rawBody}
+					e = RequestError{
+						DetailedError: autorest.DetailedError{Original: decodeErr},
+						ServiceError: &ServiceError{
+							Code:    code,
+							Message: errorBodyMessage(b),
+						},
 					}
 				}
 				e.Response = resp
 				e.RequestID = ExtractRequestID(resp)
+				e.CorrelationRequestID = ExtractCorrelationID(resp)
+				e.RoutingRequestID = autorest.ExtractHeaderValue(HeaderRoutingRequestID, resp)
+				e.ContentType = contentType
+				e.RawBody = rawBody
+				e.RawBodyTruncated = truncated
 				if e.StatusCode == nil {
 					e.StatusCode = resp.StatusCode
 				}
@@ -386,3 +505,51 @@ func WithErrorUnlessStatusCode(codes ...int) autorest.RespondDecorator {
 		})
 	}
 }
+
+// decodes an error response body, returns an error if it isn't an Azure error
+func decodeRequestError(encodedAs autorest.EncodedAs, b []byte) (RequestError, error) {
+	var e RequestError
+	if err := autorest.NewDecoder(encodedAs, bytes.NewReader(b)).Decode(&e); err != nil {
+		return e, fmt.Errorf("autorest/azure: error response cannot be parsed: %v", err)
+	}
+	if e.ServiceError == nil {
+		// Check if error is unwrapped ServiceError
+		decoder := autorest.NewDecoder(encodedAs, bytes.NewReader(b))
+		if err := decoder.Decode(&e.ServiceError); err != nil {
+			return e, fmt.Errorf("autorest/azure: error response cannot be parsed: %v", err)
+		}
+
+		// for example, should the API return the literal value `null` as the response
+		if e.ServiceError == nil {
+			rawBody, _ := truncateErrorBody(b)
+			e.ServiceError = &ServiceError{
+				Code:    "Unknown",
+				Message: "Unknown service error",
+				Details: []map[string]interface{}{
+					{
+						"HttpResponse.Body": string(rawBody),
+					},
+				},
+			}
+		}
+	}
+
+	if e.ServiceError != nil && e.ServiceError.Message == "" {
+		// if we're here it means the returned error wasn't OData v4 compliant.
+		// try to unmarshal the body in hopes of getting something.
+		rawFields := map[string]interface{}{}
+		decoder := autorest.NewDecoder(encodedAs, bytes.NewReader(b))
+		if err := decoder.Decode(&rawFields); err != nil {
+			return e, fmt.Errorf("autorest/azure: error response cannot be parsed: %v", err)
+		}
+
+		e.ServiceError = &ServiceError{
+			Code:    "Unknown",
+			Message: "Unknown service error",
+		}
+		if len(rawFields) > 0 {
+			e.ServiceError.Details = []map[string]interface{}{rawFields}
+		}
+	}
+	return e, nil
+}
@@ -16,6 +16,7 @@ package azure
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -24,6 +25,7 @@ import (
 	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/mocks"
@@ -168,9 +170,15 @@ func TestWithErrorUnlessStatusCode_NotAnAzureError(t *testing.T) {
 	err := autorest.Respond(r,
 		WithErrorUnlessStatusCode(http.StatusOK),
 		autorest.ByClosing())
-	ok, _ := err.(*RequestError)
-	if ok != nil {
-		t.Fatalf("azure: azure.RequestError returned from malformed response: %v", err)
+	azErr, ok := err.(*RequestError)
+	if !ok {
+		t.Fatalf("azure: returned error is not azure.RequestError: %T", err)
+	}
+	if azErr.ServiceError.Code != http.StatusText(http.StatusBadRequest) || azErr.ServiceError.Message != body {
+		t.Fatalf("azure: unexpected service error %v", azErr.ServiceError)
+	}
+	if errors.Unwrap(err) == nil {
+		t.Fatal("azure: expected the decoding error to be wrapped")
 	}
 
 	// the error body should still be there
@@ -184,6 +192,71 @@ func TestWithErrorUnlessStatusCode_NotAnAzureError(t *testing.T) {
 	}
 }
 
+func TestWithErrorUnlessStatusCode_NonAzureErrorBodies(t *testing.T) {
+	testCases := []struct {
+		name        string
+		statusCode  int
+		contentType string
+		body        string
+	}{
+		{"html", http.StatusBadGateway, "text/html", "<html><body>502 Bad Gateway</body></html>"},
+		{"plain text", http.StatusServiceUnavailable, "text/plain", "Service Unavailable"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := mocks.NewResponseWithBodyAndStatus(mocks.NewBody(tc.body), tc.statusCode, http.StatusText(tc.statusCode))
+			mocks.SetResponseHeader(r, HeaderContentType, tc.contentType)
+			mocks.SetResponseHeader(r, HeaderRequestID, "request-id")
+			mocks.SetResponseHeader(r, HeaderCorrelationRequestID, "correlation-id")
+			mocks.SetResponseHeader(r, HeaderRoutingRequestID, "routing-id")
+			r.Request = mocks.NewRequest()
+
+			err := autorest.Respond(r,
+				WithErrorUnlessStatusCode(http.StatusOK),
+				autorest.ByClosing())
+			azErr, ok := err.(*RequestError)
+			if !ok || !IsAzureError(err) {
+				t.Fatalf("azure: returned error is not azure.RequestError: %T", err)
+			}
+			if azErr.Response != r || string(azErr.RawBody) != tc.body || azErr.ContentType != tc.contentType ||
+				azErr.RequestID != "request-id" || azErr.CorrelationRequestID != "correlation-id" || azErr.RoutingRequestID != "routing-id" {
+				t.Fatalf("azure: unexpected diagnostics %+v", azErr)
+			}
+			if azErr.ServiceError == nil || azErr.ServiceError.Code != http.StatusText(tc.statusCode) || azErr.ServiceError.Message != tc.body {
+				t.Fatalf("azure: unexpected service error %v", azErr.ServiceError)
+			}
+			if ErrorStatusCode(err) != tc.statusCode || ErrorRequestID(err) != "request-id" {
+				t.Fatalf("azure: unexpected error details for %v", err)
+			}
+		})
+	}
+}
+
+func TestWithErrorUnlessStatusCode_NonAzureErrorLongBody(t *testing.T) {
+	body := strings.Repeat("é", 1000)
+	r := mocks.NewResponseWithBodyAndStatus(mocks.NewBody(body), http.StatusBadGateway, "Bad Gateway")
+	mocks.SetResponseHeader(r, HeaderContentType, "text/html")
+	r.Request = mocks.NewRequest()
+
+	err := autorest.Respond(r,
+		WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByClosing())
+	azErr, ok := err.(*RequestError)
+	if !ok {
+		t.Fatalf("azure: returned error is not azure.RequestError: %T", err)
+	}
+	if string(azErr.RawBody) != body || azErr.RawBodyTruncated {
+		t.Fatal("azure: the raw body wasn't kept")
+	}
+	msg := azErr.ServiceError.Message
+	if len(msg) > maxErrorBodyMessageSize+3 || !strings.HasSuffix(msg, "...") || !utf8.ValidString(msg) || !strings.HasPrefix(body, strings.TrimSuffix(msg, "...")) {
+		t.Fatalf("azure: the message wasn't truncated: %q", msg)
+	}
+	if len(err.Error()) >= len(body) {
+		t.Fatalf("azure: the error string contains the whole body: %d bytes", len(err.Error()))
+	}
+}
+
 func TestWithErrorUnlessStatusCode_FoundAzureErrorWithoutDetails(t *testing.T) {
 	j := `{
 		"error": {
@@ -707,8 +780,12 @@ func TestRequestErrorString_WithErrorNonConforming6(t *testing.T) {
 	if err == nil {
 		t.Fatalf("azure: returned nil error for proper error response")
 	}
-	if _, ok := err.(*RequestError); ok {
-		t.Fatal("unexpected RequestError when unmarshalling fails")
+	azErr, ok := err.(*RequestError)
+	if !ok {
+		t.Fatalf("azure: returned error is not azure.RequestError: %T", err)
+	}
+	if azErr.ServiceError.Code != http.StatusText(http.StatusInternalServerError) || azErr.ServiceError.Message != j || azErr.RequestID != uuid {
+		t.Fatalf("azure: unexpected error when unmarshalling fails: %v", err)
 	}
 }
 
@@ -889,3 +966,79 @@ func (mi *mockInspector) ByInspecting() autorest.RespondDecorator {
 		})
 	}
 }
+
+func TestWithErrorUnlessStatusCode_PreservesRawBody(t *testing.T) {
+	j := `{"Status":"NotFound"}`
+	r := mocks.NewResponseWithContent(j)
+	mocks.SetResponseHeader(r, HeaderContentType, "application/json; charset=utf-8")
+	mocks.SetResponseHeader(r, HeaderRequestID, "request-id")
+	mocks.SetResponseHeader(r, HeaderCorrelationRequestID, "correlation-id")
+	mocks.SetResponseHeader(r, HeaderRoutingRequestID, "WESTUS:20230101T000000Z:id")
+	r.Request = mocks.NewRequest()
+	r.StatusCode = http.StatusInternalServerError
+	r.Status = http.StatusText(r.StatusCode)
+
+	err := autorest.Respond(r,
+		WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByClosing())
+	azErr, ok := err.(*RequestError)
+	if !ok {
+		t.Fatalf("azure: returned error is not azure.RequestError: %T", err)
+	}
+	if string(azErr.RawBody) != j || azErr.RawBodyTruncated {
+		t.Fatalf("azure: unexpected raw body %q", azErr.RawBody)
+	}
+	if azErr.ContentType != "application/json; charset=utf-8" ||
+		azErr.RequestID != "request-id" ||
+		azErr.CorrelationRequestID != "correlation-id" ||
+		azErr.RoutingRequestID != "WESTUS:20230101T000000Z:id" {
+		t.Fatalf("azure: unexpected diagnostics %+v", azErr)
+	}
+
+	b, err := json.Marshal(azErr)
+	if err != nil {
+		t.Fatalf("azure: failed to marshal the error: %v", err)
+	}
+	var logged map[string]interface{}
+	if err := json.Unmarshal(b, &logged); err != nil {
+		t.Fatalf("azure: failed to unmarshal the error: %v", err)
+	}
+	if logged["statusCode"] != float64(http.StatusInternalServerError) ||
+		logged["rawBody"] != j ||
+		logged["correlationRequestId"] != "correlation-id" ||
+		logged["method"] != http.MethodGet ||
+		!strings.HasPrefix(logged["message"].(string), "autorest/azure: Service returned an error.") {
+		t.Fatalf("azure: unexpected JSON %s", b)
+	}
+	if se, ok := logged["error"].(map[string]interface{}); !ok || se["code"] != "Unknown" {
+		t.Fatalf("azure: unexpected JSON %s", b)
+	}
+}
+
+func TestWithErrorUnlessStatusCode_TruncatesRawBody(t *testing.T) {
+	defer func(size int) { MaxRawErrorBodySize = size }(MaxRawErrorBodySize)
+	MaxRawErrorBodySize = 16
+	j := `{"error":{"code":"InternalError","message":"Azure is having trouble right now."}}`
+	r := mocks.NewResponseWithContent(j)
+	r.Request = mocks.NewRequest()
+	r.StatusCode = http.StatusInternalServerError
+	r.Status = http.StatusText(r.StatusCode)
+
+	err := autorest.Respond(r, WithErrorUnlessStatusCode(http.StatusOK))
+	azErr, ok := err.(*RequestError)
+	if !ok {
+		t.Fatalf("azure: returned error is not azure.RequestError: %T", err)
+	}
+	if string(azErr.RawBody) != j[:16] || !azErr.RawBodyTruncated {
+		t.Fatalf("azure: unexpected raw body %q", azErr.RawBody)
+	}
+	if azErr.ServiceError.Code != "InternalError" {
+		t.Fatalf("azure: unexpected service error %v", azErr.ServiceError)
+	}
+	// the whole body is still available to the caller
+	defer r.Body.Close()
+	b, _ := io.ReadAll(r.Body)
+	if string(b) != j {
+		t.Fatalf("response body is wrong. got=%q expected=%q", string(b), j)
+	}
+}
@@ -0,0 +1,311 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/logger"
+	"github.com/Azure/go-autorest/tracing"
+)
+
+const (
+	// MaxBatchSize is the maximum number of requests Resource Manager accepts in a batch.
+	MaxBatchSize = 500
+
+	// DefaultBatchAPIVersion is the default api-version of the Resource Manager batch endpoint.
+	DefaultBatchAPIVersion = "2020-06-01"
+)
+
+// BatchSender sends requests to Resource Manager in batches through the /batch endpoint, so that
+// reading many resources takes a fraction of the calls, and of the throttling budget, of sending
+// each request on its own.
+type BatchSender struct {
+	// Client sends the batches, its authorizer, retry settings and PollingDelay are used.
+	Client autorest.Client
+
+	// BaseURI is the Resource Manager endpoint, e.g. https://management.azure.com.
+	BaseURI string
+
+	// BatchSize is the maximum number of requests per batch, MaxBatchSize if zero.
+	BatchSize int
+
+	// APIVersion is the api-version of the batch endpoint, DefaultBatchAPIVersion if empty.
+	APIVersion string
+}
+
+// NewBatchSender creates a BatchSender that sends batches to the Resource Manager endpoint with the client.
+func NewBatchSender(client autorest.Client, baseURI string) *BatchSender {
+	return &BatchSender{
+		Client:     client,
+		BaseURI:    baseURI,
+		BatchSize:  MaxBatchSize,
+		APIVersion: DefaultBatchAPIVersion,
+	}
+}
+
+// a request in a batch
+type batchRequest struct {
+	Name       string          `json:"name"`
+	HTTPMethod string          `json:"httpMethod"`
+	URL        string          `json:"url"`
+	Content    json.RawMessage `json:"content,omitempty"`
+}
+
+// a response in a batch
+type batchResponse struct {
+	Name           string            `json:"name"`
+	HTTPStatusCode int               `json:"httpStatusCode"`
+	Headers        map[string]string `json:"headers"`
+	Content        json.RawMessage   `json:"content"`
+}
+
+// Send sends the requests in batches and returns their responses in the same order.  The requests
+// are made by the usual preparers, their URL, method and JSON body are sent while their headers,
+// including the authorization, are replaced by the ones of the batch.  The responses can be
+// handled by the usual responders, e.g. WithErrorUnlessStatusCode.
+// Requests throttled with a 429, and idempotent requests failing with one of the
+// autorest.StatusCodesForRetry, are sent again in a later batch after their Retry-After, up to
+// the client's RetryAttempts.  Likewise a batch that fails as a whole is sent again if it was
+// throttled, or for the other autorest.StatusCodesForRetry if it has no POST or PATCH requests.
+// An error is returned if a batch fails as a whole, in which case the requests of the previous
+// batches have a response.
+func (b *BatchSender) Send(ctx context.Context, requests []*http.Request) (responses []*http.Response, err error) {
+	ctx = tracing.StartSpan(ctx, "github.com/Azure/go-autorest/autorest/azure.BatchSender.Send")
+	defer func() {
+		tracing.EndSpan(ctx, -1, err)
+	}()
+	items := make([]batchRequest, len(requests))
+	for i, r := range requests {
+		if items[i], err = newBatchRequest(strconv.Itoa(i), r); err != nil {
+			return nil, err
+		}
+	}
+	batchSize := b.BatchSize
+	if batchSize <= 0 || batchSize > MaxBatchSize {
+		batchSize = MaxBatchSize
+	}
+	responses = make([]*http.Response, len(requests))
+	for start := 0; start < len(items); start += batchSize {
+		end := start + batchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		pending := make([]int, 0, end-start)
+		for i := start; i < end; i++ {
+			pending = append(pending, i)
+		}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			batch := make([]batchRequest, len(pending))
+			for i, p := range pending {
+				batch[i] = items[p]
+			}
+			results, err := b.sendBatch(ctx, batch)
+			if err != nil {
+				return responses, err
+			}
+			var retry []int
+			var delay time.Duration
+			for _, p := range pending {
+				result, ok := results[items[p].Name]
+				if !ok {
+					return responses, autorest.NewError("azure.BatchSender", "Send", "the batch response has no response for request %s", items[p].Name)
+				}
+				resp := newBatchItemResponse(requests[p], result)
+				responses[p] = resp
+				if attempt < b.Client.RetryAttempts && shouldRetryBatchItem(requests[p].Method, resp.StatusCode) {
+					retry = append(retry, p)
+					d, ok := getRetryAfter(resp)
+					if !ok {
+						d = b.Client.RetryDuration
+					}
+					if d > delay {
+						delay = d
+					}
+				}
+			}
+			pending = retry
+			if len(pending) > 0 {
+				logger.Instance.Writef(logger.LogInfo, "BatchSender: retrying %d requests in %s\n", len(pending), delay)
+				if !delayForPolling(delay, ctx.Done()) {
+					return responses, ctx.Err()
+				}
+			}
+		}
+	}
+	return responses, nil
+}
+
+// sends a batch and returns the responses keyed by name
+func (b *BatchSender) sendBatch(ctx context.Context, batch []batchRequest) (map[string]batchResponse, error) {
+	apiVersion := b.APIVersion
+	if apiVersion == "" {
+		apiVersion = DefaultBatchAPIVersion
+	}
+	req, err := autorest.Prepare((&http.Request{}).WithContext(ctx),
+		autorest.AsContentType("application/json; charset=utf-8"),
+		autorest.AsPost(),
+		autorest.WithBaseURL(b.BaseURI),
+		autorest.WithPath("batch"),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": apiVersion}),
+		autorest.WithJSON(map[string]interface{}{"requests": batch}),
+	)
+	if err != nil {
+		return nil, autorest.NewErrorWithError(err, "azure.BatchSender", "Send", nil, "failure preparing the batch request")
+	}
+	// a throttled batch wasn't processed, other failures are retried only if every request can be
+	// sent again as some requests of the batch may have been processed
+	codes := []int{http.StatusTooManyRequests}
+	if isIdempotentBatch(batch) {
+		codes = autorest.StatusCodesForRetry
+	}
+	resp, err := autorest.SendWithSender(b.Client, req,
+		autorest.DoRetryForStatusCodes(b.Client.RetryAttempts, b.Client.RetryDuration, codes...),
+	)
+	if err != nil {
+		return nil, autorest.NewErrorWithError(err, "azure.BatchSender", "Send", resp, "failure sending the batch request")
+	}
+	// a batch that takes long to complete is accepted, its responses are at the Location
+	for resp.StatusCode == http.StatusAccepted {
+		location := autorest.GetLocation(resp)
+		if location == "" {
+			break
+		}
+		autorest.DrainResponseBody(resp)
+		delay, ok := getRetryAfter(resp)
+		if !ok {
+			delay = b.Client.PollingDelay
+		}
+		if !delayForPolling(delay, ctx.Done()) {
+			return nil, ctx.Err()
+		}
+		if req, err = http.NewRequest(http.MethodGet, location, nil); err != nil {
+			return nil, autorest.NewErrorWithError(err, "azure.BatchSender", "Send", nil, "failure creating the request for the batch results")
+		}
+		resp, err = autorest.SendWithSender(b.Client, req.WithContext(ctx),
+			autorest.DoRetryForStatusCodes(b.Client.RetryAttempts, b.Client.RetryDuration, autorest.StatusCodesForRetry...),
+		)
+		if err != nil {
+			return nil, autorest.NewErrorWithError(err, "azure.BatchSender", "Send", resp, "failure polling the batch results")
+		}
+	}
+	var result struct {
+		Responses []batchResponse `json:"responses"`
+	}
+	err = autorest.Respond(resp,
+		WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByUnmarshallingJSON(&result),
+		autorest.ByClosing())
+	if err != nil {
+		return nil, autorest.NewErrorWithError(err, "azure.BatchSender", "Send", resp, "failure responding to the batch request")
+	}
+	results := make(map[string]batchResponse, len(result.Responses))
+	for _, r := range result.Responses {
+		results[r.Name] = r
+	}
+	return results, nil
+}
+
+// converts a prepared request to a batch request
+func newBatchRequest(name string, r *http.Request) (batchRequest, error) {
+	if r == nil || r.URL == nil {
+		return batchRequest{}, autorest.NewError("azure.BatchSender", "Send", "request %s has no URL", name)
+	}
+	br := batchRequest{
+		Name:       name,
+		HTTPMethod: r.Method,
+		URL:        r.URL.String(),
+	}
+	if br.HTTPMethod == "" {
+		br.HTTPMethod = http.MethodGet
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		b, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return batchRequest{}, autorest.NewErrorWithError(err, "azure.BatchSender", "Send", nil, "failure reading the body of request %s", name)
+		}
+		// put the body back so the request can still be sent on its own
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		if len(b) > 0 {
+			if !json.Valid(b) {
+				return batchRequest{}, autorest.NewError("azure.BatchSender", "Send", "the body of request %s isn't JSON", name)
+			}
+			br.Content = b
+		}
+	}
+	return br, nil
+}
+
+// converts a response in a batch to an http.Response for the original request
+func newBatchItemResponse(r *http.Request, br batchResponse) *http.Response {
+	header := http.Header{}
+	for k, v := range br.Headers {
+		header.Set(k, v)
+	}
+	content := []byte(br.Content)
+	if bytes.Equal(content, []byte("null")) {
+		content = nil
+	}
+	if len(content) > 0 && header.Get(HeaderContentType) == "" {
+		header.Set(HeaderContentType, "application/json; charset=utf-8")
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", br.HTTPStatusCode, http.StatusText(br.HTTPStatusCode)),
+		StatusCode:    br.HTTPStatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(content)),
+		ContentLength: int64(len(content)),
+		Request:       r,
+	}
+}
+
+// returns true if none of the requests in the batch is a POST or a PATCH
+func isIdempotentBatch(batch []batchRequest) bool {
+	for _, br := range batch {
+		if br.HTTPMethod == http.MethodPost || br.HTTPMethod == http.MethodPatch {
+			return false
+		}
+	}
+	return true
+}
+
+// returns true if the request in a batch should be sent again.  throttled requests weren't
+// processed, other transient failures are retried only for idempotent methods.
+func shouldRetryBatchItem(method string, statusCode int) bool {
+	if statusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if method == http.MethodPost || method == http.MethodPatch {
+		return false
+	}
+	for _, c := range autorest.StatusCodesForRetry {
+		if c == statusCode {
+			return true
+		}
+	}
+	return false
+}
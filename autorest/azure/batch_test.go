@@ -0,0 +1,241 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// serves the batch endpoint, the handler returns the response of each request
+type batchServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	batches [][]batchRequest
+}
+
+func newBatchServer(t *testing.T, handler func(attempt int, r batchRequest) batchResponse) *batchServer {
+	bs := &batchServer{}
+	attempts := map[string]int{}
+	bs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/batch" || r.URL.Query().Get("api-version") != DefaultBatchAPIVersion {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		var body struct {
+			Requests []batchRequest `json:"requests"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode the batch: %v", err)
+		}
+		bs.mu.Lock()
+		bs.batches = append(bs.batches, body.Requests)
+		var result struct {
+			Responses []batchResponse `json:"responses"`
+		}
+		for _, req := range body.Requests {
+			resp := handler(attempts[req.URL], req)
+			resp.Name = req.Name
+			attempts[req.URL]++
+			result.Responses = append(result.Responses, resp)
+		}
+		bs.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+	return bs
+}
+
+func newBatchTestRequests(t *testing.T, n int) []*http.Request {
+	requests := make([]*http.Request, n)
+	for i := range requests {
+		r, err := autorest.Prepare(&http.Request{},
+			autorest.AsGet(),
+			autorest.WithBaseURL("https://management.azure.com"),
+			autorest.WithPath(fmt.Sprintf("/subscriptions/sub/resourceGroups/rg%d", i)),
+			autorest.WithQueryParameters(map[string]interface{}{"api-version": "2020-01-01"}))
+		if err != nil {
+			t.Fatalf("failed to prepare the request: %v", err)
+		}
+		requests[i] = r
+	}
+	return requests
+}
+
+func okBatchResponse(r batchRequest) batchResponse {
+	return batchResponse{
+		HTTPStatusCode: http.StatusOK,
+		Headers:        map[string]string{HeaderRequestID: "id-" + r.Name},
+		Content:        json.RawMessage(fmt.Sprintf(`{"id": %q}`, r.URL)),
+	}
+}
+
+func TestBatchSender(t *testing.T) {
+	bs := newBatchServer(t, func(attempt int, r batchRequest) batchResponse {
+		if strings.Contains(r.URL, "rg3") {
+			return batchResponse{
+				HTTPStatusCode: http.StatusNotFound,
+				Content:        json.RawMessage(`{"error": {"code": "ResourceGroupNotFound", "message": "not found"}}`),
+			}
+		}
+		return okBatchResponse(r)
+	})
+	defer bs.Close()
+	sender := NewBatchSender(autorest.Client{Sender: bs.Client()}, bs.URL)
+	sender.BatchSize = 2
+	requests := newBatchTestRequests(t, 5)
+	responses, err := sender.Send(context.Background(), requests)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bs.batches) != 3 {
+		t.Fatalf("expected 3 batches, got %d", len(bs.batches))
+	}
+	for i, resp := range responses {
+		if resp.Request != requests[i] {
+			t.Fatalf("response %d is for the wrong request", i)
+		}
+		var result struct {
+			ID string `json:"id"`
+		}
+		err := autorest.Respond(resp,
+			WithErrorUnlessStatusCode(http.StatusOK),
+			autorest.ByUnmarshallingJSON(&result),
+			autorest.ByClosing())
+		if i == 3 {
+			if ErrorCode(err) != "ResourceGroupNotFound" {
+				t.Fatalf("unexpected error %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.ID != requests[i].URL.String() || ExtractRequestID(resp) == "" {
+			t.Fatalf("unexpected response %d: %v", i, result)
+		}
+	}
+}
+
+func TestBatchSender_RetryThrottled(t *testing.T) {
+	bs := newBatchServer(t, func(attempt int, r batchRequest) batchResponse {
+		if strings.Contains(r.URL, "rg1") && attempt == 0 {
+			return batchResponse{
+				HTTPStatusCode: http.StatusTooManyRequests,
+				Headers:        map[string]string{"Retry-After": "0"},
+			}
+		}
+		return okBatchResponse(r)
+	})
+	defer bs.Close()
+	sender := NewBatchSender(autorest.Client{Sender: bs.Client(), RetryAttempts: 3, RetryDuration: time.Hour}, bs.URL)
+	responses, err := sender.Send(context.Background(), newBatchTestRequests(t, 3))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bs.batches) != 2 || len(bs.batches[1]) != 1 || !strings.Contains(bs.batches[1][0].URL, "rg1") {
+		t.Fatalf("unexpected batches %v", bs.batches)
+	}
+	for i, resp := range responses {
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d for response %d", resp.StatusCode, i)
+		}
+	}
+}
+
+func TestBatchSender_Content(t *testing.T) {
+	bs := newBatchServer(t, func(attempt int, r batchRequest) batchResponse {
+		return batchResponse{HTTPStatusCode: http.StatusOK, Content: r.Content}
+	})
+	defer bs.Close()
+	req, err := autorest.Prepare(&http.Request{},
+		autorest.AsPut(),
+		autorest.WithBaseURL("https://management.azure.com"),
+		autorest.WithPath("/subscriptions/sub/resourceGroups/rg"),
+		autorest.WithJSON(map[string]string{"location": "westus"}))
+	if err != nil {
+		t.Fatalf("failed to prepare the request: %v", err)
+	}
+	responses, err := NewBatchSender(autorest.Client{Sender: bs.Client()}, bs.URL).Send(context.Background(), []*http.Request{req})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bs.batches[0][0].HTTPMethod != http.MethodPut {
+		t.Fatalf("unexpected method %s", bs.batches[0][0].HTTPMethod)
+	}
+	var result map[string]string
+	if err = autorest.Respond(responses[0], autorest.ByUnmarshallingJSON(&result), autorest.ByClosing()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result["location"] != "westus" {
+		t.Fatalf("unexpected content %v", result)
+	}
+}
+
+func TestBatchSender_BatchFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error": {"code": "AuthorizationFailed", "message": "denied"}}`))
+	}))
+	defer ts.Close()
+	_, err := NewBatchSender(autorest.Client{Sender: ts.Client()}, ts.URL).Send(context.Background(), newBatchTestRequests(t, 2))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if ErrorCode(err) != "AuthorizationFailed" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestBatchSender_RetryBatchFailure(t *testing.T) {
+	testCases := []struct {
+		name       string
+		method     string
+		statusCode int
+		expected   int32
+	}{
+		{"idempotent", http.MethodGet, http.StatusServiceUnavailable, 2},
+		{"not idempotent", http.MethodPost, http.StatusServiceUnavailable, 1},
+		{"throttled", http.MethodPost, http.StatusTooManyRequests, 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var batches int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&batches, 1)
+				w.WriteHeader(tc.statusCode)
+			}))
+			defer ts.Close()
+			requests := newBatchTestRequests(t, 2)
+			requests[1].Method = tc.method
+			client := autorest.Client{Sender: ts.Client(), RetryAttempts: 1, RetryDuration: time.Millisecond}
+			if _, err := NewBatchSender(client, ts.URL).Send(context.Background(), requests); err == nil {
+				t.Fatal("expected an error")
+			}
+			if n := atomic.LoadInt32(&batches); n != tc.expected {
+				t.Fatalf("expected %d batches, got %d", tc.expected, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// TokenProviderFunc returns the token provider for the specified resource, e.g. by creating an
+// adal.ServicePrincipalToken for it.
+type TokenProviderFunc func(resource string) (adal.OAuthTokenProvider, error)
+
+// EnvironmentAuthorizer implements bearer authorization for requests to any service of an
+// Environment.  The resource is resolved from the host of each request with
+// Environment.ResourceForURL and the token provider of each resource is created once and reused,
+// so a single client can call Resource Manager, Key Vault and Storage with one credential.
+type EnvironmentAuthorizer struct {
+	env           Environment
+	tokenProvider TokenProviderFunc
+
+	mu          sync.Mutex
+	authorizers map[string]*autorest.BearerAuthorizer
+}
+
+// NewEnvironmentAuthorizer creates an EnvironmentAuthorizer for the environment.  tokenProvider is
+// called the first time a request is made to a resource.
+func NewEnvironmentAuthorizer(env Environment, tokenProvider TokenProviderFunc) *EnvironmentAuthorizer {
+	return &EnvironmentAuthorizer{
+		env:           env,
+		tokenProvider: tokenProvider,
+		authorizers:   map[string]*autorest.BearerAuthorizer{},
+	}
+}
+
+// WithAuthorization returns a PrepareDecorator that adds an HTTP Authorization header whose
+// value is "Bearer " followed by the token for the resource of the request's host.
+//
+// By default, the token will be automatically refreshed through the Refresher interface.
+func (ea *EnvironmentAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err != nil {
+				return r, err
+			}
+			resource, err := ea.env.ResourceForURL(r.URL)
+			if err != nil {
+				return r, autorest.NewErrorWithError(err, "azure.EnvironmentAuthorizer", "WithAuthorization", nil, "failed to resolve the resource")
+			}
+			ba, err := ea.authorizer(resource)
+			if err != nil {
+				return r, autorest.NewErrorWithError(err, "azure.EnvironmentAuthorizer", "WithAuthorization", nil, "failed to get the token provider for %s", resource)
+			}
+			return autorest.Prepare(r, ba.WithAuthorization())
+		})
+	}
+}
+
+// TokenProvider returns the token provider for the resource, creating it if needed.
+func (ea *EnvironmentAuthorizer) TokenProvider(resource string) (adal.OAuthTokenProvider, error) {
+	ba, err := ea.authorizer(resource)
+	if err != nil {
+		return nil, err
+	}
+	return ba.TokenProvider(), nil
+}
+
+// returns the cached authorizer for the resource or creates it
+func (ea *EnvironmentAuthorizer) authorizer(resource string) (*autorest.BearerAuthorizer, error) {
+	ea.mu.Lock()
+	defer ea.mu.Unlock()
+	if ba, ok := ea.authorizers[resource]; ok {
+		return ba, nil
+	}
+	tp, err := ea.tokenProvider(resource)
+	if err != nil {
+		return nil, err
+	}
+	ba := autorest.NewBearerAuthorizer(tp)
+	ea.authorizers[resource] = ba
+	return ba, nil
+}
@@ -0,0 +1,93 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+type resourceToken string
+
+func (rt resourceToken) OAuthToken() string {
+	return "token-for-" + string(rt)
+}
+
+func TestEnvironmentAuthorizer(t *testing.T) {
+	var created []string
+	ea := NewEnvironmentAuthorizer(PublicCloud, func(resource string) (adal.OAuthTokenProvider, error) {
+		created = append(created, resource)
+		return resourceToken(resource), nil
+	})
+	testCases := []struct {
+		url      string
+		expected string
+	}{
+		{"https://management.azure.com/subscriptions/123", "Bearer token-for-https://management.azure.com/"},
+		{"https://myvault.vault.azure.net/secrets/s", "Bearer token-for-https://vault.azure.net"},
+		{"https://othervault.vault.azure.net/secrets/s", "Bearer token-for-https://vault.azure.net"},
+		{"https://myaccount.blob.core.windows.net/c", "Bearer token-for-https://storage.azure.com/"},
+	}
+	for _, tc := range testCases {
+		req, err := autorest.Prepare(mocks.NewRequestForURL(tc.url), ea.WithAuthorization())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.url, err)
+		}
+		if h := req.Header.Get("Authorization"); h != tc.expected {
+			t.Fatalf("%s: expected %s, got %s", tc.url, tc.expected, h)
+		}
+	}
+	// one token provider per resource
+	if len(created) != 3 {
+		t.Fatalf("unexpected token providers %v", created)
+	}
+	if tp, err := ea.TokenProvider("https://vault.azure.net"); err != nil || tp.OAuthToken() != "token-for-https://vault.azure.net" {
+		t.Fatalf("unexpected token provider %v, %v", tp, err)
+	}
+}
+
+func TestEnvironmentAuthorizerErrors(t *testing.T) {
+	ea := NewEnvironmentAuthorizer(PublicCloud, func(resource string) (adal.OAuthTokenProvider, error) {
+		return nil, errors.New("no credential")
+	})
+	for _, u := range []string{"https://example.com", "https://management.azure.com"} {
+		req := mocks.NewRequestForURL(u)
+		if _, err := autorest.Prepare(req, ea.WithAuthorization()); err == nil {
+			t.Fatalf("%s: expected an error", u)
+		}
+		if req.Header.Get("Authorization") != "" {
+			t.Fatalf("%s: unexpected Authorization header", u)
+		}
+	}
+	// failures aren't cached
+	calls := 0
+	ea = NewEnvironmentAuthorizer(PublicCloud, func(resource string) (adal.OAuthTokenProvider, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("transient")
+		}
+		return resourceToken(resource), nil
+	})
+	if _, err := ea.TokenProvider("r"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := ea.TokenProvider("r"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
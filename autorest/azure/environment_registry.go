@@ -0,0 +1,267 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// the registry used by EnvironmentFromName and SetEnvironment
+var environments = NewEnvironmentRegistry()
+
+// EnvironmentRegistry is a set of named cloud environments.  It's safe for concurrent use.
+type EnvironmentRegistry struct {
+	mu   sync.RWMutex
+	envs map[string]Environment
+}
+
+// NewEnvironmentRegistry creates an EnvironmentRegistry containing the built-in Azure clouds.
+func NewEnvironmentRegistry() *EnvironmentRegistry {
+	r := &EnvironmentRegistry{envs: map[string]Environment{}}
+	for name, env := range builtinEnvironments {
+		r.envs[name] = env
+	}
+	return r
+}
+
+// Get returns the environment with the specified name, the name is case-insensitive.
+func (r *EnvironmentRegistry) Get(name string) (Environment, error) {
+	name = strings.ToUpper(name)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	env, ok := r.envs[name]
+	if !ok {
+		return env, fmt.Errorf("autorest/azure: There is no cloud environment matching the name %q", name)
+	}
+	return env, nil
+}
+
+// Set adds or replaces the environment with the specified name without validating it.
+func (r *EnvironmentRegistry) Set(name string, env Environment) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.envs[strings.ToUpper(name)] = env
+}
+
+// Register validates the environment, see ValidateEnvironment, then adds or replaces the
+// environment with the specified name.
+func (r *EnvironmentRegistry) Register(name string, env Environment) error {
+	if err := ValidateEnvironment(env); err != nil {
+		return err
+	}
+	r.Set(name, env)
+	return nil
+}
+
+// Remove removes the environment with the specified name, returns false if there was no such environment.
+func (r *EnvironmentRegistry) Remove(name string) bool {
+	name = strings.ToUpper(name)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.envs[name]
+	delete(r.envs, name)
+	return ok
+}
+
+// List returns the sorted, upper-case names of the environments in the registry.
+func (r *EnvironmentRegistry) List() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.envs))
+	for name := range r.envs {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// LoadFile registers the environments defined in the specified JSON file and returns their names.
+// The file contains either a single environment, as read by EnvironmentFromFile, an array of
+// environments or an object mapping names to environments.  Environments are registered under
+// their name, or under the key of the object if any.  All environments are validated before any
+// is registered so if an error is returned the registry is left unchanged.
+func (r *EnvironmentRegistry) LoadFile(location string) ([]string, error) {
+	envs, err := readEnvironmentsFile(location)
+	if err != nil {
+		return nil, err
+	}
+	return r.setAll(envs), nil
+}
+
+// LoadDirectory registers the environments defined in every .json file in the specified directory,
+// see LoadFile, and returns their names.  Sub-directories are ignored.  If an error is returned
+// the registry is left unchanged.
+func (r *EnvironmentRegistry) LoadDirectory(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var envs []namedEnvironment
+	for _, f := range files {
+		if fi, err := os.Stat(f); err != nil {
+			return nil, err
+		} else if fi.IsDir() {
+			continue
+		}
+		fileEnvs, err := readEnvironmentsFile(f)
+		if err != nil {
+			return nil, err
+		}
+		envs = append(envs, fileEnvs...)
+	}
+	return r.setAll(envs), nil
+}
+
+// adds the environments under a single lock, returns their names
+func (r *EnvironmentRegistry) setAll(envs []namedEnvironment) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(envs))
+	for _, ne := range envs {
+		name := strings.ToUpper(ne.name)
+		r.envs[name] = ne.env
+		names = append(names, name)
+	}
+	return names
+}
+
+// an environment and the name it's registered under
+type namedEnvironment struct {
+	name string
+	env  Environment
+}
+
+// reads and validates the environments in a file
+func readEnvironmentsFile(location string) ([]namedEnvironment, error) {
+	contents, err := os.ReadFile(location)
+	if err != nil {
+		return nil, err
+	}
+	envs, err := parseEnvironments(contents)
+	if err != nil {
+		return nil, fmt.Errorf("autorest/azure: failed to load environments from %s: %w", location, err)
+	}
+	return envs, nil
+}
+
+func parseEnvironments(contents []byte) ([]namedEnvironment, error) {
+	var envs []namedEnvironment
+	contents = bytes.TrimSpace(contents)
+	if len(contents) > 0 && contents[0] == '[' {
+		var list []Environment
+		if err := json.Unmarshal(contents, &list); err != nil {
+			return nil, err
+		}
+		for _, env := range list {
+			envs = append(envs, namedEnvironment{name: env.Name, env: env})
+		}
+	} else {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(contents, &obj); err != nil {
+			return nil, err
+		}
+		var name string
+		if raw, ok := obj["name"]; ok && json.Unmarshal(raw, &name) == nil {
+			// a single environment
+			var env Environment
+			if err := json.Unmarshal(contents, &env); err != nil {
+				return nil, err
+			}
+			envs = append(envs, namedEnvironment{name: env.Name, env: env})
+		} else {
+			for key, raw := range obj {
+				var env Environment
+				if err := json.Unmarshal(raw, &env); err != nil {
+					return nil, fmt.Errorf("environment %s: %w", key, err)
+				}
+				if env.Name == "" {
+					env.Name = key
+				}
+				envs = append(envs, namedEnvironment{name: key, env: env})
+			}
+			// make the order deterministic
+			sort.Slice(envs, func(i, j int) bool { return envs[i].name < envs[j].name })
+		}
+	}
+	for _, ne := range envs {
+		if ne.name == "" {
+			return nil, errors.New("environment name is empty")
+		}
+		if err := validateEnvironment(ne.env); err != nil {
+			return nil, err
+		}
+	}
+	return envs, nil
+}
+
+// ValidateEnvironment returns an error if the environment lacks a name or any of the endpoints
+// required to authenticate and call Resource Manager, i.e. ResourceManagerEndpoint,
+// ActiveDirectoryEndpoint and TokenAudience.  The endpoints must be absolute URLs.
+func ValidateEnvironment(env Environment) error {
+	if err := validateEnvironment(env); err != nil {
+		return fmt.Errorf("autorest/azure: %w", err)
+	}
+	return nil
+}
+
+func validateEnvironment(env Environment) error {
+	if env.Name == "" {
+		return errors.New("environment name is empty")
+	}
+	required := []struct {
+		field string
+		value string
+	}{
+		{"resourceManagerEndpoint", env.ResourceManagerEndpoint},
+		{"activeDirectoryEndpoint", env.ActiveDirectoryEndpoint},
+		{"tokenAudience", env.TokenAudience},
+	}
+	for _, r := range required {
+		if r.value == "" || r.value == NotAvailable {
+			return fmt.Errorf("environment %s: %s is required", env.Name, r.field)
+		}
+		if u, err := url.Parse(r.value); err != nil || !u.IsAbs() || u.Host == "" {
+			return fmt.Errorf("environment %s: %s %q is not an absolute URL", env.Name, r.field, r.value)
+		}
+	}
+	return nil
+}
+
+// ListEnvironments returns the sorted, upper-case names of the environments that can be passed
+// to EnvironmentFromName, including the ones added with SetEnvironment or loaded from files.
+func ListEnvironments() []string {
+	return environments.List()
+}
+
+// LoadEnvironmentsFromFile loads the environments in the specified file, see EnvironmentRegistry.LoadFile,
+// so they can be retrieved with EnvironmentFromName.
+func LoadEnvironmentsFromFile(location string) ([]string, error) {
+	return environments.LoadFile(location)
+}
+
+// LoadEnvironmentsFromDirectory loads the environments in the specified directory, see
+// EnvironmentRegistry.LoadDirectory, so they can be retrieved with EnvironmentFromName.
+func LoadEnvironmentsFromDirectory(dir string) ([]string, error) {
+	return environments.LoadDirectory(dir)
+}
@@ -0,0 +1,168 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testStackEnvironment(name string) string {
+	return fmt.Sprintf(`{
+	"name": %q,
+	"resourceManagerEndpoint": "https://management.%[1]s.contoso.com/",
+	"activeDirectoryEndpoint": "https://login.%[1]s.contoso.com/",
+	"tokenAudience": "https://management.%[1]s.contoso.com/"
+}`, name)
+}
+
+func writeTestFile(t *testing.T, dir, name, contents string) string {
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte(contents), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", p, err)
+	}
+	return p
+}
+
+func TestEnvironmentRegistry_Builtin(t *testing.T) {
+	r := NewEnvironmentRegistry()
+	env, err := r.Get("AzurePublicCloud")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env != PublicCloud {
+		t.Fatalf("expected PublicCloud, got %s", env.Name)
+	}
+	if len(r.List()) != len(builtinEnvironments) {
+		t.Fatalf("unexpected environments %v", r.List())
+	}
+}
+
+func TestEnvironmentRegistry_LoadFile(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		contents string
+		expected []string
+	}{
+		{testStackEnvironment("stack1"), []string{"STACK1"}},
+		{"[" + testStackEnvironment("stack1") + "," + testStackEnvironment("stack2") + "]", []string{"STACK1", "STACK2"}},
+		{`{"b": ` + testStackEnvironment("stack2") + `, "a": ` + testStackEnvironment("stack1") + "}", []string{"A", "B"}},
+	}
+	for i, tc := range testCases {
+		r := NewEnvironmentRegistry()
+		names, err := r.LoadFile(writeTestFile(t, dir, fmt.Sprintf("%d.json", i), tc.contents))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Join(names, ",") != strings.Join(tc.expected, ",") {
+			t.Fatalf("expected %v, got %v", tc.expected, names)
+		}
+		for _, name := range names {
+			if _, err := r.Get(strings.ToLower(name)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+}
+
+func TestEnvironmentRegistry_LoadFileInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for i, contents := range []string{
+		"not json",
+		`{"name": "stack1"}`,
+		`[{"name": "stack1", "resourceManagerEndpoint": "management", "activeDirectoryEndpoint": "https://login/", "tokenAudience": "https://management/"}]`,
+		// one invalid environment fails the whole file
+		"[" + testStackEnvironment("stack1") + `, {"name": "stack2", "resourceManagerEndpoint": "N/A"}]`,
+	} {
+		r := NewEnvironmentRegistry()
+		if _, err := r.LoadFile(writeTestFile(t, dir, fmt.Sprintf("%d.json", i), contents)); err == nil {
+			t.Fatalf("expected an error for %s", contents)
+		}
+		if _, err := r.Get("stack1"); err == nil {
+			t.Fatal("the registry must not be modified")
+		}
+	}
+}
+
+func TestEnvironmentRegistry_LoadDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "stack1.json", testStackEnvironment("stack1"))
+	writeTestFile(t, dir, "sovereign.json", `{"stack2": `+testStackEnvironment("stack2")+`}`)
+	writeTestFile(t, dir, "readme.txt", "not an environment")
+	r := NewEnvironmentRegistry()
+	names, err := r.LoadDirectory(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("unexpected names %v", names)
+	}
+	env, err := r.Get("Stack2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env.ResourceManagerEndpoint != "https://management.stack2.contoso.com/" {
+		t.Fatalf("unexpected environment %v", env)
+	}
+
+	writeTestFile(t, dir, "broken.json", "{")
+	r = NewEnvironmentRegistry()
+	if _, err = r.LoadDirectory(dir); err == nil || !strings.Contains(err.Error(), "broken.json") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = r.Get("stack1"); err == nil {
+		t.Fatal("the registry must not be modified")
+	}
+}
+
+func TestEnvironmentRegistry_Concurrency(t *testing.T) {
+	r := NewEnvironmentRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			r.Set(fmt.Sprintf("env%d", i), Environment{Name: "env"})
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, _ = r.Get("AzurePublicCloud")
+			_ = r.List()
+		}()
+	}
+	wg.Wait()
+	if !r.Remove("env0") || r.Remove("env0") {
+		t.Fatal("unexpected result from Remove")
+	}
+}
+
+func TestEnvironmentRegistry_Register(t *testing.T) {
+	r := NewEnvironmentRegistry()
+	if err := r.Register("invalid", Environment{Name: "invalid"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := r.Register("public", PublicCloud); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range builtinEnvironments {
+		if err := ValidateEnvironment(name); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
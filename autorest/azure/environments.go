@@ -17,6 +17,7 @@ package azure
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"strings"
 )
@@ -30,7 +31,8 @@ const (
 	NotAvailable = "N/A"
 )
 
-var environments = map[string]Environment{
+// the clouds known to every EnvironmentRegistry, keyed by upper-case name
+var builtinEnvironments = map[string]Environment{
 	"AZURECHINACLOUD":        ChinaCloud,
 	"AZUREGERMANCLOUD":       GermanCloud,
 	"AZURECLOUD":             PublicCloud,
@@ -301,13 +303,7 @@ func EnvironmentFromName(name string) (Environment, error) {
 		return EnvironmentFromFile(os.Getenv(EnvironmentFilepathName))
 	}
 
-	name = strings.ToUpper(name)
-	env, ok := environments[name]
-	if !ok {
-		return env, fmt.Errorf("autorest/azure: There is no cloud environment matching the name %q", name)
-	}
-
-	return env, nil
+	return environments.Get(name)
 }
 
 // EnvironmentFromFile loads an Environment from a configuration file available on disk.
@@ -325,6 +321,78 @@ func EnvironmentFromFile(location string) (unmarshaled Environment, err error) {
 }
 
 // SetEnvironment updates the environment map with the specified values.
+// It's safe to call concurrently with EnvironmentFromName.
 func SetEnvironment(name string, env Environment) {
-	environments[strings.ToUpper(name)] = env
+	environments.Set(name, env)
+}
+
+// ResourceForURL returns the resource, i.e. the token audience, to use when authenticating requests
+// to the specified URL.  The host of the URL is matched against the endpoints and DNS suffixes of the
+// environment, e.g. https://myvault.vault.azure.net maps to ResourceIdentifiers.KeyVault in the public
+// cloud and https://myaccount.blob.core.windows.net to ResourceIdentifiers.Storage.  An error is
+// returned if the host doesn't belong to any service of the environment.
+func (env Environment) ResourceForURL(u *url.URL) (string, error) {
+	if u == nil || u.Hostname() == "" {
+		return "", fmt.Errorf("autorest/azure: the URL has no host")
+	}
+	host := strings.ToLower(u.Hostname())
+	armAudience := env.TokenAudience
+	if armAudience == "" {
+		armAudience = env.ResourceManagerEndpoint
+	}
+	// endpoints whose host must match exactly
+	endpoints := []struct {
+		endpoint string
+		resource string
+	}{
+		{env.ResourceManagerEndpoint, armAudience},
+		{env.ServiceManagementEndpoint, env.ServiceManagementEndpoint},
+		{env.GraphEndpoint, env.ResourceIdentifiers.Graph},
+		{env.MicrosoftGraphEndpoint, env.ResourceIdentifiers.MicrosoftGraph},
+		{env.BatchManagementEndpoint, env.ResourceIdentifiers.Batch},
+		{env.ResourceIdentifiers.OperationalInsights, env.ResourceIdentifiers.OperationalInsights},
+	}
+	for _, e := range endpoints {
+		if !isAvailable(e.endpoint) || !isAvailable(e.resource) {
+			continue
+		}
+		if eu, err := url.Parse(e.endpoint); err == nil && strings.EqualFold(eu.Hostname(), host) {
+			return e.resource, nil
+		}
+	}
+	// DNS suffixes of the services, the longest matching suffix wins
+	suffixes := []struct {
+		suffix   string
+		resource string
+	}{
+		{env.KeyVaultDNSSuffix, env.ResourceIdentifiers.KeyVault},
+		{env.ManagedHSMDNSSuffix, env.ResourceIdentifiers.ManagedHSM},
+		{env.StorageEndpointSuffix, env.ResourceIdentifiers.Storage},
+		{env.ServiceBusEndpointSuffix, env.ResourceIdentifiers.ServiceBus},
+		{env.SQLDatabaseDNSSuffix, env.ResourceIdentifiers.SQLDatabase},
+		{env.CosmosDBDNSSuffix, env.ResourceIdentifiers.CosmosDB},
+		{env.MySQLDatabaseDNSSuffix, env.ResourceIdentifiers.OSSRDBMS},
+		{env.PostgresqlDatabaseDNSSuffix, env.ResourceIdentifiers.OSSRDBMS},
+		{env.MariaDBDNSSuffix, env.ResourceIdentifiers.OSSRDBMS},
+		{env.SynapseEndpointSuffix, env.ResourceIdentifiers.Synapse},
+		{env.DatalakeSuffix, env.ResourceIdentifiers.Datalake},
+	}
+	resource, matched := "", ""
+	for _, s := range suffixes {
+		if !isAvailable(s.suffix) || !isAvailable(s.resource) {
+			continue
+		}
+		suffix := strings.ToLower(strings.TrimPrefix(s.suffix, "."))
+		if strings.HasSuffix(host, "."+suffix) && len(suffix) > len(matched) {
+			resource, matched = s.resource, suffix
+		}
+	}
+	if resource == "" {
+		return "", fmt.Errorf("autorest/azure: no resource of the %s environment matches the host %s", env.Name, host)
+	}
+	return resource, nil
+}
+
+func isAvailable(value string) bool {
+	return value != "" && value != NotAvailable
 }
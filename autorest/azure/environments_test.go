@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -505,3 +506,43 @@ func TestSetEnvironment(t *testing.T) {
 		t.Fatalf("expected %v, got %v", testEnv, result)
 	}
 }
+
+func TestEnvironment_ResourceForURL(t *testing.T) {
+	testCases := []struct {
+		env      Environment
+		url      string
+		expected string
+	}{
+		{PublicCloud, "https://management.azure.com/subscriptions/123", "https://management.azure.com/"},
+		{PublicCloud, "https://MyVault.vault.azure.net/secrets/s", "https://vault.azure.net"},
+		{PublicCloud, "https://myaccount.blob.core.windows.net/container", "https://storage.azure.com/"},
+		{PublicCloud, "https://myhsm.managedhsm.azure.net:443/keys", "https://managedhsm.azure.net"},
+		{PublicCloud, "https://myserver.database.windows.net", "https://database.windows.net/"},
+		{PublicCloud, "https://myserver.postgres.database.azure.com", "https://ossrdbms-aad.database.windows.net"},
+		{PublicCloud, "https://graph.microsoft.com/v1.0/me", "https://graph.microsoft.com/"},
+		{PublicCloud, "https://api.loganalytics.io/v1/workspaces", "https://api.loganalytics.io"},
+		{ChinaCloud, "https://myvault.vault.azure.cn", "https://vault.azure.cn"},
+		{USGovernmentCloud, "https://management.usgovcloudapi.net", "https://management.usgovcloudapi.net/"},
+	}
+	for _, tc := range testCases {
+		u, _ := url.Parse(tc.url)
+		resource, err := tc.env.ResourceForURL(u)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.url, err)
+		}
+		if resource != tc.expected {
+			t.Fatalf("%s: expected %s, got %s", tc.url, tc.expected, resource)
+		}
+	}
+	for _, bad := range []string{"https://example.com", "https://vault.azure.net.example.com", "/relative"} {
+		u, _ := url.Parse(bad)
+		if _, err := PublicCloud.ResourceForURL(u); err == nil {
+			t.Fatalf("%s: expected an error", bad)
+		}
+	}
+	// services that aren't available in the cloud
+	u, _ := url.Parse("https://myhsm.managedhsm.azure.net")
+	if _, err := USGovernmentCloud.ResourceForURL(u); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,166 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// The errors a RequestError matches with errors.Is, based on its status code and error code.
+var (
+	// ErrNotFound matches a 404 or an error code reporting a missing resource.
+	ErrNotFound = errors.New("azure: not found")
+
+	// ErrConflict matches a 409 or the Conflict error code.
+	ErrConflict = errors.New("azure: conflict")
+
+	// ErrThrottled matches a 429 or an error code reporting throttling.
+	ErrThrottled = errors.New("azure: throttled")
+
+	// ErrPreconditionFailed matches a 412 or the PreconditionFailed error code.
+	ErrPreconditionFailed = errors.New("azure: precondition failed")
+
+	// ErrAuthorizationFailed matches a 403 or an error code reporting the caller isn't authorized.
+	ErrAuthorizationFailed = errors.New("azure: authorization failed")
+)
+
+// the status code and error codes of each sentinel error
+var errorClasses = []struct {
+	err        error
+	statusCode int
+	codes      []string
+}{
+	{ErrNotFound, http.StatusNotFound, []string{"NotFound", "ResourceNotFound", "ResourceGroupNotFound", "ParentResourceNotFound", "SubscriptionNotFound"}},
+	{ErrConflict, http.StatusConflict, []string{"Conflict"}},
+	{ErrThrottled, http.StatusTooManyRequests, []string{"TooManyRequests", "Throttled", "SubscriptionRequestsThrottled", "TenantRequestsThrottled"}},
+	{ErrPreconditionFailed, http.StatusPreconditionFailed, []string{"PreconditionFailed"}},
+	{ErrAuthorizationFailed, http.StatusForbidden, []string{"AuthorizationFailed", "LinkedAuthorizationFailed"}},
+}
+
+// Is returns true if target is the sentinel error matching the status code or the error code of
+// the service error, e.g. errors.Is(err, azure.ErrNotFound).
+func (e RequestError) Is(target error) bool {
+	statusCode, _ := e.StatusCode.(int)
+	code := ""
+	if e.ServiceError != nil {
+		code = e.ServiceError.Code
+	}
+	for _, c := range errorClasses {
+		if c.err != target {
+			continue
+		}
+		if statusCode == c.statusCode {
+			return true
+		}
+		for _, cc := range c.codes {
+			if code != "" && code == cc {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// the details of the first errors in a chain that carry them
+type errorDetails struct {
+	statusCode int
+	code       string
+	requestID  string
+	resp       *http.Response
+}
+
+// walks the error chain, including azure.RequestError and autorest.DetailedError
+func getErrorDetails(err error) errorDetails {
+	d := errorDetails{}
+	fromDetailedError := func(de autorest.DetailedError) {
+		if sc, ok := de.StatusCode.(int); ok && sc != 0 && d.statusCode == 0 {
+			d.statusCode = sc
+		}
+		if de.Response != nil && d.resp == nil {
+			d.resp = de.Response
+		}
+	}
+	fromRequestError := func(re RequestError) {
+		if re.ServiceError != nil && re.ServiceError.Code != "" && d.code == "" {
+			d.code = re.ServiceError.Code
+		}
+		if re.RequestID != "" && d.requestID == "" {
+			d.requestID = re.RequestID
+		}
+		fromDetailedError(re.DetailedError)
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		switch e := err.(type) {
+		case *RequestError:
+			if e != nil {
+				fromRequestError(*e)
+			}
+		case RequestError:
+			fromRequestError(e)
+		case *autorest.DetailedError:
+			if e != nil {
+				fromDetailedError(*e)
+			}
+		case autorest.DetailedError:
+			fromDetailedError(e)
+		case *ServiceError:
+			if e != nil && e.Code != "" && d.code == "" {
+				d.code = e.Code
+			}
+		case ServiceError:
+			if e.Code != "" && d.code == "" {
+				d.code = e.Code
+			}
+		}
+	}
+	if d.resp != nil {
+		if d.statusCode == 0 {
+			d.statusCode = d.resp.StatusCode
+		}
+		if d.requestID == "" {
+			d.requestID = ExtractRequestID(d.resp)
+		}
+	}
+	return d
+}
+
+// ErrorStatusCode returns the HTTP status code of the first error in the chain that has one,
+// e.g. an azure.RequestError or an autorest.DetailedError, or zero if there's none.
+func ErrorStatusCode(err error) int {
+	return getErrorDetails(err).statusCode
+}
+
+// ErrorCode returns the Azure error code, e.g. ResourceGroupNotFound, of the first service error
+// in the chain, or an empty string if there's none.
+func ErrorCode(err error) string {
+	return getErrorDetails(err).code
+}
+
+// ErrorRequestID returns the service generated request ID, from the x-ms-request-id header, of
+// the first response in the chain, or an empty string if there's none.
+func ErrorRequestID(err error) string {
+	return getErrorDetails(err).requestID
+}
+
+// ErrorCorrelationID returns the Resource Manager correlation ID, from the
+// x-ms-correlation-request-id header, of the first response in the chain, or of its request if
+// the response has none, or an empty string if there's none.
+func ErrorCorrelationID(err error) string {
+	return ExtractCorrelationID(getErrorDetails(err).resp)
+}
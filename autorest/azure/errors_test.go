@@ -0,0 +1,114 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+// returns the error of a response with the specified status and error code
+func newServiceErrorResponse(t *testing.T, statusCode int, code string) error {
+	r := mocks.NewResponseWithBodyAndStatus(mocks.NewBody(fmt.Sprintf(`{"error":{"code":%q,"message":"failed"}}`, code)), statusCode, http.StatusText(statusCode))
+	mocks.SetResponseHeader(r, HeaderRequestID, "request-id")
+	mocks.SetResponseHeader(r, HeaderCorrelationRequestID, "correlation-id")
+	err := autorest.Respond(r, WithErrorUnlessStatusCode(http.StatusOK))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	return err
+}
+
+func TestRequestErrorIs(t *testing.T) {
+	testCases := []struct {
+		statusCode int
+		code       string
+		expected   error
+	}{
+		{http.StatusNotFound, "ResourceNotFound", ErrNotFound},
+		{http.StatusBadRequest, "ResourceGroupNotFound", ErrNotFound},
+		{http.StatusConflict, "AnotherOperationInProgress", ErrConflict},
+		{http.StatusTooManyRequests, "", ErrThrottled},
+		{http.StatusPreconditionFailed, "", ErrPreconditionFailed},
+		{http.StatusForbidden, "AuthorizationFailed", ErrAuthorizationFailed},
+		{http.StatusBadRequest, "LinkedAuthorizationFailed", ErrAuthorizationFailed},
+	}
+	all := []error{ErrNotFound, ErrConflict, ErrThrottled, ErrPreconditionFailed, ErrAuthorizationFailed}
+	for _, tc := range testCases {
+		err := newServiceErrorResponse(t, tc.statusCode, tc.code)
+		for _, wrapped := range []error{err, fmt.Errorf("wrapped: %w", err), autorest.NewErrorWithError(err, "pkg", "method", nil, "failed")} {
+			for _, sentinel := range all {
+				if errors.Is(wrapped, sentinel) != (sentinel == tc.expected) {
+					t.Fatalf("%d %s: unexpected errors.Is(%v) for %v", tc.statusCode, tc.code, sentinel, wrapped)
+				}
+			}
+		}
+	}
+	// value errors match too
+	re := RequestError{DetailedError: autorest.DetailedError{StatusCode: http.StatusNotFound}}
+	if !errors.Is(re, ErrNotFound) || !IsAzureError(fmt.Errorf("%w", re)) {
+		t.Fatal("expected the value RequestError to match")
+	}
+	if errors.Is(newServiceErrorResponse(t, http.StatusBadRequest, "InvalidParameter"), ErrNotFound) {
+		t.Fatal("unexpected match")
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	err := autorest.NewErrorWithError(fmt.Errorf("wrapped: %w", newServiceErrorResponse(t, http.StatusNotFound, "ResourceGroupNotFound")),
+		"resources.GroupsClient", "Get", nil, "Failure responding to request")
+	if sc := ErrorStatusCode(err); sc != http.StatusNotFound {
+		t.Fatalf("unexpected status code %d", sc)
+	}
+	if code := ErrorCode(err); code != "ResourceGroupNotFound" {
+		t.Fatalf("unexpected code %s", code)
+	}
+	if id := ErrorRequestID(err); id != "request-id" {
+		t.Fatalf("unexpected request ID %s", id)
+	}
+	if id := ErrorCorrelationID(err); id != "correlation-id" {
+		t.Fatalf("unexpected correlation ID %s", id)
+	}
+
+	// a DetailedError without a service error
+	resp := mocks.NewResponseWithStatus("503", http.StatusServiceUnavailable)
+	mocks.SetResponseHeader(resp, HeaderRequestID, "other")
+	err = autorest.NewErrorWithResponse("pkg", "method", resp, "failed")
+	if ErrorStatusCode(err) != http.StatusServiceUnavailable || ErrorRequestID(err) != "other" || ErrorCode(err) != "" {
+		t.Fatalf("unexpected details for %v", err)
+	}
+
+	// the correlation ID that was sent when the service doesn't return it
+	resp = mocks.NewResponseWithBodyAndStatus(mocks.NewBody(`{"error":{"code":"ServerBusy"}}`), http.StatusServiceUnavailable, "503")
+	resp.Request = mocks.NewRequest()
+	resp.Request.Header.Set(HeaderCorrelationRequestID, "sent")
+	sent := autorest.Respond(resp, WithErrorUnlessStatusCode(http.StatusOK))
+	if id := ErrorCorrelationID(sent); id != "sent" {
+		t.Fatalf("unexpected correlation ID %s", id)
+	}
+	if re := (*RequestError)(nil); !errors.As(sent, &re) || re.CorrelationRequestID != "sent" {
+		t.Fatalf("unexpected error %v", sent)
+	}
+
+	plain := errors.New("not an azure error")
+	if ErrorStatusCode(plain) != 0 || ErrorCode(plain) != "" || ErrorRequestID(plain) != "" || ErrorCorrelationID(plain) != "" {
+		t.Fatal("expected no details")
+	}
+}
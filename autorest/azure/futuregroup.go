@@ -0,0 +1,309 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/logger"
+	"github.com/Azure/go-autorest/tracing"
+)
+
+// DefaultFutureGroupConcurrency is the default maximum number of polls a FutureGroup makes concurrently.
+const DefaultFutureGroupConcurrency = 10
+
+// FutureResult is the outcome of one of the futures in a FutureGroup.
+type FutureResult struct {
+	// Name is the name the future was added with.
+	Name string
+
+	// Future is the future that completed.  Use it to retrieve the result of the operation.
+	Future FutureAPI
+
+	// Err is the reason the operation failed, or nil if it succeeded.
+	Err error
+}
+
+// FutureGroupError is returned by FutureGroup.Wait when one or more operations failed.
+type FutureGroupError struct {
+	// Failed contains the results of the operations that failed, in the order they completed.
+	Failed []FutureResult
+
+	// Total is the number of operations in the group.
+	Total int
+}
+
+// Error returns an error message naming the operations that failed.
+func (e FutureGroupError) Error() string {
+	failures := make([]string, 0, len(e.Failed))
+	for _, r := range e.Failed {
+		failures = append(failures, fmt.Sprintf("%s: %v", r.Name, r.Err))
+	}
+	return fmt.Sprintf("azure: %d of %d operations failed: %s", len(e.Failed), e.Total, strings.Join(failures, "; "))
+}
+
+// FutureGroup waits for the completion of many futures.  Instead of a goroutine polling each
+// future, a single scheduler polls the future that's due next, honoring each future's Retry-After,
+// with a bounded number of concurrent polls and an optional rate limit shared by all futures.
+// The group's methods must not be called concurrently and a group can only be waited on once.
+type FutureGroup struct {
+	// MaxConcurrency is the maximum number of polls in flight, DefaultFutureGroupConcurrency if zero.
+	MaxConcurrency int
+
+	// MaxPollsPerSecond limits the rate of polls across all futures, zero means no limit.
+	MaxPollsPerSecond float64
+
+	// OnComplete, if not nil, is called as each operation completes.  It's called from the goroutine
+	// that called Wait, one call at a time, so it should return quickly.
+	OnComplete func(FutureResult)
+
+	client  autorest.Client
+	entries []*futureGroupEntry
+}
+
+// NewFutureGroup creates an empty FutureGroup that polls with the specified client.
+// The client's PollingDelay, or the PollingOptions in the context passed to Wait, are used when a
+// future doesn't return a Retry-After and failed polls are retried with the client's RetryDuration
+// up to RetryAttempts times, like WaitForCompletionRef.
+// If the context passed to Wait has no deadline the client's PollingDuration applies to the whole group.
+func NewFutureGroup(client autorest.Client) *FutureGroup {
+	return &FutureGroup{
+		MaxConcurrency: DefaultFutureGroupConcurrency,
+		client:         client,
+	}
+}
+
+// Add adds a future to the group under the specified name, which identifies the operation in
+// results and errors.  The future must not be used by the caller until Wait returns.
+func (g *FutureGroup) Add(name string, future FutureAPI) {
+	g.entries = append(g.entries, &futureGroupEntry{
+		name:   name,
+		future: future,
+	})
+}
+
+// Len returns the number of futures in the group.
+func (g *FutureGroup) Len() int {
+	return len(g.entries)
+}
+
+// Wait polls the futures until all of them have completed or the context is cancelled.
+// It returns a FutureGroupError naming the operations that failed, or that hadn't completed when
+// the context was cancelled, or nil if all operations succeeded.
+func (g *FutureGroup) Wait(ctx context.Context) (err error) {
+	ctx = tracing.StartSpan(ctx, "github.com/Azure/go-autorest/autorest/azure/async.FutureGroup.Wait")
+	defer func() {
+		tracing.EndSpan(ctx, -1, err)
+	}()
+	// if the provided context already has a deadline don't override it
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline && g.client.PollingDuration != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.client.PollingDuration)
+		defer cancel()
+	}
+	s := newFutureScheduler(g, GetPollingOptions(ctx))
+	failed := s.run(ctx)
+	if len(failed) > 0 {
+		return FutureGroupError{Failed: failed, Total: len(g.entries)}
+	}
+	return nil
+}
+
+// the state of a future in a FutureGroup
+type futureGroupEntry struct {
+	name   string
+	future FutureAPI
+
+	// the time the future is due to be polled
+	next time.Time
+
+	// the number of consecutive failed polls
+	attempts int
+
+	// the number of successful polls
+	polls int
+}
+
+// the outcome of a single poll
+type futureGroupPoll struct {
+	entry *futureGroupEntry
+	done  bool
+	err   error
+}
+
+// futureQueue is a min-heap of futures ordered by the time they're due to be polled
+type futureQueue []*futureGroupEntry
+
+func (q futureQueue) Len() int            { return len(q) }
+func (q futureQueue) Less(i, j int) bool  { return q[i].next.Before(q[j].next) }
+func (q futureQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *futureQueue) Push(x interface{}) { *q = append(*q, x.(*futureGroupEntry)) }
+func (q *futureQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// futureScheduler dispatches the polls of a FutureGroup
+type futureScheduler struct {
+	group    *FutureGroup
+	options  PollingOptions
+	queue    futureQueue
+	results  chan futureGroupPoll
+	inFlight int
+	interval time.Duration
+	lastPoll time.Time
+	failed   []FutureResult
+}
+
+func newFutureScheduler(g *FutureGroup, options PollingOptions) *futureScheduler {
+	s := &futureScheduler{
+		group:   g,
+		options: options,
+		results: make(chan futureGroupPoll),
+	}
+	if g.MaxPollsPerSecond > 0 {
+		s.interval = time.Duration(float64(time.Second) / g.MaxPollsPerSecond)
+	}
+	now := time.Now()
+	for _, e := range g.entries {
+		// if the initial response has a Retry-After, wait for the specified amount of time before polling
+		e.next = now
+		if delay, ok := e.future.GetPollingDelay(); ok {
+			e.next = now.Add(options.clamp(delay))
+		}
+		e.attempts = 0
+		e.polls = 0
+		s.queue = append(s.queue, e)
+	}
+	heap.Init(&s.queue)
+	return s
+}
+
+// runs the scheduler until all futures have completed or the context is done, returns the failures
+func (s *futureScheduler) run(ctx context.Context) []FutureResult {
+	maxConcurrency := s.group.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = DefaultFutureGroupConcurrency
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for len(s.queue) > 0 || s.inFlight > 0 {
+		// dispatch every future that's due, within the concurrency and rate limits
+		var wait time.Duration = -1
+		for len(s.queue) > 0 && s.inFlight < maxConcurrency {
+			now := time.Now()
+			due := s.queue[0].next
+			if s.interval > 0 && !s.lastPoll.IsZero() && s.lastPoll.Add(s.interval).After(due) {
+				due = s.lastPoll.Add(s.interval)
+			}
+			if due.After(now) {
+				wait = due.Sub(now)
+				break
+			}
+			s.dispatch(ctx, heap.Pop(&s.queue).(*futureGroupEntry))
+			s.lastPoll = now
+		}
+		var tick <-chan time.Time
+		if wait >= 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			tick = timer.C
+		}
+		select {
+		case r := <-s.results:
+			s.inFlight--
+			s.handle(r)
+		case <-tick:
+		case <-ctx.Done():
+			s.cancel(ctx.Err())
+			return s.failed
+		}
+	}
+	return s.failed
+}
+
+// polls the future on a new goroutine and sends the outcome to the results channel
+func (s *futureScheduler) dispatch(ctx context.Context, e *futureGroupEntry) {
+	s.inFlight++
+	go func() {
+		done, err := e.future.DoneWithContext(ctx, s.group.client)
+		s.results <- futureGroupPoll{entry: e, done: done, err: err}
+	}()
+}
+
+// completes or reschedules the future of a poll
+func (s *futureScheduler) handle(r futureGroupPoll) {
+	e := r.entry
+	client := s.group.client
+	switch {
+	case r.done:
+		s.complete(e, r.err)
+	case r.err != nil:
+		logger.Instance.Writef(logger.LogError, "FutureGroup: polling %s: %s\n", e.name, r.err)
+		if e.attempts >= client.RetryAttempts {
+			s.complete(e, autorest.NewErrorWithError(r.err, "FutureGroup", "Wait", e.future.Response(), "the number of retries has been exceeded"))
+			return
+		}
+		// exponential back-off based on the number of failed polls
+		e.next = time.Now().Add(time.Duration(float64(client.RetryDuration) * math.Pow(2, float64(e.attempts))))
+		e.attempts++
+		heap.Push(&s.queue, e)
+	default:
+		e.attempts = 0
+		e.next = time.Now().Add(s.options.nextDelay(e.future, client, e.polls))
+		e.polls++
+		heap.Push(&s.queue, e)
+	}
+}
+
+// records the completion of a future
+func (s *futureScheduler) complete(e *futureGroupEntry, err error) {
+	r := FutureResult{Name: e.name, Future: e.future, Err: err}
+	if err != nil {
+		s.failed = append(s.failed, r)
+	}
+	if s.group.OnComplete != nil {
+		s.group.OnComplete(r)
+	}
+}
+
+// waits for the polls in flight and fails the futures that haven't completed
+func (s *futureScheduler) cancel(err error) {
+	for ; s.inFlight > 0; s.inFlight-- {
+		r := <-s.results
+		if r.done {
+			s.complete(r.entry, r.err)
+		} else {
+			s.queue = append(s.queue, r.entry)
+		}
+	}
+	for _, e := range s.queue {
+		s.complete(e, autorest.NewErrorWithError(err, "FutureGroup", "Wait", e.future.Response(), "context has been cancelled"))
+	}
+	s.queue = nil
+}
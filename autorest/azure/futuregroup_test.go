@@ -0,0 +1,224 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+// operationSender serves operation status responses per polling URL
+type operationSender struct {
+	mu        sync.Mutex
+	responses map[string][]func() *http.Response
+	latency   time.Duration
+	inFlight  int
+	max       int
+	polls     []time.Time
+}
+
+func newOperationSender() *operationSender {
+	return &operationSender{responses: map[string][]func() *http.Response{}}
+}
+
+// adds the responses returned when polling the operation, the last one is repeated
+func (s *operationSender) add(operation string, responses ...func() *http.Response) {
+	s.responses[operationURL(operation)] = responses
+}
+
+func (s *operationSender) Do(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.max {
+		s.max = s.inFlight
+	}
+	s.polls = append(s.polls, time.Now())
+	u := req.URL.String()
+	responses := s.responses[u]
+	if len(responses) == 0 {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("unexpected request to %s", u)
+	}
+	next := responses[0]
+	if len(responses) > 1 {
+		s.responses[u] = responses[1:]
+	}
+	s.mu.Unlock()
+	time.Sleep(s.latency)
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+	resp := next()
+	resp.Request = req
+	return resp, nil
+}
+
+func operationURL(operation string) string {
+	return "https://microsoft.com/operations/" + operation
+}
+
+// creates a future for a PUT polled with Azure-AsyncOperation at the operation's URL
+func newOperationFuture(t *testing.T, operation string) *Future {
+	r := newAsyncResp(newAsyncReq(http.MethodPut, nil), http.StatusCreated, mocks.NewBody(fmt.Sprintf(operationResourceFormat, operationInProgress)))
+	mocks.SetResponseHeader(r, headerAsyncOperation, operationURL(operation))
+	future, err := NewFutureFromResponse(r)
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	return &future
+}
+
+func operationStatus(status string) func() *http.Response {
+	return func() *http.Response {
+		return newOperationResourceResponse(status)
+	}
+}
+
+func newFutureGroupClient(sender autorest.Sender) autorest.Client {
+	return autorest.Client{
+		PollingDelay:    retryDelay,
+		PollingDuration: autorest.DefaultPollingDuration,
+		RetryAttempts:   autorest.DefaultRetryAttempts,
+		RetryDuration:   retryDelay,
+		Sender:          sender,
+	}
+}
+
+func TestFutureGroup(t *testing.T) {
+	sender := newOperationSender()
+	sender.add("vm1", operationStatus("busy"), operationStatus(operationSucceeded))
+	sender.add("vm2", operationStatus("busy"), operationStatus("busy"), operationStatus(operationSucceeded))
+	sender.add("vm3", operationStatus("busy"), func() *http.Response {
+		return newOperationResourceErrorResponse(operationFailed)
+	})
+	// a transient error is retried
+	sender.add("vm4", func() *http.Response {
+		return mocks.NewResponseWithStatus("500 Internal Server Error", http.StatusInternalServerError)
+	}, operationStatus(operationSucceeded))
+
+	group := NewFutureGroup(newFutureGroupClient(sender))
+	var completed []string
+	group.OnComplete = func(r FutureResult) {
+		completed = append(completed, r.Name)
+		if r.Future.Status() == "" {
+			t.Errorf("unexpected status for %s", r.Name)
+		}
+	}
+	for _, name := range []string{"vm1", "vm2", "vm3", "vm4"} {
+		group.Add(name, newOperationFuture(t, name))
+	}
+	err := group.Wait(context.Background())
+	if len(completed) != 4 {
+		t.Fatalf("expected 4 completions, got %v", completed)
+	}
+	ge, ok := err.(FutureGroupError)
+	if !ok {
+		t.Fatalf("expected FutureGroupError, got %v", err)
+	}
+	if ge.Total != 4 || len(ge.Failed) != 1 || ge.Failed[0].Name != "vm3" {
+		t.Fatalf("unexpected error %v", ge)
+	}
+	if !strings.Contains(ge.Error(), "1 of 4 operations failed: vm3:") {
+		t.Fatalf("unexpected error message %s", ge.Error())
+	}
+}
+
+func TestFutureGroupSucceeds(t *testing.T) {
+	sender := newOperationSender()
+	group := NewFutureGroup(newFutureGroupClient(sender))
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("op%d", i)
+		sender.add(name, operationStatus("busy"), operationStatus(operationSucceeded))
+		group.Add(name, newOperationFuture(t, name))
+	}
+	if err := group.Wait(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestFutureGroupMaxConcurrency(t *testing.T) {
+	sender := newOperationSender()
+	sender.latency = 20 * time.Millisecond
+	group := NewFutureGroup(newFutureGroupClient(sender))
+	group.MaxConcurrency = 2
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("op%d", i)
+		sender.add(name, operationStatus(operationSucceeded))
+		group.Add(name, newOperationFuture(t, name))
+	}
+	if err := group.Wait(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sender.max != 2 {
+		t.Fatalf("expected at most 2 concurrent polls, got %d", sender.max)
+	}
+}
+
+func TestFutureGroupRateLimit(t *testing.T) {
+	sender := newOperationSender()
+	group := NewFutureGroup(newFutureGroupClient(sender))
+	group.MaxPollsPerSecond = 20
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("op%d", i)
+		sender.add(name, operationStatus(operationSucceeded))
+		group.Add(name, newOperationFuture(t, name))
+	}
+	if err := group.Wait(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sender.polls) != 5 {
+		t.Fatalf("expected 5 polls, got %d", len(sender.polls))
+	}
+	for i := 1; i < len(sender.polls); i++ {
+		// allow for timer imprecision
+		if d := sender.polls[i].Sub(sender.polls[i-1]); d < 45*time.Millisecond {
+			t.Fatalf("polls %d and %d were %v apart", i-1, i, d)
+		}
+	}
+}
+
+func TestFutureGroupCancelled(t *testing.T) {
+	sender := newOperationSender()
+	group := NewFutureGroup(newFutureGroupClient(sender))
+	sender.add("done", operationStatus(operationSucceeded))
+	group.Add("done", newOperationFuture(t, "done"))
+	sender.add("busy", operationStatus("busy"))
+	group.Add("busy", newOperationFuture(t, "busy"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := group.Wait(ctx)
+	ge, ok := err.(FutureGroupError)
+	if !ok {
+		t.Fatalf("expected FutureGroupError, got %v", err)
+	}
+	if len(ge.Failed) != 1 || ge.Failed[0].Name != "busy" {
+		t.Fatalf("unexpected error %v", ge)
+	}
+}
+
+func TestFutureGroupEmpty(t *testing.T) {
+	group := NewFutureGroup(newFutureGroupClient(newOperationSender()))
+	if err := group.Wait(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/tracing"
+)
+
+// FutureStore persists the serialized state of long-running operations so they
+// can be resumed by another process, e.g. after a worker restarts mid-poll.
+type FutureStore interface {
+	// Save stores the state under the specified key, replacing any existing state.
+	Save(ctx context.Context, key string, state []byte) error
+
+	// Load returns the state stored under the specified key.
+	// It returns a FutureNotFoundError if there's no state for the key.
+	Load(ctx context.Context, key string) ([]byte, error)
+
+	// Delete removes the state stored under the specified key.
+	// Deleting a key that doesn't exist isn't an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// FutureNotFoundError is returned by a FutureStore when there's no state for a key.
+type FutureNotFoundError struct {
+	// Key is the key that wasn't found.
+	Key string
+}
+
+// Error returns an error message including the key that wasn't found.
+func (e FutureNotFoundError) Error() string {
+	return fmt.Sprintf("azure: no future was found for key '%s'", e.Key)
+}
+
+// SaveFuture stores the state of the future in the store under the specified key.
+func SaveFuture(ctx context.Context, store FutureStore, key string, future FutureAPI) error {
+	b, err := future.MarshalJSON()
+	if err != nil {
+		return autorest.NewErrorWithError(err, "azure", "SaveFuture", nil, "failed to marshal the future")
+	}
+	if err = store.Save(ctx, key, b); err != nil {
+		return autorest.NewErrorWithError(err, "azure", "SaveFuture", nil, "failed to save the future")
+	}
+	return nil
+}
+
+// LoadFuture loads the state stored under the specified key into the future so polling
+// can be resumed.  The state is validated as described by Future.UnmarshalJSON.
+func LoadFuture(ctx context.Context, store FutureStore, key string, future FutureAPI) error {
+	b, err := store.Load(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err = future.UnmarshalJSON(b); err != nil {
+		return autorest.NewErrorWithError(err, "azure", "LoadFuture", nil, "failed to unmarshal the future for key '%s'", key)
+	}
+	return nil
+}
+
+// WaitForCompletionWithCheckpoint behaves like WaitForCompletionRef and saves the state of the future
+// in the store under the specified key before polling starts and after every poll, so that a process
+// that stops while waiting can resume the operation with LoadFuture.
+// The terminal state is saved too so the result can still be retrieved with GetResult after a
+// restart; delete the key from the store once the result has been processed.
+func (f *Future) WaitForCompletionWithCheckpoint(ctx context.Context, client autorest.Client, store FutureStore, key string) (err error) {
+	ctx = tracing.StartSpan(ctx, "github.com/Azure/go-autorest/autorest/azure/async.WaitForCompletionWithCheckpoint")
+	defer func() {
+		sc := -1
+		resp := f.Response()
+		if resp != nil {
+			sc = resp.StatusCode
+		}
+		tracing.EndSpan(ctx, sc, err)
+	}()
+	checkpoint := func(bool, error) error {
+		if err := SaveFuture(ctx, store, key, f); err != nil {
+			return autorest.NewErrorWithError(err, "Future", "WaitForCompletion", f.Response(), "failed to checkpoint the future")
+		}
+		return nil
+	}
+	if err = checkpoint(false, nil); err != nil {
+		return
+	}
+	return f.waitForCompletion(ctx, client, checkpoint)
+}
+
+// MemoryFutureStore is a FutureStore that keeps the state in memory.
+// It's safe for concurrent use and is mostly useful for testing.
+type MemoryFutureStore struct {
+	mu     sync.RWMutex
+	states map[string][]byte
+}
+
+// NewMemoryFutureStore creates an empty MemoryFutureStore.
+func NewMemoryFutureStore() *MemoryFutureStore {
+	return &MemoryFutureStore{
+		states: map[string][]byte{},
+	}
+}
+
+// Save implements the FutureStore interface.
+func (s *MemoryFutureStore) Save(ctx context.Context, key string, state []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[key] = append([]byte(nil), state...)
+	return nil
+}
+
+// Load implements the FutureStore interface.
+func (s *MemoryFutureStore) Load(ctx context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state, ok := s.states[key]
+	if !ok {
+		return nil, FutureNotFoundError{Key: key}
+	}
+	return append([]byte(nil), state...), nil
+}
+
+// Delete implements the FutureStore interface.
+func (s *MemoryFutureStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, key)
+	return nil
+}
+
+// FileFutureStore is a FutureStore that keeps the state of each future in a file in a directory.
+// Files are replaced atomically so a process that stops while saving doesn't corrupt the state.
+type FileFutureStore struct {
+	dir string
+}
+
+// NewFileFutureStore creates a FileFutureStore that keeps its files in the specified directory.
+// The directory is created if it doesn't exist.
+func NewFileFutureStore(dir string) (*FileFutureStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory (%s) to store futures in: %v", dir, err)
+	}
+	return &FileFutureStore{dir: dir}, nil
+}
+
+// returns the path of the file for the key.  keys are hashed as they can contain
+// characters that aren't valid in file names, e.g. a resource ID.
+func (s *FileFutureStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Save implements the FutureStore interface.
+func (s *FileFutureStore) Save(ctx context.Context, key string, state []byte) error {
+	path := s.path(key)
+	newFile, err := os.CreateTemp(s.dir, "future")
+	if err != nil {
+		return fmt.Errorf("failed to create the temp file to write the future: %v", err)
+	}
+	tempPath := newFile.Name()
+	_, err = newFile.Write(state)
+	if cerr := newFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write the future to temp file %s: %v", tempPath, err)
+	}
+	// Atomic replace to avoid corrupting the state if the process stops while saving
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to move the temporary future to its location. src=%s dst=%s: %v", tempPath, path, err)
+	}
+	return nil
+}
+
+// Load implements the FutureStore interface.
+func (s *FileFutureStore) Load(ctx context.Context, key string) ([]byte, error) {
+	b, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, FutureNotFoundError{Key: key}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the future for key '%s': %v", key, err)
+	}
+	return b, nil
+}
+
+// Delete implements the FutureStore interface.
+func (s *FileFutureStore) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete the future for key '%s': %v", key, err)
+	}
+	return nil
+}
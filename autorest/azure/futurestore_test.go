@@ -0,0 +1,215 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+const futureKey = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm"
+
+func testFutureStore(t *testing.T, store FutureStore) {
+	ctx := context.Background()
+	if _, err := store.Load(ctx, futureKey); err == nil {
+		t.Fatal("expected an error loading a missing key")
+	} else if _, ok := err.(FutureNotFoundError); !ok {
+		t.Fatalf("expected FutureNotFoundError, got %T", err)
+	}
+	for _, state := range []string{"first", "second"} {
+		if err := store.Save(ctx, futureKey, []byte(state)); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+		b, err := store.Load(ctx, futureKey)
+		if err != nil {
+			t.Fatalf("failed to load: %v", err)
+		}
+		if string(b) != state {
+			t.Fatalf("expected %s, got %s", state, b)
+		}
+	}
+	if err := store.Delete(ctx, futureKey); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := store.Load(ctx, futureKey); err == nil {
+		t.Fatal("expected an error loading a deleted key")
+	}
+	if err := store.Delete(ctx, futureKey); err != nil {
+		t.Fatalf("failed to delete a missing key: %v", err)
+	}
+}
+
+func TestMemoryFutureStore(t *testing.T) {
+	store := NewMemoryFutureStore()
+	testFutureStore(t, store)
+
+	// the store must not retain the caller's buffer
+	state := []byte("state")
+	if err := store.Save(context.Background(), futureKey, state); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	copy(state, "xxxxx")
+	if b, _ := store.Load(context.Background(), futureKey); string(b) != "state" {
+		t.Fatalf("unexpected state %s", b)
+	}
+}
+
+func TestFileFutureStore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "futures")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileFutureStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	testFutureStore(t, store)
+
+	// a new store over the same directory sees the saved state
+	if err := store.Save(context.Background(), futureKey, []byte("state")); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	other, err := NewFileFutureStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if b, err := other.Load(context.Background(), futureKey); err != nil || string(b) != "state" {
+		t.Fatalf("unexpected state %s: %v", b, err)
+	}
+	// no temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(entries))
+	}
+}
+
+// records every state saved to the store
+type recordingFutureStore struct {
+	*MemoryFutureStore
+	saved [][]byte
+	err   error
+}
+
+func (s *recordingFutureStore) Save(ctx context.Context, key string, state []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.saved = append(s.saved, state)
+	return s.MemoryFutureStore.Save(ctx, key, state)
+}
+
+func TestFuture_WaitForCompletionWithCheckpoint(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendAndRepeatResponse(newOperationResourceResponse("busy"), 2)
+	sender.AppendResponse(newOperationResourceResponse(operationSucceeded))
+	client := autorest.Client{
+		PollingDelay:    1 * time.Second,
+		PollingDuration: autorest.DefaultPollingDuration,
+		RetryAttempts:   autorest.DefaultRetryAttempts,
+		RetryDuration:   1 * time.Second,
+		Sender:          sender,
+	}
+	future, err := NewFutureFromResponse(newSimpleAsyncResp())
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	store := &recordingFutureStore{MemoryFutureStore: NewMemoryFutureStore()}
+	if err = future.WaitForCompletionWithCheckpoint(context.Background(), client, store, futureKey); err != nil {
+		t.Fatalf("WaitForCompletionWithCheckpoint failed: %v", err)
+	}
+	// the initial state and the state after each of the three polls
+	if len(store.saved) != 4 {
+		t.Fatalf("expected 4 checkpoints, got %d", len(store.saved))
+	}
+	if !bytes.Contains(store.saved[1], []byte(`"lroState":"busy"`)) {
+		t.Fatalf("unexpected checkpoint %s", store.saved[1])
+	}
+	var resumed Future
+	if err = LoadFuture(context.Background(), store, futureKey, &resumed); err != nil {
+		t.Fatalf("failed to load future: %v", err)
+	}
+	if resumed.Status() != operationSucceeded {
+		t.Fatalf("expected status %s, got %s", operationSucceeded, resumed.Status())
+	}
+}
+
+func TestFuture_ResumeFromCheckpoint(t *testing.T) {
+	future, err := NewFutureFromResponse(newSimpleAsyncResp())
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	store := NewMemoryFutureStore()
+	if err = SaveFuture(context.Background(), store, futureKey, &future); err != nil {
+		t.Fatalf("failed to save future: %v", err)
+	}
+
+	// simulate another process resuming the LRO
+	sender := mocks.NewSender()
+	sender.AppendResponse(newOperationResourceResponse(operationSucceeded))
+	client := autorest.Client{
+		PollingDelay:  1 * time.Second,
+		RetryAttempts: autorest.DefaultRetryAttempts,
+		RetryDuration: 1 * time.Second,
+		Sender:        sender,
+	}
+	var resumed Future
+	if err = LoadFuture(context.Background(), store, futureKey, &resumed); err != nil {
+		t.Fatalf("failed to load future: %v", err)
+	}
+	if err = resumed.WaitForCompletionWithCheckpoint(context.Background(), client, store, futureKey); err != nil {
+		t.Fatalf("WaitForCompletionWithCheckpoint failed: %v", err)
+	}
+	if sender.Attempts() != 1 {
+		t.Fatalf("expected 1 poll, got %d", sender.Attempts())
+	}
+	if resumed.Status() != operationSucceeded {
+		t.Fatalf("expected status %s, got %s", operationSucceeded, resumed.Status())
+	}
+}
+
+func TestFuture_WaitForCompletionWithCheckpointSaveFails(t *testing.T) {
+	future, err := NewFutureFromResponse(newSimpleAsyncResp())
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	sender := mocks.NewSender()
+	client := autorest.Client{Sender: sender}
+	store := &recordingFutureStore{MemoryFutureStore: NewMemoryFutureStore(), err: errors.New("disk full")}
+	if err = future.WaitForCompletionWithCheckpoint(context.Background(), client, store, futureKey); err == nil {
+		t.Fatal("expected an error")
+	}
+	if sender.Attempts() != 0 {
+		t.Fatalf("expected no polling, got %d attempts", sender.Attempts())
+	}
+}
+
+func TestLoadFutureNotFound(t *testing.T) {
+	var future Future
+	err := LoadFuture(context.Background(), NewMemoryFutureStore(), futureKey, &future)
+	if _, ok := err.(FutureNotFoundError); !ok {
+		t.Fatalf("expected FutureNotFoundError, got %v", err)
+	}
+}
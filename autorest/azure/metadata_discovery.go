@@ -0,0 +1,361 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/logger"
+)
+
+const (
+	// MetadataAPIVersion is the default api-version used to discover the endpoints of a cloud.
+	// Its response is a list of clouds, each with the suffixes of its services.
+	MetadataAPIVersion = "2022-09-01"
+
+	// MetadataAPIVersionLegacy is the api-version used by EnvironmentFromURL.
+	MetadataAPIVersionLegacy = "1.0"
+
+	// DefaultMetadataTimeout is the default timeout for the discovery of a cloud's endpoints.
+	DefaultMetadataTimeout = 30 * time.Second
+
+	// DefaultMetadataCacheTTL is the default duration a cached metadata response is used before
+	// it's refreshed.
+	DefaultMetadataCacheTTL = 24 * time.Hour
+)
+
+// ErrCloudNotFound is returned by EnvironmentFromURLWithContext when the metadata doesn't list the
+// cloud selected by MetadataOptions.CloudName, or the one of the resource manager endpoint.
+var ErrCloudNotFound = errors.New("cloud not found in the metadata")
+
+// MetadataOptions controls the discovery of a cloud's endpoints by EnvironmentFromURLWithContext.
+type MetadataOptions struct {
+	// APIVersion is the api-version of the metadata endpoint, MetadataAPIVersion if empty.
+	APIVersion string
+
+	// CloudName selects the cloud when the metadata lists more than one.  If empty the cloud
+	// whose resource manager endpoint is the one that was queried is used.
+	CloudName string
+
+	// Timeout is applied when the context has no deadline, DefaultMetadataTimeout if zero.
+	Timeout time.Duration
+
+	// RetryAttempts is the number of times a failed request is retried.
+	RetryAttempts int
+
+	// RetryDuration is the delay between retries.
+	RetryDuration time.Duration
+
+	// CacheDir, if not empty, is the directory where metadata responses are cached.  When the
+	// metadata endpoint can't be reached the last response in the cache is used, however old.
+	CacheDir string
+
+	// CacheTTL is the duration a cached response is used without querying the metadata
+	// endpoint, DefaultMetadataCacheTTL if zero.
+	CacheTTL time.Duration
+}
+
+// the suffixes of a cloud's services in the 2022-09-01 metadata
+type cloudMetadataSuffixes struct {
+	AzureDataLakeStoreFileSystem string `json:"azureDataLakeStoreFileSystem"`
+	AcrLoginServer               string `json:"acrLoginServer"`
+	SQLServerHostname            string `json:"sqlServerHostname"`
+	KeyVaultDNS                  string `json:"keyVaultDns"`
+	Storage                      string `json:"storage"`
+	MhsmDNS                      string `json:"mhsmDns"`
+	MySQLServerEndpoint          string `json:"mysqlServerEndpoint"`
+	PostgresqlServerEndpoint     string `json:"postgresqlServerEndpoint"`
+	MariadbServerEndpoint        string `json:"mariadbServerEndpoint"`
+	SynapseAnalytics             string `json:"synapseAnalytics"`
+}
+
+// a cloud in the 2022-09-01 metadata
+type cloudMetadata struct {
+	Name                       string                `json:"name"`
+	Portal                     string                `json:"portal"`
+	Authentication             authentication        `json:"authentication"`
+	Gallery                    string                `json:"gallery"`
+	Graph                      string                `json:"graph"`
+	GraphAudience              string                `json:"graphAudience"`
+	Batch                      string                `json:"batch"`
+	ResourceManager            string                `json:"resourceManager"`
+	ActiveDirectoryDataLake    string                `json:"activeDirectoryDataLake"`
+	MicrosoftGraphResourceID   string                `json:"microsoftGraphResourceId"`
+	SynapseAnalyticsResourceID string                `json:"synapseAnalyticsResourceId"`
+	LogAnalyticsResourceID     string                `json:"logAnalyticsResourceId"`
+	OSSRDBMSResourceID         string                `json:"ossrDbmsResourceId"`
+	Suffixes                   cloudMetadataSuffixes `json:"suffixes"`
+}
+
+// EnvironmentFromURLWithContext loads an Environment from the metadata endpoint of the specified
+// resource manager, like EnvironmentFromURL, sending the requests with the specified sender.
+// If sender is nil a default sender is used.  Properties take priority over the discovered values.
+// Both the 2022-09-01 metadata, a list of clouds with their suffixes, and the older 1.0 metadata
+// are supported.  See MetadataOptions for retries, timeouts and caching; options can be nil.
+func EnvironmentFromURLWithContext(ctx context.Context, sender autorest.Sender, resourceManagerEndpoint string, options *MetadataOptions, properties ...OverrideProperty) (Environment, error) {
+	if resourceManagerEndpoint == "" {
+		return Environment{}, errors.New("Metadata resource manager endpoint is empty")
+	}
+	opts := MetadataOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.APIVersion == "" {
+		opts.APIVersion = MetadataAPIVersion
+	}
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = DefaultMetadataCacheTTL
+	}
+	cacheFile := ""
+	if opts.CacheDir != "" {
+		sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSuffix(resourceManagerEndpoint, "/")) + "?api-version=" + opts.APIVersion))
+		cacheFile = filepath.Join(opts.CacheDir, "metadata-"+hex.EncodeToString(sum[:])+".json")
+	}
+
+	var body []byte
+	var cacheTime time.Time
+	if cacheFile != "" {
+		if fi, err := os.Stat(cacheFile); err == nil {
+			cacheTime = fi.ModTime()
+			if time.Since(cacheTime) < opts.CacheTTL {
+				if b, err := os.ReadFile(cacheFile); err == nil {
+					body = b
+				}
+			}
+		}
+	}
+	if body == nil {
+		b, err := fetchMetadata(ctx, sender, resourceManagerEndpoint, opts)
+		if err == nil {
+			// make sure the response can be used before caching it
+			_, err = environmentFromMetadata(resourceManagerEndpoint, b, opts.CloudName, properties)
+			if errors.Is(err, ErrCloudNotFound) {
+				// the current metadata doesn't list the cloud, an older copy mustn't be used instead
+				return Environment{}, err
+			}
+		}
+		switch {
+		case err == nil:
+			body = b
+			if cacheFile != "" {
+				if cerr := writeMetadataCache(cacheFile, b); cerr != nil {
+					logger.Instance.Writef(logger.LogWarning, "failed to cache the metadata of %s: %v\n", resourceManagerEndpoint, cerr)
+				}
+			}
+		case !cacheTime.IsZero():
+			// fall back to the last known good metadata
+			logger.Instance.Writef(logger.LogWarning, "the metadata of %s can't be used, using the copy cached at %s: %v\n",
+				resourceManagerEndpoint, cacheTime.Format(time.RFC3339), err)
+			if body, err = os.ReadFile(cacheFile); err != nil {
+				return Environment{}, err
+			}
+		default:
+			return Environment{}, err
+		}
+	}
+	return environmentFromMetadata(resourceManagerEndpoint, body, opts.CloudName, properties)
+}
+
+// retrieves the metadata of the resource manager
+func fetchMetadata(ctx context.Context, sender autorest.Sender, resourceManagerEndpoint string, opts MetadataOptions) ([]byte, error) {
+	if sender == nil {
+		sender = autorest.CreateSender()
+	}
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		timeout := opts.Timeout
+		if timeout == 0 {
+			timeout = DefaultMetadataTimeout
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := autorest.Prepare((&http.Request{}).WithContext(ctx),
+		autorest.AsGet(),
+		autorest.WithBaseURL(resourceManagerEndpoint),
+		autorest.WithPath("metadata/endpoints"),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": opts.APIVersion}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := autorest.SendWithSender(sender, req,
+		autorest.DoRetryForStatusCodes(opts.RetryAttempts, opts.RetryDuration, autorest.StatusCodesForRetry...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the metadata of %s: %w", resourceManagerEndpoint, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to retrieve the metadata of %s: unexpected status %s", resourceManagerEndpoint, resp.Status)
+	}
+	return body, nil
+}
+
+// atomically replaces the cached metadata
+func writeMetadataCache(path string, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "metadata")
+	if err != nil {
+		return err
+	}
+	tempPath := f.Name()
+	_, err = f.Write(body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return err
+}
+
+// creates an Environment from either shape of the metadata
+func environmentFromMetadata(resourceManagerEndpoint string, body []byte, cloudName string, properties []OverrideProperty) (Environment, error) {
+	body = bytes.TrimSpace(body)
+	var clouds []cloudMetadata
+	if len(body) > 0 && body[0] == '[' {
+		if err := json.Unmarshal(body, &clouds); err != nil {
+			return Environment{}, fmt.Errorf("failed to parse the metadata of %s: %w", resourceManagerEndpoint, err)
+		}
+	} else {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return Environment{}, fmt.Errorf("failed to parse the metadata of %s: %w", resourceManagerEndpoint, err)
+		}
+		if _, ok := fields["resourceManager"]; !ok {
+			// 1.0 metadata
+			var info environmentMetadataInfo
+			if err := json.Unmarshal(body, &info); err != nil {
+				return Environment{}, fmt.Errorf("failed to parse the metadata of %s: %w", resourceManagerEndpoint, err)
+			}
+			return environmentFromMetadataInfo(resourceManagerEndpoint, info, properties), nil
+		}
+		var cloud cloudMetadata
+		if err := json.Unmarshal(body, &cloud); err != nil {
+			return Environment{}, fmt.Errorf("failed to parse the metadata of %s: %w", resourceManagerEndpoint, err)
+		}
+		clouds = append(clouds, cloud)
+	}
+	cloud, err := selectCloud(clouds, resourceManagerEndpoint, cloudName)
+	if err != nil {
+		return Environment{}, err
+	}
+	return environmentFromCloudMetadata(cloud, properties), nil
+}
+
+// selects the cloud by name or resource manager endpoint
+func selectCloud(clouds []cloudMetadata, resourceManagerEndpoint, cloudName string) (cloudMetadata, error) {
+	normalize := func(u string) string {
+		return strings.ToLower(strings.TrimSuffix(u, "/"))
+	}
+	for _, c := range clouds {
+		if cloudName != "" && strings.EqualFold(c.Name, cloudName) {
+			return c, nil
+		}
+		if cloudName == "" && normalize(c.ResourceManager) == normalize(resourceManagerEndpoint) {
+			return c, nil
+		}
+	}
+	if cloudName == "" && len(clouds) == 1 {
+		return clouds[0], nil
+	}
+	if cloudName != "" {
+		return cloudMetadata{}, fmt.Errorf("the metadata of %s has no cloud named %q: %w", resourceManagerEndpoint, cloudName, ErrCloudNotFound)
+	}
+	return cloudMetadata{}, fmt.Errorf("the metadata of %s has no cloud with that resource manager endpoint: %w", resourceManagerEndpoint, ErrCloudNotFound)
+}
+
+// creates an Environment from a cloud in the 2022-09-01 metadata
+func environmentFromCloudMetadata(cloud cloudMetadata, properties []OverrideProperty) Environment {
+	suffix := func(s string) string {
+		return strings.TrimPrefix(s, ".")
+	}
+	endpoint := func(dnsSuffix string) string {
+		if dnsSuffix == "" {
+			return ""
+		}
+		return fmt.Sprintf("https://%s/", dnsSuffix)
+	}
+	sfx := cloud.Suffixes
+	environment := Environment{
+		Name:                        cloud.Name,
+		ManagementPortalURL:         cloud.Portal,
+		ResourceManagerEndpoint:     cloud.ResourceManager,
+		ActiveDirectoryEndpoint:     cloud.Authentication.LoginEndpoint,
+		GalleryEndpoint:             cloud.Gallery,
+		GraphEndpoint:               cloud.Graph,
+		BatchManagementEndpoint:     cloud.Batch,
+		MicrosoftGraphEndpoint:      cloud.MicrosoftGraphResourceID,
+		StorageEndpointSuffix:       suffix(sfx.Storage),
+		KeyVaultDNSSuffix:           suffix(sfx.KeyVaultDNS),
+		KeyVaultEndpoint:            endpoint(suffix(sfx.KeyVaultDNS)),
+		ManagedHSMDNSSuffix:         suffix(sfx.MhsmDNS),
+		ManagedHSMEndpoint:          endpoint(suffix(sfx.MhsmDNS)),
+		SQLDatabaseDNSSuffix:        suffix(sfx.SQLServerHostname),
+		MySQLDatabaseDNSSuffix:      suffix(sfx.MySQLServerEndpoint),
+		PostgresqlDatabaseDNSSuffix: suffix(sfx.PostgresqlServerEndpoint),
+		MariaDBDNSSuffix:            suffix(sfx.MariadbServerEndpoint),
+		ContainerRegistryDNSSuffix:  suffix(sfx.AcrLoginServer),
+		SynapseEndpointSuffix:       suffix(sfx.SynapseAnalytics),
+		DatalakeSuffix:              suffix(sfx.AzureDataLakeStoreFileSystem),
+		ResourceIdentifiers: ResourceIdentifier{
+			Graph:               cloud.GraphAudience,
+			Datalake:            cloud.ActiveDirectoryDataLake,
+			Batch:               cloud.Batch,
+			OperationalInsights: cloud.LogAnalyticsResourceID,
+			OSSRDBMS:            cloud.OSSRDBMSResourceID,
+			Synapse:             cloud.SynapseAnalyticsResourceID,
+			MicrosoftGraph:      cloud.MicrosoftGraphResourceID,
+		},
+	}
+	if len(cloud.Authentication.Audiences) > 0 {
+		environment.TokenAudience = cloud.Authentication.Audiences[0]
+	}
+	if sfx.KeyVaultDNS != "" {
+		environment.ResourceIdentifiers.KeyVault = "https://" + suffix(sfx.KeyVaultDNS)
+	}
+	if sfx.MhsmDNS != "" {
+		environment.ResourceIdentifiers.ManagedHSM = "https://" + suffix(sfx.MhsmDNS)
+	}
+	if environment.Name == "" {
+		environment.Name = "HybridEnvironment"
+	}
+	// Give priority to user's override values
+	overrideProperties(&environment, properties)
+	return environment
+}
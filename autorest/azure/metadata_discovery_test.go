@@ -0,0 +1,180 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testCloudMetadataFormat = `[{
+	"name": "AzureStackHub",
+	"portal": "https://portal.local.azurestack.external/",
+	"authentication": {
+		"loginEndpoint": "https://login.microsoftonline.com/",
+		"audiences": ["https://management.azurestack.onmicrosoft.com/1234"]
+	},
+	"graph": "https://graph.windows.net/",
+	"graphAudience": "https://graph.windows.net/",
+	"resourceManager": "%s",
+	"suffixes": {
+		"storage": "local.azurestack.external",
+		"keyVaultDns": ".vault.local.azurestack.external",
+		"acrLoginServer": "azurecr.local.azurestack.external"
+	}
+}, {
+	"name": "Other",
+	"resourceManager": "https://management.other.external/",
+	"authentication": {"loginEndpoint": "https://login.other.external/", "audiences": ["https://management.other.external/"]}
+}]`
+
+// serves the metadata, counting the requests.  if failing is set the requests fail.
+func newMetadataServer(t *testing.T, failing *int32) (*httptest.Server, *int32) {
+	var requests int32
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/metadata/endpoints" || r.URL.Query().Get("api-version") != MetadataAPIVersion {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if failing != nil && atomic.LoadInt32(failing) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(fmt.Sprintf(testCloudMetadataFormat, ts.URL+"/")))
+	}))
+	return ts, &requests
+}
+
+func TestEnvironmentFromURLWithContext(t *testing.T) {
+	ts, _ := newMetadataServer(t, nil)
+	defer ts.Close()
+	env, err := EnvironmentFromURLWithContext(context.Background(), nil, ts.URL, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env.Name != "AzureStackHub" || env.ResourceManagerEndpoint != ts.URL+"/" {
+		t.Fatalf("unexpected environment %v", env)
+	}
+	if env.TokenAudience != "https://management.azurestack.onmicrosoft.com/1234" ||
+		env.ActiveDirectoryEndpoint != "https://login.microsoftonline.com/" {
+		t.Fatalf("unexpected authentication %v", env)
+	}
+	if env.KeyVaultDNSSuffix != "vault.local.azurestack.external" ||
+		env.KeyVaultEndpoint != "https://vault.local.azurestack.external/" ||
+		env.ResourceIdentifiers.KeyVault != "https://vault.local.azurestack.external" ||
+		env.StorageEndpointSuffix != "local.azurestack.external" ||
+		env.ContainerRegistryDNSSuffix != "azurecr.local.azurestack.external" {
+		t.Fatalf("unexpected suffixes %v", env)
+	}
+
+	env, err = EnvironmentFromURLWithContext(context.Background(), nil, ts.URL, &MetadataOptions{CloudName: "other"},
+		OverrideProperty{Key: EnvironmentName, Value: "Overridden"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env.Name != "Overridden" || env.ResourceManagerEndpoint != "https://management.other.external/" {
+		t.Fatalf("unexpected environment %v", env)
+	}
+
+	if _, err = EnvironmentFromURLWithContext(context.Background(), nil, ts.URL, &MetadataOptions{CloudName: "missing"}); !errors.Is(err, ErrCloudNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestEnvironmentFromURLWithContext_Legacy(t *testing.T) {
+	fileContents, _ := os.ReadFile(filepath.Join("testdata", "test_metadata_environment_1.json"))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(fileContents)
+	}))
+	defer ts.Close()
+	env, err := EnvironmentFromURLWithContext(context.Background(), nil, ts.URL, &MetadataOptions{APIVersion: MetadataAPIVersionLegacy})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env.Name != "HybridEnvironment" || env.GalleryEndpoint != "https://portal.local.azurestack.external:30015/" {
+		t.Fatalf("unexpected environment %v", env)
+	}
+}
+
+func TestEnvironmentFromURLWithContext_Cache(t *testing.T) {
+	var failing int32
+	ts, requests := newMetadataServer(t, &failing)
+	defer ts.Close()
+	options := &MetadataOptions{CacheDir: t.TempDir()}
+	for i := 0; i < 2; i++ {
+		if _, err := EnvironmentFromURLWithContext(context.Background(), nil, ts.URL, options); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if *requests != 1 {
+		t.Fatalf("expected the cached metadata to be used, got %d requests", *requests)
+	}
+
+	// the cache has expired and the endpoint fails, the last known good metadata is used
+	options.CacheTTL = time.Nanosecond
+	atomic.StoreInt32(&failing, 1)
+	env, err := EnvironmentFromURLWithContext(context.Background(), nil, ts.URL, options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env.Name != "AzureStackHub" {
+		t.Fatalf("unexpected environment %v", env)
+	}
+	if *requests != 2 {
+		t.Fatalf("expected the metadata to be refreshed, got %d requests", *requests)
+	}
+
+	// the refreshed metadata doesn't list the cloud, the cached copy isn't used
+	atomic.StoreInt32(&failing, 0)
+	options.CloudName = "missing"
+	if _, err = EnvironmentFromURLWithContext(context.Background(), nil, ts.URL, options); !errors.Is(err, ErrCloudNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	atomic.StoreInt32(&failing, 1)
+
+	// without a cache the error is returned
+	if _, err = EnvironmentFromURLWithContext(context.Background(), nil, ts.URL, nil); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestEnvironmentFromURLWithContext_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+	start := time.Now()
+	_, err := EnvironmentFromURLWithContext(context.Background(), nil, ts.URL, &MetadataOptions{Timeout: 50 * time.Millisecond})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("the timeout wasn't applied")
+	}
+}
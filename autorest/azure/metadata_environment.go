@@ -103,7 +103,11 @@ func EnvironmentFromURL(resourceManagerEndpoint string, properties ...OverridePr
 	if metadataEnvProperties, err = retrieveMetadataEnvironment(resourceManagerEndpoint); err != nil {
 		return environment, err
 	}
+	return environmentFromMetadataInfo(resourceManagerEndpoint, metadataEnvProperties, properties), nil
+}
 
+// creates an Environment from the response of the 1.0 metadata endpoint
+func environmentFromMetadataInfo(resourceManagerEndpoint string, metadataEnvProperties environmentMetadataInfo, properties []OverrideProperty) (environment Environment) {
 	// Give priority to user's override values
 	overrideProperties(&environment, properties)
 
@@ -121,7 +125,7 @@ func EnvironmentFromURL(resourceManagerEndpoint string, properties ...OverridePr
 	if environment.KeyVaultEndpoint == "" {
 		environment.KeyVaultEndpoint = fmt.Sprintf("%s%s", "https://", environment.KeyVaultDNSSuffix)
 	}
-	if environment.TokenAudience == "" {
+	if environment.TokenAudience == "" && len(metadataEnvProperties.Authentication.Audiences) > 0 {
 		environment.TokenAudience = metadataEnvProperties.Authentication.Audiences[0]
 	}
 	if environment.ActiveDirectoryEndpoint == "" {
@@ -137,7 +141,7 @@ func EnvironmentFromURL(resourceManagerEndpoint string, properties ...OverridePr
 		environment.GraphEndpoint = metadataEnvProperties.GraphEndpoint
 	}
 
-	return environment, nil
+	return environment
 }
 
 func overrideProperties(environment *Environment, properties []OverrideProperty) {
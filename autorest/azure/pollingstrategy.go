@@ -0,0 +1,167 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// MinPollingStrategyDelay is the minimum delay of the polling strategies in this package, a smaller
+// delay would poll the service in a tight loop.
+const MinPollingStrategyDelay = 100 * time.Millisecond
+
+// PollingStrategy computes the delay between polls of a long-running operation when the
+// service doesn't return a Retry-After header.
+type PollingStrategy interface {
+	// NextDelay returns the delay before the next poll.  attempt is the number of
+	// successful polls made so far, starting at zero.
+	NextDelay(attempt int) time.Duration
+}
+
+// PollingStrategyFunc is a method that implements the PollingStrategy interface.
+type PollingStrategyFunc func(attempt int) time.Duration
+
+// NextDelay implements the PollingStrategy interface on PollingStrategyFunc.
+func (psf PollingStrategyFunc) NextDelay(attempt int) time.Duration {
+	return psf(attempt)
+}
+
+// ConstantPolling returns a PollingStrategy that always waits for the specified delay,
+// raised to MinPollingStrategyDelay if it's smaller.
+func ConstantPolling(delay time.Duration) PollingStrategy {
+	delay = minStrategyDelay(delay)
+	return PollingStrategyFunc(func(int) time.Duration {
+		return delay
+	})
+}
+
+// ExponentialPolling returns a PollingStrategy that starts with the initial delay and doubles it
+// after every poll.  To cap the maximum delay specify a value greater than zero for cap.
+// The initial delay is raised to MinPollingStrategyDelay if it's smaller.
+func ExponentialPolling(initial, cap time.Duration) PollingStrategy {
+	initial = minStrategyDelay(initial)
+	return PollingStrategyFunc(func(attempt int) time.Duration {
+		d := initial
+		for i := 0; i < attempt; i++ {
+			d *= 2
+			// stop before the delay can overflow
+			if (cap > 0 && d >= cap) || d >= 24*time.Hour {
+				break
+			}
+		}
+		return capDelay(d, cap)
+	})
+}
+
+// FibonacciPolling returns a PollingStrategy whose delays follow the Fibonacci sequence in multiples
+// of unit, i.e. unit, unit, 2*unit, 3*unit, 5*unit...  It backs off slower than ExponentialPolling.
+// To cap the maximum delay specify a value greater than zero for cap.
+// The unit is raised to MinPollingStrategyDelay if it's smaller.
+func FibonacciPolling(unit, cap time.Duration) PollingStrategy {
+	unit = minStrategyDelay(unit)
+	return PollingStrategyFunc(func(attempt int) time.Duration {
+		prev, d := time.Duration(0), unit
+		for i := 0; i < attempt; i++ {
+			prev, d = d, prev+d
+			// stop before the delay can overflow
+			if (cap > 0 && d >= cap) || d >= 24*time.Hour {
+				break
+			}
+		}
+		return capDelay(d, cap)
+	})
+}
+
+func minStrategyDelay(d time.Duration) time.Duration {
+	if d < MinPollingStrategyDelay {
+		return MinPollingStrategyDelay
+	}
+	return d
+}
+
+func capDelay(d, cap time.Duration) time.Duration {
+	if cap > 0 && d > cap {
+		return cap
+	}
+	return d
+}
+
+// PollingOptions controls the delay between polls of long-running operations.
+type PollingOptions struct {
+	// Strategy computes the delay when the service doesn't return a Retry-After header.
+	// If nil, the client's PollingDelay is used.
+	Strategy PollingStrategy
+
+	// MinDelay is the minimum delay between polls, applied to Retry-After values too.
+	MinDelay time.Duration
+
+	// MaxDelay, if greater than zero, is the maximum delay between polls, applied to Retry-After values too.
+	MaxDelay time.Duration
+}
+
+// used as a key type in context.WithValue()
+type ctxPollingOptions struct{}
+
+// WithPollingOptions adds the specified PollingOptions to the provided context.
+// The options are used by the methods waiting for the completion of futures, including
+// WaitForCompletionRef and FutureGroup.Wait.
+func WithPollingOptions(ctx context.Context, options PollingOptions) context.Context {
+	return context.WithValue(ctx, ctxPollingOptions{}, options)
+}
+
+// GetPollingOptions returns the PollingOptions in the provided context, or the default options.
+func GetPollingOptions(ctx context.Context) PollingOptions {
+	if po, ok := ctx.Value(ctxPollingOptions{}).(PollingOptions); ok {
+		return po
+	}
+	return PollingOptions{}
+}
+
+// returns the delay before the next poll of the future.  the Retry-After returned by the
+// service takes precedence over the strategy, both are clamped to the minimum and maximum.
+func (po PollingOptions) nextDelay(future FutureAPI, client autorest.Client, attempt int) time.Duration {
+	delay, ok := future.GetPollingDelay()
+	return po.delay(delay, ok, client, attempt)
+}
+
+// returns the delay before the next poll given the Retry-After, if any
+func (po PollingOptions) delay(retryAfter time.Duration, hasRetryAfter bool, client autorest.Client, attempt int) time.Duration {
+	delay := retryAfter
+	if !hasRetryAfter {
+		strategy := po.Strategy
+		if strategy == nil {
+			// the client's PollingDelay isn't raised to MinPollingStrategyDelay
+			strategy = PollingStrategyFunc(func(int) time.Duration {
+				return client.PollingDelay
+			})
+		}
+		delay = strategy.NextDelay(attempt)
+	}
+	return po.clamp(delay)
+}
+
+// clamps the delay to the minimum and maximum delay
+func (po PollingOptions) clamp(delay time.Duration) time.Duration {
+	if delay < po.MinDelay {
+		delay = po.MinDelay
+	}
+	if po.MaxDelay > 0 && delay > po.MaxDelay {
+		delay = po.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,176 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+func testPollingStrategy(t *testing.T, ps PollingStrategy, expected ...time.Duration) {
+	for i, e := range expected {
+		if d := ps.NextDelay(i); d != e {
+			t.Fatalf("attempt %d: expected %v, got %v", i, e, d)
+		}
+	}
+}
+
+func TestConstantPolling(t *testing.T) {
+	testPollingStrategy(t, ConstantPolling(time.Second), time.Second, time.Second, time.Second)
+}
+
+func TestPollingStrategyMinDelay(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second, time.Millisecond} {
+		testPollingStrategy(t, ConstantPolling(d), MinPollingStrategyDelay, MinPollingStrategyDelay)
+		testPollingStrategy(t, ExponentialPolling(d, 0), MinPollingStrategyDelay, 2*MinPollingStrategyDelay, 4*MinPollingStrategyDelay)
+		testPollingStrategy(t, FibonacciPolling(d, 0), MinPollingStrategyDelay, MinPollingStrategyDelay, 2*MinPollingStrategyDelay)
+	}
+}
+
+func TestExponentialPolling(t *testing.T) {
+	testPollingStrategy(t, ExponentialPolling(time.Second, 10*time.Second),
+		time.Second, 2*time.Second, 4*time.Second, 8*time.Second, 10*time.Second, 10*time.Second)
+	// no overflow without a cap
+	if d := ExponentialPolling(time.Second, 0).NextDelay(1000); d <= 0 {
+		t.Fatalf("unexpected delay %v", d)
+	}
+}
+
+func TestFibonacciPolling(t *testing.T) {
+	testPollingStrategy(t, FibonacciPolling(time.Second, 6*time.Second),
+		time.Second, time.Second, 2*time.Second, 3*time.Second, 5*time.Second, 6*time.Second, 6*time.Second)
+	if d := FibonacciPolling(time.Second, 0).NextDelay(1000); d <= 0 {
+		t.Fatalf("unexpected delay %v", d)
+	}
+}
+
+func TestPollingOptionsNextDelay(t *testing.T) {
+	client := autorest.Client{PollingDelay: 3 * time.Second}
+	withRetryAfter := func(ra string) *Future {
+		resp := newSimpleAsyncResp()
+		if ra != "" {
+			mocks.SetResponseHeader(resp, autorest.HeaderRetryAfter, ra)
+		}
+		f, err := NewFutureFromResponse(resp)
+		if err != nil {
+			t.Fatalf("failed to create future: %v", err)
+		}
+		return &f
+	}
+	testCases := []struct {
+		options    PollingOptions
+		retryAfter string
+		expected   time.Duration
+	}{
+		{PollingOptions{}, "", 3 * time.Second},
+		{PollingOptions{}, "5", 5 * time.Second},
+		{PollingOptions{Strategy: ExponentialPolling(time.Second, 0)}, "", 4 * time.Second},
+		{PollingOptions{MinDelay: 10 * time.Second}, "", 10 * time.Second},
+		{PollingOptions{MinDelay: 10 * time.Second}, "5", 10 * time.Second},
+		{PollingOptions{MaxDelay: 2 * time.Second}, "120", 2 * time.Second},
+		// an invalid Retry-After falls back to the strategy
+		{PollingOptions{Strategy: ConstantPolling(time.Second)}, "soon", time.Second},
+	}
+	for _, tc := range testCases {
+		if d := tc.options.nextDelay(withRetryAfter(tc.retryAfter), client, 2); d != tc.expected {
+			t.Fatalf("Retry-After %q: expected %v, got %v", tc.retryAfter, tc.expected, d)
+		}
+	}
+}
+
+func TestGetPollingDelayInvalidRetryAfter(t *testing.T) {
+	resp := newSimpleAsyncResp()
+	mocks.SetResponseHeader(resp, autorest.HeaderRetryAfter, "not-a-number")
+	future, err := NewFutureFromResponse(resp)
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	if d, ok := future.GetPollingDelay(); ok || d != 0 {
+		t.Fatalf("expected no delay, got %v, %v", d, ok)
+	}
+}
+
+func TestGetPollingDelayHTTPDate(t *testing.T) {
+	resp := newSimpleAsyncResp()
+	mocks.SetResponseHeader(resp, autorest.HeaderRetryAfter, time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	future, err := NewFutureFromResponse(resp)
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	d, ok := future.GetPollingDelay()
+	if !ok || d <= 50*time.Second || d > time.Minute {
+		t.Fatalf("unexpected delay %v, %v", d, ok)
+	}
+}
+
+func TestFuture_WaitForCompletionWithPollingOptions(t *testing.T) {
+	// polling responses without Retry-After
+	status := func(s string) *http.Response {
+		resp := newOperationResourceResponse(s)
+		resp.Header.Del(autorest.HeaderRetryAfter)
+		return resp
+	}
+	sender := mocks.NewSender()
+	sender.AppendAndRepeatResponse(status("busy"), 3)
+	sender.AppendResponse(status(operationSucceeded))
+	client := autorest.Client{
+		// would make the test time out if the strategy wasn't used
+		PollingDelay:  time.Hour,
+		RetryAttempts: autorest.DefaultRetryAttempts,
+		Sender:        sender,
+	}
+	future, err := NewFutureFromResponse(newSimpleAsyncResp())
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	var attempts []int
+	ctx := WithPollingOptions(context.Background(), PollingOptions{
+		Strategy: PollingStrategyFunc(func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return time.Millisecond
+		}),
+	})
+	if err = future.WaitForCompletionRef(ctx, client); err != nil {
+		t.Fatalf("WaitForCompletionRef failed: %v", err)
+	}
+	if len(attempts) != 3 || attempts[0] != 0 || attempts[2] != 2 {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+}
+
+func TestFuture_WaitForCompletionInvalidRetryAfter(t *testing.T) {
+	busy := newOperationResourceResponse("busy")
+	mocks.SetResponseHeader(busy, autorest.HeaderRetryAfter, "garbage")
+	sender := mocks.NewSender()
+	sender.AppendResponse(busy)
+	sender.AppendResponse(newOperationResourceResponse(operationSucceeded))
+	client := autorest.Client{
+		PollingDelay:  time.Millisecond,
+		RetryAttempts: autorest.DefaultRetryAttempts,
+		Sender:        sender,
+	}
+	future, err := NewFutureFromResponse(newSimpleAsyncResp())
+	if err != nil {
+		t.Fatalf("failed to create future: %v", err)
+	}
+	if err = future.WaitForCompletionRef(context.Background(), client); err != nil {
+		t.Fatalf("WaitForCompletionRef failed: %v", err)
+	}
+}
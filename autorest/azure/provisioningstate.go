@@ -0,0 +1,127 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/logger"
+	"github.com/Azure/go-autorest/tracing"
+)
+
+// ProvisioningStateOptions controls WaitForProvisioningState.
+type ProvisioningStateOptions struct {
+	// TerminalStates are the provisioning states that end the wait, compared case-insensitively.
+	// If empty, Succeeded, Failed and Canceled are used.
+	TerminalStates []string
+
+	// FailedStates are the terminal states reported as an error, compared case-insensitively.
+	// If nil, Failed and Canceled are used.
+	FailedStates []string
+}
+
+// WaitForProvisioningState polls the resource with the specified ID until its provisioningState
+// reaches a terminal state, for resources that are updated asynchronously without returning a
+// long-running operation.  baseURI is the Resource Manager endpoint, e.g. https://management.azure.com.
+// A resource without a provisioningState is considered Succeeded.  The last response is returned,
+// its body is available to the caller.  If the resource reaches a failed state a *ServiceError,
+// whose code is the provisioning state, is returned along with the response.
+// Deadlines, retries and delays between polls are handled like WaitForCompletionRef; the
+// Retry-After returned by the service takes precedence over the client's PollingDelay.
+func WaitForProvisioningState(ctx context.Context, client autorest.Client, baseURI, resourceID, apiVersion string, options *ProvisioningStateOptions) (resp *http.Response, err error) {
+	ctx = tracing.StartSpan(ctx, "github.com/Azure/go-autorest/autorest/azure.WaitForProvisioningState")
+	defer func() {
+		sc := -1
+		if resp != nil {
+			sc = resp.StatusCode
+		}
+		tracing.EndSpan(ctx, sc, err)
+	}()
+	terminal := []string{operationSucceeded, operationFailed, operationCanceled}
+	failed := []string{operationFailed, operationCanceled}
+	if options != nil {
+		if len(options.TerminalStates) > 0 {
+			terminal = options.TerminalStates
+		}
+		if options.FailedStates != nil {
+			failed = options.FailedStates
+		}
+	}
+	u, err := url.Parse(strings.TrimSuffix(baseURI, "/") + "/" + strings.TrimPrefix(resourceID, "/"))
+	if err != nil || !u.IsAbs() {
+		return nil, autorest.NewErrorWithError(err, "azure", "WaitForProvisioningState", nil, "invalid resource URL '%s%s'", baseURI, resourceID)
+	}
+	q := u.Query()
+	q.Set("api-version", apiVersion)
+	u.RawQuery = q.Encode()
+
+	// if the provided context already has a deadline don't override it
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline && client.PollingDuration != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.PollingDuration)
+		defer cancel()
+	}
+	pollingOptions := GetPollingOptions(ctx)
+	pt := &pollingTrackerBase{URI: u.String(), Pm: PollingRequestURI}
+	for attempts, polls := 0, 0; ; {
+		err = pt.pollForStatus(ctx, client)
+		resp = pt.resp
+		if err == nil {
+			state := operationSucceeded
+			if ps := pt.getProvisioningState(); ps != nil {
+				state = *ps
+			}
+			pt.State = state
+			logger.Instance.Writef(logger.LogInfo, "WaitForProvisioningState: %s is %s%s\n", resourceID, state, correlationLog(operationCorrelationID(ctx, resp)))
+			if containsState(terminal, state) {
+				if containsState(failed, state) {
+					// no error in the resource body so this reports the state and the resource
+					pt.updateErrorFromResponse()
+					return resp, pt.Err
+				}
+				return resp, nil
+			}
+		}
+		if err != nil && attempts >= client.RetryAttempts {
+			return resp, autorest.NewErrorWithError(err, "azure", "WaitForProvisioningState", resp, "the number of retries has been exceeded")
+		}
+		var delayElapsed bool
+		if err == nil {
+			delay, ok := getRetryAfter(resp)
+			delayElapsed = delayForPolling(pollingOptions.delay(delay, ok, client, polls), ctx.Done())
+			polls++
+		} else {
+			logger.Instance.Writef(logger.LogError, "WaitForProvisioningState: %s%s\n", err, correlationLog(operationCorrelationID(ctx, resp)))
+			delayElapsed = autorest.DelayForBackoff(client.RetryDuration, attempts, ctx.Done())
+			attempts++
+		}
+		if !delayElapsed {
+			return resp, autorest.NewErrorWithError(ctx.Err(), "azure", "WaitForProvisioningState", resp, "context has been cancelled")
+		}
+	}
+}
+
+func containsState(states []string, state string) bool {
+	for _, s := range states {
+		if strings.EqualFold(s, state) {
+			return true
+		}
+	}
+	return false
+}
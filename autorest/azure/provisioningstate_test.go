@@ -0,0 +1,127 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/mocks"
+)
+
+const testResourceID = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet"
+
+func newProvisioningStateResponse(state string) *http.Response {
+	resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody(fmt.Sprintf(`{"id": "%s", "properties": {"provisioningState": "%s"}}`, testResourceID, state)), http.StatusOK, "200 OK")
+	// would make the test time out if Retry-After wasn't honored
+	mocks.SetResponseHeader(resp, autorest.HeaderRetryAfter, "0")
+	return resp
+}
+
+func newProvisioningStateClient(sender autorest.Sender) autorest.Client {
+	return autorest.Client{
+		PollingDelay:    time.Hour,
+		PollingDuration: autorest.DefaultPollingDuration,
+		RetryAttempts:   autorest.DefaultRetryAttempts,
+		RetryDuration:   time.Millisecond,
+		Sender:          sender,
+	}
+}
+
+func TestWaitForProvisioningState(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendResponse(newProvisioningStateResponse("Updating"))
+	// a transient error is retried
+	sender.AppendResponse(mocks.NewResponseWithStatus("500 Internal Server Error", http.StatusInternalServerError))
+	sender.AppendResponse(newProvisioningStateResponse("Updating"))
+	sender.AppendResponse(newProvisioningStateResponse("Succeeded"))
+	client := newProvisioningStateClient(sender)
+	resp, err := WaitForProvisioningState(context.Background(), client, "https://management.azure.com/", testResourceID, "2020-01-01", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sender.Attempts() != 4 {
+		t.Fatalf("expected 4 polls, got %d", sender.Attempts())
+	}
+	u := resp.Request.URL
+	if u.Path != testResourceID || u.Query().Get("api-version") != "2020-01-01" || u.Host != "management.azure.com" {
+		t.Fatalf("unexpected URL %s", u)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(b), `"provisioningState": "Succeeded"`) {
+		t.Fatalf("unexpected body %s", b)
+	}
+}
+
+func TestWaitForProvisioningState_NoProvisioningState(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendResponse(mocks.NewResponseWithBodyAndStatus(mocks.NewBody(`{"id": "id"}`), http.StatusOK, "200 OK"))
+	if _, err := WaitForProvisioningState(context.Background(), newProvisioningStateClient(sender), "https://management.azure.com", testResourceID, "2020-01-01", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWaitForProvisioningState_Failed(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendResponse(newProvisioningStateResponse("Updating"))
+	sender.AppendResponse(newProvisioningStateResponse("Failed"))
+	_, err := WaitForProvisioningState(context.Background(), newProvisioningStateClient(sender), "https://management.azure.com", testResourceID, "2020-01-01", nil)
+	se, ok := err.(*ServiceError)
+	if !ok {
+		t.Fatalf("expected a *ServiceError, got %T", err)
+	}
+	if se.Code != "Failed" {
+		t.Fatalf("unexpected error %v", se)
+	}
+}
+
+func TestWaitForProvisioningState_CustomStates(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendResponse(newProvisioningStateResponse("Updating"))
+	sender.AppendResponse(newProvisioningStateResponse("Succeeded"))
+	sender.AppendResponse(newProvisioningStateResponse("Ready"))
+	options := &ProvisioningStateOptions{TerminalStates: []string{"ready", "Broken"}, FailedStates: []string{"Broken"}}
+	if _, err := WaitForProvisioningState(context.Background(), newProvisioningStateClient(sender), "https://management.azure.com", testResourceID, "2020-01-01", options); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sender.Attempts() != 3 {
+		t.Fatalf("expected 3 polls, got %d", sender.Attempts())
+	}
+
+	sender = mocks.NewSender()
+	sender.AppendResponse(newProvisioningStateResponse("Broken"))
+	if _, err := WaitForProvisioningState(context.Background(), newProvisioningStateClient(sender), "https://management.azure.com", testResourceID, "2020-01-01", options); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestWaitForProvisioningState_Cancelled(t *testing.T) {
+	sender := mocks.NewSender()
+	sender.AppendAndRepeatResponse(newProvisioningStateResponse("Updating"), 100)
+	client := newProvisioningStateClient(sender)
+	client.PollingDuration = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ctx = WithPollingOptions(ctx, PollingOptions{MinDelay: 10 * time.Millisecond})
+	if _, err := WaitForProvisioningState(ctx, client, "https://management.azure.com", testResourceID, "2020-01-01", nil); err == nil {
+		t.Fatal("expected an error")
+	}
+}
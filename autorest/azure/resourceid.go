@@ -0,0 +1,285 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	resourcesNamespace   = "Microsoft.Resources"
+	managementNamespace  = "Microsoft.Management"
+	tenantsType          = "tenants"
+	subscriptionsKey     = "subscriptions"
+	resourceGroupsKey    = "resourceGroups"
+	providersKey         = "providers"
+	managementGroupsType = "managementGroups"
+	resourceIDSeparator  = "/"
+)
+
+// ResourceType is the type of an Azure resource, e.g. Microsoft.Network/virtualNetworks/subnets.
+type ResourceType struct {
+	// Namespace is the resource provider namespace, e.g. Microsoft.Network.
+	Namespace string
+
+	// Types contains the type of the resource and of each of its parents within the namespace,
+	// e.g. virtualNetworks and subnets.
+	Types []string
+}
+
+// String returns the resource type in the form namespace/type[/type...].
+func (rt ResourceType) String() string {
+	return strings.Join(append([]string{rt.Namespace}, rt.Types...), "/")
+}
+
+// LastType returns the type of the resource without its parents' types, e.g. subnets.
+func (rt ResourceType) LastType() string {
+	if len(rt.Types) == 0 {
+		return ""
+	}
+	return rt.Types[len(rt.Types)-1]
+}
+
+// returns a copy of the resource type with the specified child type appended
+func (rt ResourceType) child(childType string) ResourceType {
+	types := make([]string, len(rt.Types), len(rt.Types)+1)
+	copy(types, rt.Types)
+	return ResourceType{Namespace: rt.Namespace, Types: append(types, childType)}
+}
+
+// ResourceID is a parsed Azure resource ID that retains the whole chain of resources it's made of.
+// Each ResourceID links to its parent, up to the tenant, so tenant, management group, subscription and
+// resource group scopes are represented along with child and extension resources, e.g. a role assignment
+// on top of a virtual machine.
+//
+// ResourceID values must not be modified, use the builder methods to create new IDs.
+type ResourceID struct {
+	// Parent is the ID of the parent resource, or nil for the tenant.
+	Parent *ResourceID
+
+	// SubscriptionID is the ID of the subscription containing the resource, if any.
+	SubscriptionID string
+
+	// ResourceGroupName is the name of the resource group containing the resource, if any.
+	ResourceGroupName string
+
+	// ResourceType is the type of the resource.
+	ResourceType ResourceType
+
+	// Name is the name of the resource.
+	Name string
+
+	// isChild is true when the ID's last segments are type/name under the parent's provider
+	// instead of providers/namespace/type/name
+	isChild bool
+}
+
+// TenantResourceID returns the ID of the tenant, the root of every resource ID.
+func TenantResourceID() *ResourceID {
+	return &ResourceID{
+		ResourceType: ResourceType{Namespace: resourcesNamespace, Types: []string{tenantsType}},
+	}
+}
+
+// NewSubscriptionResourceID returns the ID of the specified subscription.
+func NewSubscriptionResourceID(subscriptionID string) *ResourceID {
+	return &ResourceID{
+		Parent:         TenantResourceID(),
+		SubscriptionID: subscriptionID,
+		ResourceType:   ResourceType{Namespace: resourcesNamespace, Types: []string{subscriptionsKey}},
+		Name:           subscriptionID,
+	}
+}
+
+// NewResourceGroupResourceID returns the ID of the specified resource group.
+func NewResourceGroupResourceID(subscriptionID, resourceGroupName string) *ResourceID {
+	return &ResourceID{
+		Parent:            NewSubscriptionResourceID(subscriptionID),
+		SubscriptionID:    subscriptionID,
+		ResourceGroupName: resourceGroupName,
+		ResourceType:      ResourceType{Namespace: resourcesNamespace, Types: []string{resourceGroupsKey}},
+		Name:              resourceGroupName,
+	}
+}
+
+// NewManagementGroupResourceID returns the ID of the specified management group.
+func NewManagementGroupResourceID(name string) *ResourceID {
+	return TenantResourceID().ProviderResource(managementNamespace, managementGroupsType, name)
+}
+
+// ProviderResource returns the ID of a resource of the specified provider namespace and type at the scope
+// of this ID.  When this ID is itself a provider resource the new ID is an extension resource.
+func (id *ResourceID) ProviderResource(namespace, resourceType, name string) *ResourceID {
+	return &ResourceID{
+		Parent:            id,
+		SubscriptionID:    id.SubscriptionID,
+		ResourceGroupName: id.ResourceGroupName,
+		ResourceType:      ResourceType{Namespace: namespace, Types: []string{resourceType}},
+		Name:              name,
+	}
+}
+
+// ChildResource returns the ID of a child resource of the specified type, e.g. a subnet of a virtual network.
+func (id *ResourceID) ChildResource(resourceType, name string) *ResourceID {
+	return &ResourceID{
+		Parent:            id,
+		SubscriptionID:    id.SubscriptionID,
+		ResourceGroupName: id.ResourceGroupName,
+		ResourceType:      id.ResourceType.child(resourceType),
+		Name:              name,
+		isChild:           true,
+	}
+}
+
+// IsTenant returns true if this is the ID of the tenant.
+func (id *ResourceID) IsTenant() bool {
+	return id.Parent == nil
+}
+
+// IsChild returns true if this is a child resource of its parent, e.g. a subnet of a virtual network.
+func (id *ResourceID) IsChild() bool {
+	return id.isChild
+}
+
+// IsExtension returns true if this is an extension resource applied to another provider resource,
+// e.g. a role assignment on a virtual machine.
+func (id *ResourceID) IsExtension() bool {
+	return !id.isChild && id.Parent != nil && id.Parent.isProviderResource()
+}
+
+// returns true for the ID of a subscription
+func (id *ResourceID) isSubscription() bool {
+	return id.Parent != nil && id.Parent.IsTenant() && !id.isChild &&
+		strings.EqualFold(id.ResourceType.Namespace, resourcesNamespace) && strings.EqualFold(id.ResourceType.LastType(), subscriptionsKey)
+}
+
+// returns true for the ID of a resource group
+func (id *ResourceID) isResourceGroup() bool {
+	return id.Parent != nil && id.Parent.isSubscription() && !id.isChild &&
+		strings.EqualFold(id.ResourceType.Namespace, resourcesNamespace) && strings.EqualFold(id.ResourceType.LastType(), resourceGroupsKey)
+}
+
+// returns true for resources that aren't the tenant, a subscription or a resource group
+func (id *ResourceID) isProviderResource() bool {
+	return id.Parent != nil && !id.isSubscription() && !id.isResourceGroup()
+}
+
+// Scope returns the ID of the tenant, management group, subscription, resource group or resource
+// the resource is deployed at.  Child resources have the scope of their parent.  It returns nil for the tenant.
+func (id *ResourceID) Scope() *ResourceID {
+	if id.isChild {
+		return id.Parent.Scope()
+	}
+	return id.Parent
+}
+
+// String returns the resource ID in its canonical form.
+func (id *ResourceID) String() string {
+	if id.Parent == nil {
+		return resourceIDSeparator
+	}
+	parent := id.Parent.String()
+	if parent == resourceIDSeparator {
+		parent = ""
+	}
+	switch {
+	case id.isChild:
+		return fmt.Sprintf("%s/%s/%s", parent, id.ResourceType.LastType(), id.Name)
+	case id.isSubscription():
+		return fmt.Sprintf("/%s/%s", subscriptionsKey, id.Name)
+	case id.isResourceGroup():
+		return fmt.Sprintf("%s/%s/%s", parent, resourceGroupsKey, id.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%s", parent, providersKey, id.ResourceType.Namespace, id.ResourceType.LastType(), id.Name)
+}
+
+// Equal returns true if both IDs refer to the same resource.  Resource IDs are case-insensitive.
+func (id *ResourceID) Equal(other *ResourceID) bool {
+	if id == nil || other == nil {
+		return id == other
+	}
+	return strings.EqualFold(id.String(), other.String())
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (id *ResourceID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (id *ResourceID) UnmarshalText(text []byte) error {
+	parsed, err := ParseHierarchicalResourceID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = *parsed
+	return nil
+}
+
+// ParseHierarchicalResourceID parses a resource ID at any scope into a ResourceID.  Unlike ParseResourceID it accepts
+// tenant, management group, subscription and resource group scopes, and retains child and extension resources,
+// e.g. /subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm/providers/Microsoft.Authorization/roleAssignments/ra.
+func ParseHierarchicalResourceID(resourceID string) (*ResourceID, error) {
+	if !strings.HasPrefix(resourceID, resourceIDSeparator) {
+		return nil, fmt.Errorf("azure: failed to parse resource ID %q: the resource ID must start with %s", resourceID, resourceIDSeparator)
+	}
+	id := TenantResourceID()
+	trimmed := strings.Trim(resourceID, resourceIDSeparator)
+	if trimmed == "" {
+		return id, nil
+	}
+	parts := strings.Split(trimmed, resourceIDSeparator)
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("azure: failed to parse resource ID %q: the resource ID contains an empty segment", resourceID)
+		}
+	}
+	for len(parts) > 0 {
+		var err error
+		if id, parts, err = appendResourceIDSegment(id, parts); err != nil {
+			return nil, fmt.Errorf("azure: failed to parse resource ID %q: %v", resourceID, err)
+		}
+	}
+	return id, nil
+}
+
+// consumes the next resource from parts and returns its ID and the remaining parts
+func appendResourceIDSegment(parent *ResourceID, parts []string) (*ResourceID, []string, error) {
+	key := parts[0]
+	switch {
+	case strings.EqualFold(key, providersKey):
+		if len(parts) < 4 {
+			return nil, nil, fmt.Errorf("%s must be followed by a namespace, resource type and name", providersKey)
+		}
+		return parent.ProviderResource(parts[1], parts[2], parts[3]), parts[4:], nil
+	case strings.EqualFold(key, subscriptionsKey) && parent.IsTenant():
+		if len(parts) < 2 {
+			return nil, nil, fmt.Errorf("%s must be followed by a subscription ID", subscriptionsKey)
+		}
+		return NewSubscriptionResourceID(parts[1]), parts[2:], nil
+	case strings.EqualFold(key, resourceGroupsKey) && parent.isSubscription():
+		if len(parts) < 2 {
+			return nil, nil, fmt.Errorf("%s must be followed by a resource group name", resourceGroupsKey)
+		}
+		return NewResourceGroupResourceID(parent.SubscriptionID, parts[1]), parts[2:], nil
+	case parent.IsTenant():
+		return nil, nil, fmt.Errorf("unexpected segment %q, expected %s or %s", key, subscriptionsKey, providersKey)
+	}
+	if len(parts) < 2 {
+		return nil, nil, fmt.Errorf("resource type %q must be followed by a name", key)
+	}
+	return parent.ChildResource(key, parts[1]), parts[2:], nil
+}
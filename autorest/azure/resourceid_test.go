@@ -0,0 +1,218 @@
+package azure
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseHierarchicalResourceID(t *testing.T) {
+	testCases := []struct {
+		id           string
+		resourceType string
+		name         string
+		subscription string
+		group        string
+		scope        string
+		parent       string
+		child        bool
+		extension    bool
+	}{
+		{
+			id:           "/",
+			resourceType: "Microsoft.Resources/tenants",
+		},
+		{
+			id:           "/subscriptions/sub",
+			resourceType: "Microsoft.Resources/subscriptions",
+			name:         "sub",
+			subscription: "sub",
+			scope:        "/",
+			parent:       "/",
+		},
+		{
+			id:           "/subscriptions/sub/resourceGroups/rg",
+			resourceType: "Microsoft.Resources/resourceGroups",
+			name:         "rg",
+			subscription: "sub",
+			group:        "rg",
+			scope:        "/subscriptions/sub",
+			parent:       "/subscriptions/sub",
+		},
+		{
+			id:           "/providers/Microsoft.Management/managementGroups/mg",
+			resourceType: "Microsoft.Management/managementGroups",
+			name:         "mg",
+			scope:        "/",
+			parent:       "/",
+		},
+		{
+			id:           "/subscriptions/sub/providers/Microsoft.Authorization/roleDefinitions/rd",
+			resourceType: "Microsoft.Authorization/roleDefinitions",
+			name:         "rd",
+			subscription: "sub",
+			scope:        "/subscriptions/sub",
+			parent:       "/subscriptions/sub",
+		},
+		{
+			id:           "/subscriptions/sub/locations/westus",
+			resourceType: "Microsoft.Resources/subscriptions/locations",
+			name:         "westus",
+			subscription: "sub",
+			scope:        "/",
+			parent:       "/subscriptions/sub",
+			child:        true,
+		},
+		{
+			id:           "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet",
+			resourceType: "Microsoft.Network/virtualNetworks/subnets",
+			name:         "subnet",
+			subscription: "sub",
+			group:        "rg",
+			scope:        "/subscriptions/sub/resourceGroups/rg",
+			parent:       "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet",
+			child:        true,
+		},
+		{
+			id:           "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm/providers/Microsoft.Authorization/roleAssignments/ra",
+			resourceType: "Microsoft.Authorization/roleAssignments",
+			name:         "ra",
+			subscription: "sub",
+			group:        "rg",
+			scope:        "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+			parent:       "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+			extension:    true,
+		},
+		{
+			id:           "/providers/Microsoft.Management/managementGroups/mg/providers/Microsoft.Authorization/policyAssignments/pa",
+			resourceType: "Microsoft.Authorization/policyAssignments",
+			name:         "pa",
+			scope:        "/providers/Microsoft.Management/managementGroups/mg",
+			parent:       "/providers/Microsoft.Management/managementGroups/mg",
+			extension:    true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			id, err := ParseHierarchicalResourceID(tc.id)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if s := id.String(); s != tc.id {
+				t.Fatalf("expected %s, got %s", tc.id, s)
+			}
+			if rt := id.ResourceType.String(); rt != tc.resourceType {
+				t.Fatalf("expected resource type %s, got %s", tc.resourceType, rt)
+			}
+			if id.Name != tc.name || id.SubscriptionID != tc.subscription || id.ResourceGroupName != tc.group {
+				t.Fatalf("unexpected ID %+v", id)
+			}
+			if id.IsChild() != tc.child || id.IsExtension() != tc.extension {
+				t.Fatalf("expected child %v and extension %v", tc.child, tc.extension)
+			}
+			if tc.scope == "" {
+				if !id.IsTenant() || id.Scope() != nil || id.Parent != nil {
+					t.Fatal("expected the tenant")
+				}
+				return
+			}
+			if s := id.Scope().String(); s != tc.scope {
+				t.Fatalf("expected scope %s, got %s", tc.scope, s)
+			}
+			if p := id.Parent.String(); p != tc.parent {
+				t.Fatalf("expected parent %s, got %s", tc.parent, p)
+			}
+		})
+	}
+}
+
+func TestParseHierarchicalResourceIDInvalid(t *testing.T) {
+	for _, id := range []string{
+		"",
+		"subscriptions/sub",
+		"/resourceGroups/rg",
+		"/subscriptions",
+		"/subscriptions/sub/resourceGroups",
+		"/subscriptions/sub//resourceGroups/rg",
+		"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/",
+		"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets",
+	} {
+		if _, err := ParseHierarchicalResourceID(id); err == nil {
+			t.Fatalf("expected an error parsing %q", id)
+		}
+	}
+}
+
+func TestResourceIDBuilder(t *testing.T) {
+	vnet := NewResourceGroupResourceID("sub", "rg").ProviderResource("Microsoft.Network", "virtualNetworks", "vnet")
+	subnet := vnet.ChildResource("subnets", "subnet")
+	const expected = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+	if s := subnet.String(); s != expected {
+		t.Fatalf("expected %s, got %s", expected, s)
+	}
+	if rt := subnet.ResourceType.String(); rt != "Microsoft.Network/virtualNetworks/subnets" {
+		t.Fatalf("unexpected resource type %s", rt)
+	}
+	// building a child must not modify the parent's type
+	if rt := vnet.ResourceType.String(); rt != "Microsoft.Network/virtualNetworks" {
+		t.Fatalf("unexpected parent resource type %s", rt)
+	}
+	lock := subnet.ProviderResource("Microsoft.Authorization", "locks", "lock")
+	if !lock.IsExtension() || lock.SubscriptionID != "sub" || lock.ResourceGroupName != "rg" {
+		t.Fatalf("unexpected extension %+v", lock)
+	}
+	mg := NewManagementGroupResourceID("mg")
+	if s := mg.String(); s != "/providers/Microsoft.Management/managementGroups/mg" {
+		t.Fatalf("unexpected management group %s", s)
+	}
+}
+
+func TestResourceIDEqual(t *testing.T) {
+	id, err := ParseHierarchicalResourceID("/SUBSCRIPTIONS/sub/resourcegroups/RG/providers/microsoft.network/virtualnetworks/VNET")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	built := NewResourceGroupResourceID("sub", "rg").ProviderResource("Microsoft.Network", "virtualNetworks", "vnet")
+	if !id.Equal(built) {
+		t.Fatalf("expected %s to equal %s", id, built)
+	}
+	if id.Equal(built.ChildResource("subnets", "subnet")) {
+		t.Fatal("unexpected equality")
+	}
+	if id.Equal(nil) {
+		t.Fatal("unexpected equality with nil")
+	}
+}
+
+func TestResourceIDJSON(t *testing.T) {
+	const expected = `{"id":"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Web/sites/site"}`
+	v := struct {
+		ID *ResourceID `json:"id"`
+	}{}
+	if err := json.Unmarshal([]byte(expected), &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.ID.Name != "site" || v.ID.ResourceGroupName != "rg" {
+		t.Fatalf("unexpected ID %+v", v.ID)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(b) != expected {
+		t.Fatalf("expected %s, got %s", expected, b)
+	}
+}
@@ -15,19 +15,31 @@
 package azure
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/go-autorest/autorest"
 )
 
+// the registration manager used by DoRetryWithRegistration
+var defaultRegistrationManager = NewRegistrationManager(0)
+
 // DoRetryWithRegistration tries to register the resource provider in case it is unregistered.
-// It also handles request retries
+// It also handles request retries.  Registrations are shared by all the clients in the process,
+// see RegistrationManager.
 func DoRetryWithRegistration(client autorest.Client) autorest.SendDecorator {
+	return DoRetryWithRegistrationManager(client, defaultRegistrationManager)
+}
+
+// DoRetryWithRegistrationManager tries to register the resource provider in case it is unregistered
+// using the specified RegistrationManager.  It also handles request retries.
+func DoRetryWithRegistrationManager(client autorest.Client, manager *RegistrationManager) autorest.SendDecorator {
 	return func(s autorest.Sender) autorest.Sender {
 		return autorest.SenderFunc(func(r *http.Request) (resp *http.Response, err error) {
 			rr := autorest.NewRetriableRequest(r)
@@ -62,7 +74,7 @@ func DoRetryWithRegistration(client autorest.Client) autorest.SendDecorator {
 				err = re
 
 				if re.ServiceError != nil && re.ServiceError.Code == "MissingSubscriptionRegistration" {
-					regErr := register(client, r, re)
+					regErr := manager.registerFromError(client, r, re)
 					if regErr != nil {
 						return resp, fmt.Errorf("failed auto registering Resource Provider: %s. Original error: %w", regErr, err)
 					}
@@ -73,26 +85,184 @@ func DoRetryWithRegistration(client autorest.Client) autorest.SendDecorator {
 	}
 }
 
-func getProvider(re RequestError) (string, error) {
-	if re.ServiceError != nil && len(re.ServiceError.Details) > 0 {
-		return re.ServiceError.Details[0]["target"].(string), nil
+// returns the resource providers named by the targets of the error details
+func getProviders(re RequestError) ([]string, error) {
+	var providers []string
+	if re.ServiceError != nil {
+		seen := map[string]bool{}
+		for _, detail := range re.ServiceError.Details {
+			target, ok := detail["target"].(string)
+			if !ok || target == "" || seen[strings.ToLower(target)] {
+				continue
+			}
+			seen[strings.ToLower(target)] = true
+			providers = append(providers, target)
+		}
+	}
+	if len(providers) == 0 {
+		return nil, errors.New("provider was not found in the response")
 	}
-	return "", errors.New("provider was not found in the response")
+	return providers, nil
+}
+
+// DefaultRegistrationCacheDuration is how long a provider registered by a RegistrationManager is
+// considered registered when its CacheDuration is zero.
+const DefaultRegistrationCacheDuration = 10 * time.Minute
+
+// RegistrationManager registers resource providers on behalf of the requests failing with
+// MissingSubscriptionRegistration.  Concurrent registrations of the same provider in the same
+// subscription of the same Resource Manager endpoint are made once and waited on by all the
+// requests.  Providers that have been registered are cached for CacheDuration so Register doesn't
+// register them again, after that a MissingSubscriptionRegistration error registers them again.
+type RegistrationManager struct {
+	// Timeout is the maximum time spent registering a provider, including waiting for the provider
+	// to be registered.  If zero the client's PollingDuration is used.
+	Timeout time.Duration
+
+	// CacheDuration is how long a registered provider is considered registered, during which a
+	// MissingSubscriptionRegistration error is attributed to the registration not having propagated
+	// yet and the request is sent again without registering the provider.
+	// DefaultRegistrationCacheDuration if zero.
+	CacheDuration time.Duration
+
+	mu sync.Mutex
+	// the time each provider was registered at
+	registered map[string]time.Time
+	calls      map[string]*registrationCall
+}
+
+// a registration in progress
+type registrationCall struct {
+	done chan struct{}
+	err  error
+}
+
+// NewRegistrationManager creates a RegistrationManager with the specified timeout.
+func NewRegistrationManager(timeout time.Duration) *RegistrationManager {
+	return &RegistrationManager{
+		Timeout:    timeout,
+		registered: map[string]time.Time{},
+		calls:      map[string]*registrationCall{},
+	}
+}
+
+// returns the key of the provider in the subscription, clouds and stamps have different endpoints
+func registrationKey(baseURI, subscriptionID, provider string) string {
+	host := baseURI
+	if u, err := url.Parse(baseURI); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return strings.ToLower(host + "/" + subscriptionID + "/" + provider)
+}
+
+// returns true if the provider was registered within the cache duration, the caller must hold mu
+func (m *RegistrationManager) isRegistered(key string) bool {
+	registeredAt, ok := m.registered[key]
+	if !ok {
+		return false
+	}
+	cacheDuration := m.CacheDuration
+	if cacheDuration <= 0 {
+		cacheDuration = DefaultRegistrationCacheDuration
+	}
+	return time.Since(registeredAt) < cacheDuration
+}
+
+// IsRegistered returns true if the provider has been registered in the subscription by the manager
+// within its CacheDuration.  baseURI is the Resource Manager endpoint, e.g. https://management.azure.com.
+func (m *RegistrationManager) IsRegistered(baseURI, subscriptionID, provider string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.isRegistered(registrationKey(baseURI, subscriptionID, provider))
+}
+
+// Forget removes the provider from the cache so it's registered again on the next failure,
+// e.g. after the provider has been unregistered.
+func (m *RegistrationManager) Forget(baseURI, subscriptionID, provider string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.registered, registrationKey(baseURI, subscriptionID, provider))
 }
 
-func register(client autorest.Client, originalReq *http.Request, re RequestError) error {
+// Register registers the provider in the subscription and waits until its registrationState is
+// Registered.  baseURI is the Resource Manager endpoint, e.g. https://management.azure.com.
+// It returns immediately if the provider has been registered by the manager within its
+// CacheDuration.  If a registration of the provider is in progress it waits for that registration
+// instead.  The requests of the registration carry the values of the context of the caller that
+// started it, but the registration isn't canceled with that context; each caller stops waiting
+// when its own context is done.
+func (m *RegistrationManager) Register(ctx context.Context, client autorest.Client, baseURI, subscriptionID, provider string) error {
+	key := registrationKey(baseURI, subscriptionID, provider)
+	m.mu.Lock()
+	if m.isRegistered(key) {
+		m.mu.Unlock()
+		return nil
+	}
+	call, inProgress := m.calls[key]
+	if !inProgress {
+		call = &registrationCall{done: make(chan struct{})}
+		m.calls[key] = call
+		go func() {
+			err := m.register(detachedContext{ctx}, client, baseURI, subscriptionID, provider)
+			m.mu.Lock()
+			delete(m.calls, key)
+			if err == nil {
+				m.registered[key] = time.Now()
+			}
+			call.err = err
+			m.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	m.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// registers the providers named in the MissingSubscriptionRegistration error of the request
+func (m *RegistrationManager) registerFromError(client autorest.Client, originalReq *http.Request, re RequestError) error {
 	subID := getSubscription(originalReq.URL.Path)
 	if subID == "" {
 		return errors.New("missing parameter subscriptionID to register resource provider")
 	}
-	providerName, err := getProvider(re)
+	providers, err := getProviders(re)
 	if err != nil {
 		return fmt.Errorf("missing parameter provider to register resource provider: %s", err)
 	}
-	newURL := url.URL{
+	baseURL := url.URL{
 		Scheme: originalReq.URL.Scheme,
 		Host:   originalReq.URL.Host,
 	}
+	// the registration is part of the operation of the original request
+	ctx := originalReq.Context()
+	if autorest.GetCorrelationID(ctx) == "" {
+		ctx = autorest.WithCorrelationID(ctx, ExtractCorrelationID(re.Response))
+	}
+	for _, provider := range providers {
+		if err := m.Register(ctx, client, baseURL.String(), subID, provider); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registers the provider and polls it until it's registered or the timeout elapses
+func (m *RegistrationManager) register(ctx context.Context, client autorest.Client, baseURI, subID, providerName string) error {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = client.PollingDuration
+	}
+	if timeout == 0 {
+		// the registration isn't canceled with the context of the callers
+		timeout = autorest.DefaultPollingDuration
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
 	// taken from the resources SDK
 	// with almost identical code, this sections are easier to mantain
@@ -110,7 +280,7 @@ func register(client autorest.Client, originalReq *http.Request, re RequestError
 
 	preparer := autorest.CreatePreparer(
 		autorest.AsPost(),
-		autorest.WithBaseURL(newURL.String()),
+		autorest.WithBaseURL(baseURI),
 		autorest.WithPathParameters("/subscriptions/{subscriptionId}/providers/{resourceProviderNamespace}/register", pathParameters),
 		autorest.WithQueryParameters(queryParameters),
 	)
@@ -119,7 +289,7 @@ func register(client autorest.Client, originalReq *http.Request, re RequestError
 	if err != nil {
 		return err
 	}
-	req = req.WithContext(originalReq.Context())
+	req = req.WithContext(ctx)
 
 	resp, err := autorest.SendWithSender(client, req,
 		autorest.DoRetryForStatusCodes(client.RetryAttempts, client.RetryDuration, autorest.StatusCodesForRetry...),
@@ -144,13 +314,12 @@ func register(client autorest.Client, originalReq *http.Request, re RequestError
 	}
 
 	// poll for registered provisioning state
-	registrationStartTime := time.Now()
-	for err == nil && (client.PollingDuration == 0 || (client.PollingDuration != 0 && time.Since(registrationStartTime) < client.PollingDuration)) {
+	for provider.RegistrationState == nil || !strings.EqualFold(*provider.RegistrationState, "Registered") {
 		// taken from the resources SDK
 		// https://github.com/Azure/azure-sdk-for-go/blob/9f366792afa3e0ddaecdc860e793ba9d75e76c27/arm/resources/resources/providers.go#L45
 		preparer := autorest.CreatePreparer(
 			autorest.AsGet(),
-			autorest.WithBaseURL(newURL.String()),
+			autorest.WithBaseURL(baseURI),
 			autorest.WithPathParameters("/subscriptions/{subscriptionId}/providers/{resourceProviderNamespace}", pathParameters),
 			autorest.WithQueryParameters(queryParameters),
 		)
@@ -158,13 +327,13 @@ func register(client autorest.Client, originalReq *http.Request, re RequestError
 		if err != nil {
 			return err
 		}
-		req = req.WithContext(originalReq.Context())
+		req = req.WithContext(ctx)
 
 		resp, err := autorest.SendWithSender(client, req,
 			autorest.DoRetryForStatusCodes(client.RetryAttempts, client.RetryDuration, autorest.StatusCodesForRetry...),
 		)
 		if err != nil {
-			return err
+			return registrationError(ctx, err)
 		}
 
 		err = autorest.Respond(
@@ -178,16 +347,38 @@ func register(client autorest.Client, originalReq *http.Request, re RequestError
 		}
 
 		if provider.RegistrationState != nil &&
-			*provider.RegistrationState == "Registered" {
+			strings.EqualFold(*provider.RegistrationState, "Registered") {
 			break
 		}
 
-		delayed := autorest.DelayWithRetryAfter(resp, originalReq.Context().Done())
-		if !delayed && !autorest.DelayForBackoff(client.PollingDelay, 0, originalReq.Context().Done()) {
-			return originalReq.Context().Err()
+		delayed := autorest.DelayWithRetryAfter(resp, ctx.Done())
+		if !delayed && !autorest.DelayForBackoff(client.PollingDelay, 0, ctx.Done()) {
+			return registrationError(ctx, ctx.Err())
 		}
 	}
-	if client.PollingDuration != 0 && !(time.Since(registrationStartTime) < client.PollingDuration) {
+	return nil
+}
+
+// a context with the values of its parent that's never canceled
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// returns a descriptive error when the registration timed out
+func registrationError(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
 		return errors.New("polling for resource provider registration has exceeded the polling duration")
 	}
 	return err
@@ -16,8 +16,11 @@ package azure
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -167,3 +170,228 @@ func TestDoRetryWithRegistration_CanBeCancelled(t *testing.T) {
 		t.Fatalf("azure: DoRetryWithRegistration failed to cancel")
 	}
 }
+
+// registrationSender serves the requests of resource provider registrations
+type registrationSender struct {
+	registers int32
+	polls     int32
+	// the number of polls before the provider is registered, -1 for never
+	pollsUntilRegistered int32
+	latency              time.Duration
+}
+
+func (s *registrationSender) Do(req *http.Request) (*http.Response, error) {
+	if !strings.Contains(req.URL.Path, "/providers/") {
+		return nil, fmt.Errorf("unexpected request to %s", req.URL)
+	}
+	state := "Registering"
+	if req.Method == http.MethodPost {
+		atomic.AddInt32(&s.registers, 1)
+		time.Sleep(s.latency)
+	} else if n := atomic.AddInt32(&s.polls, 1); s.pollsUntilRegistered >= 0 && n >= s.pollsUntilRegistered {
+		state = "Registered"
+	}
+	resp := mocks.NewResponseWithBodyAndStatus(mocks.NewBody(fmt.Sprintf(`{"registrationState": "%s"}`, state)), http.StatusOK, "200 OK")
+	resp.Request = req
+	return resp, nil
+}
+
+func newRegistrationClient(sender autorest.Sender) autorest.Client {
+	return autorest.Client{
+		PollingDelay:    time.Millisecond,
+		PollingDuration: 10 * time.Second,
+		RetryAttempts:   1,
+		RetryDuration:   time.Millisecond,
+		Sender:          sender,
+	}
+}
+
+func TestRegistrationManager_DeduplicatesAndCaches(t *testing.T) {
+	sender := &registrationSender{pollsUntilRegistered: 2, latency: 50 * time.Millisecond}
+	client := newRegistrationClient(sender)
+	m := NewRegistrationManager(0)
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = m.Register(context.Background(), client, "https://lol", "rofl", "Microsoft.EventGrid")
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if sender.registers != 1 {
+		t.Fatalf("expected 1 registration, got %d", sender.registers)
+	}
+	if !m.IsRegistered("https://LOL/", "ROFL", "microsoft.eventgrid") {
+		t.Fatal("expected the provider to be registered")
+	}
+	if m.IsRegistered("https://other", "rofl", "Microsoft.EventGrid") {
+		t.Fatal("registrations must not be shared by endpoints")
+	}
+	// cached, no requests are made
+	if err := m.Register(context.Background(), client, "https://lol", "rofl", "Microsoft.EventGrid"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sender.registers != 1 || sender.polls != 2 {
+		t.Fatalf("unexpected requests: %d registrations, %d polls", sender.registers, sender.polls)
+	}
+	m.Forget("https://lol", "rofl", "Microsoft.EventGrid")
+	if m.IsRegistered("https://lol", "rofl", "Microsoft.EventGrid") {
+		t.Fatal("expected the provider to be forgotten")
+	}
+}
+
+func TestRegistrationManager_Timeout(t *testing.T) {
+	sender := &registrationSender{pollsUntilRegistered: -1}
+	m := NewRegistrationManager(50 * time.Millisecond)
+	err := m.Register(context.Background(), newRegistrationClient(sender), "https://lol", "rofl", "Microsoft.EventGrid")
+	if err == nil || !strings.Contains(err.Error(), "exceeded the polling duration") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.IsRegistered("https://lol", "rofl", "Microsoft.EventGrid") {
+		t.Fatal("a failed registration must not be cached")
+	}
+}
+
+func TestDoRetryWithRegistrationManager_StaleRegistration(t *testing.T) {
+	m := NewRegistrationManager(0)
+	sender := mocks.NewSender()
+	sender.AppendResponse(mocks.NewResponseWithBodyAndStatus(mocks.NewBody(`{
+	"error":{
+		"code":"MissingSubscriptionRegistration",
+		"details":[{"code":"MissingSubscriptionRegistration","target":"Microsoft.EventGrid"}]
+	}
+}`), http.StatusConflict, "MissingSubscriptionRegistration"))
+	// registration response
+	sender.AppendResponse(mocks.NewResponseWithBodyAndStatus(mocks.NewBody(`{"registrationState": "Registered"}`), http.StatusOK, "200 OK"))
+	sender.AppendResponse(mocks.NewResponseWithStatus("200 OK", http.StatusOK))
+	client := newRegistrationClient(sender)
+	client.RetryAttempts = 2
+	// the provider was registered by an earlier request and has been unregistered since
+	m.registered[registrationKey("https://lol", "rofl", "Microsoft.EventGrid")] = time.Now().Add(-2 * DefaultRegistrationCacheDuration)
+
+	req := mocks.NewRequestForURL("https://lol/subscriptions/rofl")
+	r, err := autorest.SendWithSender(sender, req, DoRetryWithRegistrationManager(client, m))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d", r.StatusCode)
+	}
+	if sender.Attempts() != 3 {
+		t.Fatalf("expected the provider to be registered again, got %d requests", sender.Attempts())
+	}
+	if !m.IsRegistered("https://lol", "rofl", "Microsoft.EventGrid") {
+		t.Fatal("expected the provider to be cached after registering it again")
+	}
+}
+
+func TestDoRetryWithRegistrationManager_CachedRegistration(t *testing.T) {
+	m := NewRegistrationManager(0)
+	sender := mocks.NewSender()
+	sender.AppendResponse(mocks.NewResponseWithBodyAndStatus(mocks.NewBody(`{
+	"error":{
+		"code":"MissingSubscriptionRegistration",
+		"details":[{"code":"MissingSubscriptionRegistration","target":"Microsoft.EventGrid"}]
+	}
+}`), http.StatusConflict, "MissingSubscriptionRegistration"))
+	sender.AppendResponse(mocks.NewResponseWithStatus("200 OK", http.StatusOK))
+	client := newRegistrationClient(sender)
+	client.RetryAttempts = 2
+	// the provider was registered by an earlier request, the registration hasn't propagated yet
+	m.registered[registrationKey("https://lol", "rofl", "Microsoft.EventGrid")] = time.Now()
+
+	req := mocks.NewRequestForURL("https://lol/subscriptions/rofl")
+	r, err := autorest.SendWithSender(sender, req, DoRetryWithRegistrationManager(client, m))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if r.StatusCode != http.StatusOK || sender.Attempts() != 2 {
+		t.Fatalf("expected the request to be sent again without registering the provider, got %d requests", sender.Attempts())
+	}
+}
+
+func TestRegistrationManager_WaiterContext(t *testing.T) {
+	sender := &registrationSender{pollsUntilRegistered: 1, latency: 100 * time.Millisecond}
+	client := newRegistrationClient(sender)
+	m := NewRegistrationManager(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		first <- m.Register(ctx, client, "https://lol", "rofl", "Microsoft.EventGrid")
+	}()
+	// wait for the registration to start
+	for atomic.LoadInt32(&sender.registers) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan error)
+	go func() {
+		second <- m.Register(context.Background(), client, "https://lol", "rofl", "Microsoft.EventGrid")
+	}()
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("expected the first caller to be canceled, got %v", err)
+	}
+	// the registration goes on for the other callers
+	if err := <-second; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sender.registers != 1 || !m.IsRegistered("https://lol", "rofl", "Microsoft.EventGrid") {
+		t.Fatalf("unexpected registrations %d", sender.registers)
+	}
+}
+
+func TestRegistrationManager_PropagatesCorrelationID(t *testing.T) {
+	sender := &registrationSender{pollsUntilRegistered: 1}
+	var ids []string
+	client := newRegistrationClient(autorest.SenderFunc(func(req *http.Request) (*http.Response, error) {
+		ids = append(ids, req.Header.Get(HeaderCorrelationRequestID))
+		return sender.Do(req)
+	}))
+	req := mocks.NewRequestForURL("https://management.azure.com/subscriptions/sub/resourceGroups/rg")
+	resp := mocks.NewResponseWithStatus("409 Conflict", http.StatusConflict)
+	resp.Request = req
+	mocks.SetResponseHeader(resp, HeaderCorrelationRequestID, "correlation")
+	re := RequestError{
+		DetailedError: autorest.DetailedError{Response: resp},
+		ServiceError: &ServiceError{
+			Code:    "MissingSubscriptionRegistration",
+			Details: []map[string]interface{}{{"target": "Microsoft.Test"}},
+		},
+	}
+	if err := NewRegistrationManager(0).registerFromError(client, req, re); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != "correlation" || ids[1] != "correlation" {
+		t.Fatalf("unexpected correlation IDs %v", ids)
+	}
+}
+
+func TestGetProviders(t *testing.T) {
+	re := RequestError{ServiceError: &ServiceError{
+		Details: []map[string]interface{}{
+			{"target": 42},
+			{"code": "NoTarget"},
+			{"target": "Microsoft.Network"},
+			{"target": "Microsoft.Compute"},
+			{"target": "microsoft.network"},
+		},
+	}}
+	providers, err := getProviders(re)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(providers) != 2 || providers[0] != "Microsoft.Network" || providers[1] != "Microsoft.Compute" {
+		t.Fatalf("unexpected providers %v", providers)
+	}
+	re.ServiceError.Details = []map[string]interface{}{{"target": nil}}
+	if _, err = getProviders(re); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -16,6 +16,7 @@ package autorest
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -65,6 +66,29 @@ const (
 `
 )
 
+// used as a key type in context.WithValue()
+type ctxCorrelationID struct{}
+
+// WithCorrelationID returns a copy of the provided context carrying the specified correlation ID.
+// Client.Do sets the x-ms-correlation-request-id header of every request made with the context
+// to this ID, so that all the requests of a logical operation (e.g. the initial request of a
+// long-running operation, its polls and the final GET) can be correlated in the service logs.
+// If the ID is empty the context is unchanged.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxCorrelationID{}, id)
+}
+
+// GetCorrelationID returns the correlation ID in the provided context or an empty string if there's none.
+func GetCorrelationID(ctx context.Context) string {
+	if id, ok := ctx.Value(ctxCorrelationID{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
 // Response serves as the base for all responses from generated clients. It provides access to the
 // last http.Response.
 type Response struct {
@@ -231,12 +255,17 @@ func (c *Client) AddToUserAgent(extension string) error {
 
 // Do implements the Sender interface by invoking the active Sender after applying authorization.
 // If Sender is not set, it uses a new instance of http.Client. In both cases it will, if UserAgent
-// is set, apply set the User-Agent header.
+// is set, apply set the User-Agent header.  If the request's context carries a correlation ID, see
+// WithCorrelationID, and the request has no x-ms-correlation-request-id header it's set to the ID.
 func (c Client) Do(r *http.Request) (*http.Response, error) {
 	if r.UserAgent() == "" {
 		r, _ = Prepare(r,
 			WithUserAgent(c.UserAgent))
 	}
+	if id := GetCorrelationID(r.Context()); id != "" && r.Header.Get(headerCorrelationID) == "" {
+		r, _ = Prepare(r,
+			WithHeader(headerCorrelationID, id))
+	}
 	// NOTE: c.WithInspection() must be last in the list so that it can inspect all preceding operations
 	r, err := Prepare(r,
 		c.WithAuthorization(),
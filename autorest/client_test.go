@@ -237,6 +237,44 @@ func TestClientDoSetsUserAgent(t *testing.T) {
 	}
 }
 
+func TestClientDoSetsCorrelationID(t *testing.T) {
+	s := mocks.NewSender()
+	c := Client{Sender: s}
+	r := mocks.NewRequest().WithContext(WithCorrelationID(context.Background(), "correlation"))
+
+	c.Do(r)
+	if got := s.Attempts(); got != 1 {
+		t.Fatalf("autorest: Client#Do sent %d requests, expected 1", got)
+	}
+	if id := r.Header.Get(headerCorrelationID); id != "correlation" {
+		t.Fatalf("autorest: Client#Do set %s=%q, expected %q", headerCorrelationID, id, "correlation")
+	}
+}
+
+func TestClientDoKeepsCorrelationIDHeader(t *testing.T) {
+	c := Client{Sender: mocks.NewSender()}
+	r := mocks.NewRequest().WithContext(WithCorrelationID(context.Background(), "correlation"))
+	r.Header.Set(headerCorrelationID, "explicit")
+
+	c.Do(r)
+	if id := r.Header.Get(headerCorrelationID); id != "explicit" {
+		t.Fatalf("autorest: Client#Do overwrote %s, got %q", headerCorrelationID, id)
+	}
+}
+
+func TestGetCorrelationID(t *testing.T) {
+	ctx := context.Background()
+	if id := GetCorrelationID(ctx); id != "" {
+		t.Fatalf("autorest: GetCorrelationID returned %q for a context without ID", id)
+	}
+	if WithCorrelationID(ctx, "") != ctx {
+		t.Fatal("autorest: WithCorrelationID changed the context for an empty ID")
+	}
+	if id := GetCorrelationID(WithCorrelationID(ctx, "correlation")); id != "correlation" {
+		t.Fatalf("autorest: GetCorrelationID returned %q, expected %q", id, "correlation")
+	}
+}
+
 func TestClientDoSetsAuthorization(t *testing.T) {
 	r := mocks.NewRequest()
 	s := mocks.NewSender()
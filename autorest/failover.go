@@ -0,0 +1,201 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/logger"
+)
+
+// DefaultFailoverProbeInterval is the duration an endpoint that failed is skipped before it's probed again.
+const DefaultFailoverProbeInterval = 30 * time.Second
+
+// FailoverEndpoints is an ordered list of base URLs of a service, e.g. the primary and secondary
+// endpoints of an RA-GRS storage account, along with their health.  An endpoint that fails is
+// considered unhealthy and is tried after the healthy ones until ProbeInterval elapses, then it's
+// probed again in its place.  It's safe for concurrent use and is meant to be shared by the
+// requests to the service, see DoFailover.
+type FailoverEndpoints struct {
+	// ProbeInterval is the duration an endpoint that failed is considered unhealthy,
+	// DefaultFailoverProbeInterval if zero.
+	ProbeInterval time.Duration
+
+	// AllowWrites fails over PUT and DELETE requests too.  Only set it when every endpoint accepts
+	// writes, unlike e.g. the read-only secondary endpoint of an RA-GRS storage account.
+	AllowWrites bool
+
+	mu        sync.Mutex
+	endpoints []*url.URL
+	// the time until which the endpoint at the same index is unhealthy
+	unhealthyUntil []time.Time
+}
+
+// NewFailoverEndpoints creates a FailoverEndpoints for the specified base URLs, in order of preference.
+// The URLs must be absolute, they can have a path in which case it's the prefix of the paths of the requests.
+func NewFailoverEndpoints(baseURLs ...string) (*FailoverEndpoints, error) {
+	if len(baseURLs) == 0 {
+		return nil, fmt.Errorf("autorest: no failover endpoints were specified")
+	}
+	fe := &FailoverEndpoints{
+		ProbeInterval:  DefaultFailoverProbeInterval,
+		endpoints:      make([]*url.URL, len(baseURLs)),
+		unhealthyUntil: make([]time.Time, len(baseURLs)),
+	}
+	for i, baseURL := range baseURLs {
+		u, err := url.Parse(baseURL)
+		if err != nil || !u.IsAbs() || u.Host == "" {
+			return nil, fmt.Errorf("autorest: invalid failover endpoint %q", baseURL)
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		u.RawPath = ""
+		u.RawQuery = ""
+		u.Fragment = ""
+		fe.endpoints[i] = u
+	}
+	return fe, nil
+}
+
+// Healthy returns the base URLs of the endpoints that are considered healthy, in order of preference.
+func (fe *FailoverEndpoints) Healthy() []string {
+	now := time.Now()
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	healthy := []string{}
+	for i, ep := range fe.endpoints {
+		if !now.Before(fe.unhealthyUntil[i]) {
+			healthy = append(healthy, ep.String())
+		}
+	}
+	return healthy
+}
+
+// returns the indexes of the endpoints in the order they should be tried, the healthy ones first
+func (fe *FailoverEndpoints) order() []int {
+	now := time.Now()
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	healthy := make([]int, 0, len(fe.endpoints))
+	var unhealthy []int
+	for i := range fe.endpoints {
+		if now.Before(fe.unhealthyUntil[i]) {
+			unhealthy = append(unhealthy, i)
+		} else {
+			healthy = append(healthy, i)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (fe *FailoverEndpoints) setHealthy(i int, healthy bool) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	if healthy {
+		fe.unhealthyUntil[i] = time.Time{}
+		return
+	}
+	interval := fe.ProbeInterval
+	if interval <= 0 {
+		interval = DefaultFailoverProbeInterval
+	}
+	fe.unhealthyUntil[i] = time.Now().Add(interval)
+}
+
+// returns the index of the endpoint the URL belongs to, -1 if it doesn't belong to any
+func (fe *FailoverEndpoints) match(u *url.URL) int {
+	if u == nil {
+		return -1
+	}
+	for i, ep := range fe.endpoints {
+		if strings.EqualFold(ep.Scheme, u.Scheme) && strings.EqualFold(ep.Host, u.Host) &&
+			(ep.Path == "" || u.Path == ep.Path || strings.HasPrefix(u.Path, ep.Path+"/")) {
+			return i
+		}
+	}
+	return -1
+}
+
+// returns a copy of the URL with the base URL of the endpoint at index from replaced by the one at index to
+func (fe *FailoverEndpoints) rewrite(u *url.URL, from, to int) *url.URL {
+	target := *u
+	target.Scheme = fe.endpoints[to].Scheme
+	target.Host = fe.endpoints[to].Host
+	target.Path = fe.endpoints[to].Path + strings.TrimPrefix(u.Path, fe.endpoints[from].Path)
+	target.RawPath = ""
+	return &target
+}
+
+// DoFailover returns a SendDecorator that sends GET, HEAD and OPTIONS requests, and PUT and DELETE
+// requests if AllowWrites is set, to the endpoints in order of preference, see FailoverEndpoints,
+// moving on to the next endpoint when a request fails to be sent or its response has a 5xx status
+// code.  The request is sent to the endpoint by replacing the base URL it was prepared for, which
+// must be one of the endpoints, and its body is replayed as with the other retrying SendDecorators.
+// The response of the last endpoint tried is returned.  Other requests are sent unchanged.
+// Failing over may be canceled by cancelling the context on the http.Request.
+func DoFailover(endpoints *FailoverEndpoints) SendDecorator {
+	return func(s Sender) Sender {
+		return SenderFunc(func(r *http.Request) (resp *http.Response, err error) {
+			from := endpoints.match(r.URL)
+			if from < 0 || !endpoints.canFailover(r.Method) {
+				return s.Do(r)
+			}
+			rr := NewRetriableRequest(r)
+			order := endpoints.order()
+			for attempt, i := range order {
+				err = rr.Prepare()
+				if err != nil {
+					return resp, err
+				}
+				DrainResponseBody(resp)
+				req := rr.Request().WithContext(r.Context())
+				req.URL = endpoints.rewrite(r.URL, from, i)
+				req.Host = ""
+				resp, err = s.Do(req)
+				if err != nil && (IsTokenRefreshError(err) || r.Context().Err() != nil) {
+					return resp, err
+				}
+				if err == nil && resp.StatusCode < http.StatusInternalServerError {
+					endpoints.setHealthy(i, true)
+					return resp, err
+				}
+				endpoints.setHealthy(i, false)
+				if attempt < len(order)-1 {
+					if err != nil {
+						logger.Instance.Writef(logger.LogWarning, "DoFailover: failed to send the request to %s: %v\n", req.URL.Host, err)
+					} else {
+						logger.Instance.Writef(logger.LogWarning, "DoFailover: received status code %d from %s\n", resp.StatusCode, req.URL.Host)
+					}
+				}
+			}
+			return resp, err
+		})
+	}
+}
+
+// returns true if requests with the HTTP method can be sent to another endpoint
+func (fe *FailoverEndpoints) canFailover(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPut, http.MethodDelete:
+		return fe.AllowWrites
+	}
+	return false
+}
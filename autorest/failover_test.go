@@ -0,0 +1,221 @@
+package autorest
+
+// Copyright 2017 Microsoft Corporation
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a test endpoint that records the requests it receives
+type failoverServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	status int
+	paths  []string
+	bodies []string
+}
+
+func newFailoverServer(status int) *failoverServer {
+	fs := &failoverServer{status: status}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		fs.mu.Lock()
+		fs.paths = append(fs.paths, r.URL.Path)
+		fs.bodies = append(fs.bodies, string(b))
+		status := fs.status
+		fs.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	return fs
+}
+
+func (fs *failoverServer) setStatus(status int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.status = status
+}
+
+func (fs *failoverServer) requests() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return len(fs.paths)
+}
+
+func TestNewFailoverEndpoints(t *testing.T) {
+	if _, err := NewFailoverEndpoints(); err == nil {
+		t.Fatal("autorest: NewFailoverEndpoints expected an error without endpoints")
+	}
+	if _, err := NewFailoverEndpoints("https://primary", "secondary"); err == nil {
+		t.Fatal("autorest: NewFailoverEndpoints expected an error for a relative URL")
+	}
+	fe, err := NewFailoverEndpoints("https://primary/", "https://secondary/base/")
+	if err != nil {
+		t.Fatalf("autorest: NewFailoverEndpoints failed: %v", err)
+	}
+	if healthy := fe.Healthy(); len(healthy) != 2 || healthy[0] != "https://primary" || healthy[1] != "https://secondary/base" {
+		t.Fatalf("autorest: unexpected healthy endpoints %v", healthy)
+	}
+}
+
+func TestDoFailover(t *testing.T) {
+	primary := newFailoverServer(http.StatusServiceUnavailable)
+	defer primary.Close()
+	secondary := newFailoverServer(http.StatusOK)
+	defer secondary.Close()
+	fe, err := NewFailoverEndpoints(primary.URL, secondary.URL+"/secondary")
+	if err != nil {
+		t.Fatalf("autorest: NewFailoverEndpoints failed: %v", err)
+	}
+	fe.ProbeInterval = 100 * time.Millisecond
+
+	send := func(method string) *http.Response {
+		req, err := http.NewRequest(method, primary.URL+"/container/blob?comp=block", strings.NewReader("content"))
+		if err != nil {
+			t.Fatalf("autorest: failed to create the request: %v", err)
+		}
+		resp, err := SendWithSender(&http.Client{}, req, DoFailover(fe))
+		if err != nil {
+			t.Fatalf("autorest: DoFailover failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// writes aren't failed over by default
+	if resp := send(http.MethodPut); resp.StatusCode != http.StatusServiceUnavailable || primary.requests() != 1 || secondary.requests() != 0 {
+		t.Fatalf("autorest: DoFailover failed over a PUT, status %d", resp.StatusCode)
+	}
+	if healthy := fe.Healthy(); len(healthy) != 2 {
+		t.Fatalf("autorest: unexpected healthy endpoints %v", healthy)
+	}
+
+	// fails over to the secondary
+	resp := send(http.MethodGet)
+	if resp.StatusCode != http.StatusOK || primary.requests() != 2 || secondary.requests() != 1 {
+		t.Fatalf("autorest: DoFailover didn't fail over, status %d", resp.StatusCode)
+	}
+	if secondary.paths[0] != "/secondary/container/blob" || resp.Request.URL.Query().Get("comp") != "block" {
+		t.Fatalf("autorest: DoFailover sent %s to the secondary", secondary.paths[0])
+	}
+	if healthy := fe.Healthy(); len(healthy) != 1 || healthy[0] != secondary.URL+"/secondary" {
+		t.Fatalf("autorest: unexpected healthy endpoints %v", healthy)
+	}
+
+	// the unhealthy primary is skipped
+	send(http.MethodGet)
+	if primary.requests() != 2 || secondary.requests() != 2 {
+		t.Fatal("autorest: DoFailover didn't skip the unhealthy primary")
+	}
+
+	// the primary is probed again after the probe interval
+	primary.setStatus(http.StatusOK)
+	time.Sleep(2 * fe.ProbeInterval)
+	send(http.MethodGet)
+	if primary.requests() != 3 || secondary.requests() != 2 || len(fe.Healthy()) != 2 {
+		t.Fatal("autorest: DoFailover didn't probe the primary again")
+	}
+}
+
+func TestDoFailover_AllowWrites(t *testing.T) {
+	primary := newFailoverServer(http.StatusServiceUnavailable)
+	defer primary.Close()
+	secondary := newFailoverServer(http.StatusOK)
+	defer secondary.Close()
+	fe, err := NewFailoverEndpoints(primary.URL, secondary.URL)
+	if err != nil {
+		t.Fatalf("autorest: NewFailoverEndpoints failed: %v", err)
+	}
+	fe.AllowWrites = true
+
+	for _, method := range []string{http.MethodPut, http.MethodPost} {
+		req, _ := http.NewRequest(method, primary.URL+"/item", strings.NewReader("content"))
+		resp, err := SendWithSender(&http.Client{}, req, DoFailover(fe))
+		if err != nil {
+			t.Fatalf("autorest: DoFailover failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	// the PUT is failed over and its body replayed, the POST is sent to the primary
+	if primary.requests() != 2 || secondary.requests() != 1 || secondary.bodies[0] != "content" {
+		t.Fatalf("autorest: unexpected requests, primary %d, secondary %v", primary.requests(), secondary.bodies)
+	}
+}
+
+func TestDoFailover_ConnectionFailure(t *testing.T) {
+	primary := newFailoverServer(http.StatusOK)
+	primary.Close()
+	secondary := newFailoverServer(http.StatusOK)
+	defer secondary.Close()
+	fe, err := NewFailoverEndpoints(primary.URL, secondary.URL)
+	if err != nil {
+		t.Fatalf("autorest: NewFailoverEndpoints failed: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, primary.URL+"/item", nil)
+	resp, err := SendWithSender(&http.Client{}, req, DoFailover(fe))
+	if err != nil {
+		t.Fatalf("autorest: DoFailover failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || secondary.requests() != 1 {
+		t.Fatalf("autorest: DoFailover didn't fail over, status %d", resp.StatusCode)
+	}
+}
+
+func TestDoFailover_AllEndpointsFail(t *testing.T) {
+	primary := newFailoverServer(http.StatusInternalServerError)
+	defer primary.Close()
+	secondary := newFailoverServer(http.StatusBadGateway)
+	defer secondary.Close()
+	fe, err := NewFailoverEndpoints(primary.URL, secondary.URL)
+	if err != nil {
+		t.Fatalf("autorest: NewFailoverEndpoints failed: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, primary.URL+"/item", nil)
+	resp, err := SendWithSender(&http.Client{}, req, DoFailover(fe))
+	if err != nil {
+		t.Fatalf("autorest: DoFailover failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || primary.requests() != 1 || secondary.requests() != 1 {
+		t.Fatalf("autorest: DoFailover returned status %d", resp.StatusCode)
+	}
+	if healthy := fe.Healthy(); len(healthy) != 0 {
+		t.Fatalf("autorest: unexpected healthy endpoints %v", healthy)
+	}
+}
+
+func TestDoFailover_OtherHost(t *testing.T) {
+	other := newFailoverServer(http.StatusServiceUnavailable)
+	defer other.Close()
+	fe, err := NewFailoverEndpoints("https://primary.example", "https://secondary.example")
+	if err != nil {
+		t.Fatalf("autorest: NewFailoverEndpoints failed: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, other.URL+"/item", nil)
+	resp, err := SendWithSender(&http.Client{}, req, DoFailover(fe))
+	if err != nil {
+		t.Fatalf("autorest: DoFailover failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || other.requests() != 1 || len(fe.Healthy()) != 2 {
+		t.Fatalf("autorest: DoFailover changed a request to another host, status %d", resp.StatusCode)
+	}
+}
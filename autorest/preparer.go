@@ -34,6 +34,7 @@ const (
 
 	headerAuthorization    = "Authorization"
 	headerAuxAuthorization = "x-ms-authorization-auxiliary"
+	headerCorrelationID    = "x-ms-correlation-request-id"
 	headerContentType      = "Content-Type"
 	headerUserAgent        = "User-Agent"
 )